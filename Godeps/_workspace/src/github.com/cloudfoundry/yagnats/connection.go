@@ -2,6 +2,7 @@ package yagnats
 
 import (
 	"bufio"
+	"crypto/tls"
 	"errors"
 	"math/rand"
 	"net"
@@ -12,9 +13,10 @@ import (
 type Connection struct {
 	conn net.Conn
 
-	addr string
-	user string
-	pass string
+	addr      string
+	user      string
+	pass      string
+	tlsConfig *tls.Config
 
 	writeLock *sync.Mutex
 
@@ -57,14 +59,17 @@ func NewConnection(addr, user, pass string) *Connection {
 	}
 }
 
+// ConnectionInfo dials Addr over TLS when TLSConfig isn't nil.
 type ConnectionInfo struct {
-	Addr     string
-	Username string
-	Password string
+	Addr      string
+	Username  string
+	Password  string
+	TLSConfig *tls.Config
 }
 
 func (c *ConnectionInfo) ProvideConnection() (*Connection, error) {
 	conn := NewConnection(c.Addr, c.Username, c.Password)
+	conn.tlsConfig = c.TLSConfig
 
 	var err error
 
@@ -90,7 +95,13 @@ func (c *ConnectionCluster) ProvideConnection() (*Connection, error) {
 }
 
 func (c *Connection) Dial() error {
-	conn, err := net.Dial("tcp", c.addr)
+	var conn net.Conn
+	var err error
+	if c.tlsConfig == nil {
+		conn, err = net.Dial("tcp", c.addr)
+	} else {
+		conn, err = tls.Dial("tcp", c.addr, c.tlsConfig)
+	}
 	if err != nil {
 		return err
 	}
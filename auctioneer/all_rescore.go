@@ -19,8 +19,8 @@ func allRescoreAuction(client types.RepPoolClient, auctionRequest types.AuctionR
 		firstRoundReps := auctionRequest.RepGuids.RandomSubsetByFraction(auctionRequest.Rules.MaxBiddingPool)
 
 		//get everyone's score, if they're all full: bail
-		numCommunications += len(firstRoundReps)
-		firstRoundScores := client.Score(firstRoundReps, auctionRequest.Instance)
+		firstRoundScores, asked := Score(client, firstRoundReps, auctionRequest.Instance, scoreOptions(auctionRequest.Rules, firstRoundReps, auctionRequest.RepGuids))
+		numCommunications += asked
 		if firstRoundScores.AllFailed() {
 			continue
 		}
@@ -17,8 +17,8 @@ func allReserveAuction(client types.RepPoolClient, auctionRequest types.AuctionR
 		firstRoundReps := auctionRequest.RepGuids.RandomSubsetByFraction(auctionRequest.Rules.MaxBiddingPool)
 
 		//reserve everyone
-		numCommunications += len(firstRoundReps)
-		scores := client.ScoreThenTentativelyReserve(firstRoundReps, auctionRequest.Instance)
+		scores, asked := ScoreThenTentativelyReserve(client, firstRoundReps, auctionRequest.Instance, scoreOptions(auctionRequest.Rules, firstRoundReps, auctionRequest.RepGuids))
+		numCommunications += asked
 
 		if scores.AllFailed() {
 			continue
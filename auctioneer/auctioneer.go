@@ -20,6 +20,16 @@ func Auction(client types.RepPoolClient, auctionRequest types.AuctionRequest) ty
 		Instance: auctionRequest.Instance,
 	}
 
+	//leave out reps the client won't ask, unless that leaves nobody to ask
+	if filter, ok := client.(types.FilteringRepPoolClient); ok {
+		available := filter.Available(auctionRequest.RepGuids)
+		if len(available) > 0 {
+			auctionRequest.RepGuids = available
+		}
+	}
+
+	client, bids := tally(client)
+
 	t := time.Now()
 	switch auctionRequest.Rules.Algorithm {
 	case "all_rescore":
@@ -30,14 +40,19 @@ func Auction(client types.RepPoolClient, auctionRequest types.AuctionRequest) ty
 		result.Winner, result.NumRounds, result.NumCommunications = pickAmongBestAuction(client, auctionRequest)
 	case "pick_best":
 		result.Winner, result.NumRounds, result.NumCommunications = pickBestAuction(client, auctionRequest)
+	case "optimistic_pick_best":
+		result.Winner, result.NumRounds, result.NumCommunications = optimisticPickBestAuction(client, auctionRequest)
 	case "reserve_n_best":
 		result.Winner, result.NumRounds, result.NumCommunications = reserveNBestAuction(client, auctionRequest)
+	case "broadcast_reserve_n_best":
+		result.Winner, result.NumRounds, result.NumCommunications = broadcastReserveNBestAuction(client, auctionRequest)
 	case "random":
 		result.Winner, result.NumRounds, result.NumCommunications = randomAuction(client, auctionRequest)
 	default:
 		panic("unkown algorithm " + auctionRequest.Rules.Algorithm)
 	}
 	result.BiddingDuration = time.Since(t)
+	result.FailedBids = bids.FailedBids()
 
 	return result
 }
@@ -0,0 +1,36 @@
+package auctioneer_test
+
+import (
+	"sync"
+
+	. "github.com/onsi/auction/auctioneer"
+	"github.com/onsi/auction/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Auction", func() {
+	It("should count the bids that failed, by error", func() {
+		client := &slowClient{
+			full: map[string]bool{"rep-full": true},
+			busy: map[string]bool{"rep-busy": true},
+			lock: &sync.Mutex{},
+		}
+
+		result := Auction(client, types.AuctionRequest{
+			Instance: types.Instance{AppGuid: "app-guid", InstanceGuid: "instance-guid"},
+			RepGuids: types.RepGuids{"rep-full", "rep-busy"},
+			Rules: types.AuctionRules{
+				Algorithm:      "pick_best",
+				MaxRounds:      2,
+				MaxBiddingPool: 1,
+			},
+		})
+
+		Ω(result.Winner).Should(BeEmpty())
+		Ω(result.FailedBids).Should(Equal(map[types.ScoreError]int{
+			types.InsufficientResources: 2,
+			types.RepBusy:               2,
+		}))
+	})
+})
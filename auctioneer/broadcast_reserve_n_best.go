@@ -0,0 +1,90 @@
+package auctioneer
+
+import (
+	"math"
+
+	"github.com/onsi/auction/types"
+)
+
+/*
+
+Broadcast one score request to the whole pool
+	Keep the first (pool size x max bidding pool) scores to arrive
+		Tell the top 5 to reserve
+			Pick the best from that set and release the others
+
+Clients that can't broadcast fall back to scoring a random subset
+
+*/
+
+func broadcastReserveNBestAuction(client types.RepPoolClient, auctionRequest types.AuctionRequest) (string, int, int) {
+	rounds, numCommunications := 1, 0
+
+	k := int(math.Ceil(float64(len(auctionRequest.RepGuids)) * auctionRequest.Rules.MaxBiddingPool))
+	broadcaster, canBroadcast := client.(types.BroadcastRepPoolClient)
+
+	for ; rounds <= auctionRequest.Rules.MaxRounds; rounds++ {
+		//get the first k scores, if they're all full: bail
+		var firstRoundScores types.ScoreResults
+		if canBroadcast {
+			//one request out, and a reply from each rep we heard back from
+			firstRoundScores = onlyFrom(auctionRequest.RepGuids, broadcaster.BroadcastScore(k, auctionRequest.Instance))
+			numCommunications += 1 + len(firstRoundScores)
+		} else {
+			firstRoundReps := auctionRequest.RepGuids.RandomSubsetByFraction(auctionRequest.Rules.MaxBiddingPool)
+			var asked int
+			firstRoundScores, asked = Score(client, firstRoundReps, auctionRequest.Instance, scoreOptions(auctionRequest.Rules, firstRoundReps, auctionRequest.RepGuids))
+			numCommunications += asked
+		}
+		if firstRoundScores.AllFailed() {
+			continue
+		}
+
+		// pick the top 5 winners, keeping the runners up for any that are busy
+		ranked := firstRoundScores.FilterErrors().Shuffle().Sort()
+		max := 5
+		if len(ranked) < max {
+			max = len(ranked)
+		}
+		winners, runnersUp := ranked[:max], ranked[max:]
+
+		//ask them to reserve
+		winners, asked := ScoreThenTentativelyReserve(client, winners.Reps(), auctionRequest.Instance, ScoreOptions{Hedges: runnersUp.Reps()})
+		numCommunications += asked
+		//if they're all out of space, try again
+		if winners.AllFailed() {
+			continue
+		}
+
+		//order by score: the first is the winner, all others release
+		orderedReps := winners.FilterErrors().Shuffle().Sort().Reps()
+
+		numCommunications += len(winners)
+		client.Claim(orderedReps[0], auctionRequest.Instance)
+		if len(orderedReps) > 1 {
+			client.ReleaseReservation(orderedReps[1:], auctionRequest.Instance)
+		}
+
+		return orderedReps[0], rounds, numCommunications
+	}
+
+	return "", rounds, numCommunications
+}
+
+// onlyFrom drops scores from reps that aren't taking part in the auction: a
+// broadcast reaches every rep listening, not just the ones we were given.
+func onlyFrom(guids types.RepGuids, scores types.ScoreResults) types.ScoreResults {
+	participating := map[string]bool{}
+	for _, guid := range guids {
+		participating[guid] = true
+	}
+
+	out := types.ScoreResults{}
+	for _, score := range scores {
+		if participating[score.Rep] {
+			out = append(out, score)
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,43 @@
+package auctioneer
+
+import "github.com/onsi/auction/types"
+
+/*
+
+Get the scores from the subset of reps
+	Tell the best to reserve, provided its state hasn't changed since it scored
+		If it has (or it filled up) move on to the runner up without rescoring
+
+*/
+
+func optimisticPickBestAuction(client types.RepPoolClient, auctionRequest types.AuctionRequest) (string, int, int) {
+	rounds, numCommunications := 1, 0
+
+	for ; rounds <= auctionRequest.Rules.MaxRounds; rounds++ {
+		//pick a subset
+		firstRoundReps := auctionRequest.RepGuids.RandomSubsetByFraction(auctionRequest.Rules.MaxBiddingPool)
+
+		//get everyone's score, if they're all full: bail
+		firstRoundScores, asked := Score(client, firstRoundReps, auctionRequest.Instance, scoreOptions(auctionRequest.Rules, firstRoundReps, auctionRequest.RepGuids))
+		numCommunications += asked
+		if firstRoundScores.AllFailed() {
+			continue
+		}
+
+		//walk down the ranking until someone reserves against the state they scored with
+		for _, candidate := range firstRoundScores.FilterErrors().Shuffle().Sort() {
+			numCommunications += 1
+			result := client.TentativelyReserveIfUnchanged(types.ScoreResults{candidate}, auctionRequest.Instance)[0]
+			if result.Error != "" {
+				continue
+			}
+
+			client.Claim(candidate.Rep, auctionRequest.Instance)
+			numCommunications += 1
+
+			return candidate.Rep, rounds, numCommunications
+		}
+	}
+
+	return "", rounds, numCommunications
+}
@@ -18,13 +18,16 @@ func pickAmongBestAuction(client types.RepPoolClient, auctionRequest types.Aucti
 		firstRoundReps := auctionRequest.RepGuids.RandomSubsetByFraction(auctionRequest.Rules.MaxBiddingPool)
 
 		//get everyone's score, if they're all full: bail
-		numCommunications += len(firstRoundReps)
-		firstRoundScores := client.Score(firstRoundReps, auctionRequest.Instance)
+		firstRoundScores, asked := Score(client, firstRoundReps, auctionRequest.Instance, scoreOptions(auctionRequest.Rules, firstRoundReps, auctionRequest.RepGuids))
+		numCommunications += asked
 		if firstRoundScores.AllFailed() {
 			continue
 		}
 
-		top5Winners := firstRoundScores.FilterErrors().Shuffle().Sort()[:5]
+		top5Winners := firstRoundScores.FilterErrors().Shuffle().Sort()
+		if len(top5Winners) > 5 {
+			top5Winners = top5Winners[:5]
+		}
 
 		winner := top5Winners.Shuffle()[0]
 
@@ -17,8 +17,8 @@ func pickBestAuction(client types.RepPoolClient, auctionRequest types.AuctionReq
 		firstRoundReps := auctionRequest.RepGuids.RandomSubsetByFraction(auctionRequest.Rules.MaxBiddingPool)
 
 		//get everyone's score, if they're all full: bail
-		numCommunications += len(firstRoundReps)
-		firstRoundScores := client.Score(firstRoundReps, auctionRequest.Instance)
+		firstRoundScores, asked := Score(client, firstRoundReps, auctionRequest.Instance, scoreOptions(auctionRequest.Rules, firstRoundReps, auctionRequest.RepGuids))
+		numCommunications += asked
 		if firstRoundScores.AllFailed() {
 			continue
 		}
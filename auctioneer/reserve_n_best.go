@@ -18,23 +18,23 @@ func reserveNBestAuction(client types.RepPoolClient, auctionRequest types.Auctio
 		firstRoundReps := auctionRequest.RepGuids.RandomSubsetByFraction(auctionRequest.Rules.MaxBiddingPool)
 
 		//get everyone's score, if they're all full: bail
-		numCommunications += len(firstRoundReps)
-		firstRoundScores := client.Score(firstRoundReps, auctionRequest.Instance)
+		firstRoundScores, asked := Score(client, firstRoundReps, auctionRequest.Instance, scoreOptions(auctionRequest.Rules, firstRoundReps, auctionRequest.RepGuids))
+		numCommunications += asked
 		if firstRoundScores.AllFailed() {
 			continue
 		}
 
-		// pick the top 5 winners
-		winners := firstRoundScores.FilterErrors().Shuffle().Sort()
+		// pick the top 5 winners, keeping the runners up for any that are busy
+		ranked := firstRoundScores.FilterErrors().Shuffle().Sort()
 		max := 5
-		if len(winners) < max {
-			max = len(winners)
+		if len(ranked) < max {
+			max = len(ranked)
 		}
-		winners = winners[:max]
+		winners, runnersUp := ranked[:max], ranked[max:]
 
 		//ask them to reserve
-		numCommunications += len(winners)
-		winners = client.ScoreThenTentativelyReserve(winners.Reps(), auctionRequest.Instance)
+		winners, asked = ScoreThenTentativelyReserve(client, winners.Reps(), auctionRequest.Instance, ScoreOptions{Hedges: runnersUp.Reps()})
+		numCommunications += asked
 		//if they're all out of space, try again
 		if winners.AllFailed() {
 			continue
@@ -0,0 +1,185 @@
+package auctioneer
+
+import (
+	"math"
+	"time"
+
+	"github.com/onsi/auction/types"
+)
+
+// ScoreOptions let a round of scoring finish without waiting on its slowest
+// reps, and stand in for reps that are too busy to bid.
+type ScoreOptions struct {
+	// Quorum is the number of reps that must bid successfully before the
+	// round finishes; zero means every rep asked.  The round also finishes
+	// once every rep asked (hedges included) has answered.
+	Quorum int
+
+	// HedgeAfter is how long to wait for the quorum before asking Hedges to
+	// bid as well: one hedge for every rep that hasn't answered yet.  Zero
+	// never hedges.
+	HedgeAfter time.Duration
+
+	// Hedges are asked, in order, in place of reps that are slow or that
+	// answer types.RepBusy.  A busy rep isn't full, so its bid doesn't count
+	// towards the quorum and doesn't stop the round from finding a home.
+	Hedges types.RepGuids
+}
+
+// Score asks guids to score instance as client.Score does, subject to the
+// options, and returns the results that arrived before the round finished,
+// in the order the reps were asked, along with the number of reps asked.
+// Reps that hadn't answered are left out.
+//
+// Without a quorum or hedging this is just client.Score, followed by one more
+// batch to ask hedges in place of any busy reps.  Otherwise each rep is asked
+// on its own, so that the results can be counted as they arrive.
+func Score(client types.RepPoolClient, guids []string, instance types.Instance, options ScoreOptions) (types.ScoreResults, int) {
+	if options.Quorum == 0 && options.HedgeAfter == 0 {
+		return batch(guids, options.Hedges, func(guids []string) types.ScoreResults {
+			return client.Score(guids, instance)
+		})
+	}
+
+	return gather(guids, options, func(guid string) types.ScoreResult {
+		return client.Score([]string{guid}, instance)[0]
+	}, nil)
+}
+
+// ScoreThenTentativelyReserve is Score for client.ScoreThenTentativelyReserve.
+// Reps that reserve after the round has finished are told to release their
+// reservations, since nobody will claim them.
+func ScoreThenTentativelyReserve(client types.RepPoolClient, guids []string, instance types.Instance, options ScoreOptions) (types.ScoreResults, int) {
+	if options.Quorum == 0 && options.HedgeAfter == 0 {
+		return batch(guids, options.Hedges, func(guids []string) types.ScoreResults {
+			return client.ScoreThenTentativelyReserve(guids, instance)
+		})
+	}
+
+	return gather(guids, options, func(guid string) types.ScoreResult {
+		return client.ScoreThenTentativelyReserve([]string{guid}, instance)[0]
+	}, func(result types.ScoreResult) {
+		client.ReleaseReservation([]string{result.Rep}, instance)
+	})
+}
+
+// batch asks guids with request all at once, then asks as many hedges as there
+// were busy reps.
+func batch(guids []string, hedges types.RepGuids, request func(guids []string) types.ScoreResults) (types.ScoreResults, int) {
+	results := request(guids)
+	asked := len(guids)
+
+	busy := len(results.Busy())
+	if busy > len(hedges) {
+		busy = len(hedges)
+	}
+	if busy > 0 {
+		results = append(results, request(hedges[:busy])...)
+		asked += busy
+	}
+
+	return results, asked
+}
+
+type indexedResult struct {
+	index  int
+	result types.ScoreResult
+}
+
+// gather asks each rep with request, concurrently, until the round finishes.
+// late, if not nil, hears about the successful bids that arrive afterwards.
+func gather(guids []string, options ScoreOptions, request func(guid string) types.ScoreResult, late func(types.ScoreResult)) (types.ScoreResults, int) {
+	quorum := options.Quorum
+	if quorum == 0 {
+		quorum = len(guids)
+	}
+
+	asked := []string{}
+	arrived := make(chan indexedResult, len(guids)+len(options.Hedges))
+	ask := func(guid string) {
+		index := len(asked)
+		asked = append(asked, guid)
+		go func() {
+			arrived <- indexedResult{index: index, result: request(guid)}
+		}()
+	}
+
+	hedges := options.Hedges
+	askHedge := func() {
+		if len(hedges) > 0 {
+			ask(hedges[0])
+			hedges = hedges[1:]
+		}
+	}
+
+	for _, guid := range guids {
+		ask(guid)
+	}
+
+	var hedge <-chan time.Time
+	if options.HedgeAfter > 0 && len(options.Hedges) > 0 {
+		timer := time.NewTimer(options.HedgeAfter)
+		defer timer.Stop()
+		hedge = timer.C
+	}
+
+	received := map[int]types.ScoreResult{}
+	successes := 0
+	for successes < quorum && len(received) < len(asked) {
+		select {
+		case r := <-arrived:
+			received[r.index] = r.result
+			switch r.result.Error {
+			case "":
+				successes++
+			case types.RepBusy:
+				askHedge()
+			}
+
+		case <-hedge:
+			hedge = nil
+			outstanding := len(asked) - len(received)
+			for i := 0; i < outstanding; i++ {
+				askHedge()
+			}
+		}
+	}
+
+	if late != nil {
+		go func(outstanding int) {
+			for i := 0; i < outstanding; i++ {
+				r := <-arrived
+				if r.result.Error == "" {
+					late(r.result)
+				}
+			}
+		}(len(asked) - len(received))
+	}
+
+	results := types.ScoreResults{}
+	for index := range asked {
+		if result, ok := received[index]; ok {
+			results = append(results, result)
+		}
+	}
+
+	return results, len(asked)
+}
+
+// scoreOptions turns the auction's rules into options for a round in which
+// guids are asked to bid, hedging with the other reps from the pool in a random
+// order.
+func scoreOptions(rules types.AuctionRules, guids types.RepGuids, pool types.RepGuids) ScoreOptions {
+	hedges := pool.Without(guids...)
+
+	options := ScoreOptions{
+		HedgeAfter: rules.HedgeAfter,
+		Hedges:     hedges.RandomSubsetByCount(len(hedges)),
+	}
+
+	if rules.ScoreQuorum > 0 {
+		options.Quorum = int(math.Ceil(float64(len(guids)) * rules.ScoreQuorum))
+	}
+
+	return options
+}
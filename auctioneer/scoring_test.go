@@ -0,0 +1,185 @@
+package auctioneer_test
+
+import (
+	"sync"
+	"time"
+
+	. "github.com/onsi/auction/auctioneer"
+	"github.com/onsi/auction/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// slowClient has each rep answer after its delay (immediately by default) and
+// fail if it is full or busy.  It records who was asked and who released.
+type slowClient struct {
+	types.RepPoolClient
+
+	delays map[string]time.Duration
+	full   map[string]bool
+	busy   map[string]bool
+
+	lock     *sync.Mutex
+	asked    []string
+	released []string
+}
+
+func (c *slowClient) bid(guids []string) types.ScoreResults {
+	results := types.ScoreResults{}
+	for _, guid := range guids {
+		c.lock.Lock()
+		c.asked = append(c.asked, guid)
+		c.lock.Unlock()
+
+		time.Sleep(c.delays[guid])
+
+		result := types.ScoreResult{Rep: guid, Score: 0.5}
+		if c.full[guid] {
+			result.Error = types.InsufficientResources
+		}
+		if c.busy[guid] {
+			result.Error = types.RepBusy
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func (c *slowClient) Score(guids []string, instance types.Instance) types.ScoreResults {
+	return c.bid(guids)
+}
+
+func (c *slowClient) ScoreThenTentativelyReserve(guids []string, instance types.Instance) types.ScoreResults {
+	return c.bid(guids)
+}
+
+func (c *slowClient) ReleaseReservation(guids []string, instance types.Instance) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.released = append(c.released, guids...)
+}
+
+func (c *slowClient) Asked() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]string{}, c.asked...)
+}
+
+func (c *slowClient) Released() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]string{}, c.released...)
+}
+
+var _ = Describe("Scoring with options", func() {
+	var client *slowClient
+	var instance types.Instance
+
+	BeforeEach(func() {
+		client = &slowClient{
+			delays: map[string]time.Duration{"rep-slow": time.Second},
+			full:   map[string]bool{},
+			busy:   map[string]bool{},
+			lock:   &sync.Mutex{},
+		}
+		instance = types.Instance{AppGuid: "app-guid", InstanceGuid: "instance-guid"}
+	})
+
+	It("should wait for every rep without options", func() {
+		t := time.Now()
+		results, asked := Score(client, []string{"rep-a", "rep-slow"}, instance, ScoreOptions{})
+		Ω(time.Since(t)).Should(BeNumerically(">=", time.Second))
+		Ω(results.Reps()).Should(Equal(types.RepGuids{"rep-a", "rep-slow"}))
+		Ω(asked).Should(Equal(2))
+	})
+
+	It("should finish once the quorum has bid, leaving out the reps that haven't answered", func() {
+		t := time.Now()
+		results, asked := Score(client, []string{"rep-slow", "rep-a", "rep-b"}, instance, ScoreOptions{Quorum: 2})
+		Ω(time.Since(t)).Should(BeNumerically("<", 500*time.Millisecond))
+		Ω(results.Reps()).Should(Equal(types.RepGuids{"rep-a", "rep-b"}))
+		Ω(asked).Should(Equal(3))
+	})
+
+	It("should not count failed bids towards the quorum", func() {
+		client.full["rep-b"] = true
+		client.delays["rep-slow"] = 100 * time.Millisecond
+
+		results, _ := Score(client, []string{"rep-a", "rep-b", "rep-slow"}, instance, ScoreOptions{Quorum: 2})
+		Ω(results.Reps()).Should(Equal(types.RepGuids{"rep-a", "rep-b", "rep-slow"}))
+		Ω(results.FilterErrors()).Should(HaveLen(2))
+	})
+
+	It("should finish when everyone has answered, even without a quorum", func() {
+		client.full["rep-a"] = true
+
+		results, _ := Score(client, []string{"rep-a"}, instance, ScoreOptions{Quorum: 1})
+		Ω(results.Reps()).Should(Equal(types.RepGuids{"rep-a"}))
+		Ω(results.AllFailed()).Should(BeTrue())
+	})
+
+	It("should hedge with one more rep for every rep that is slow", func() {
+		t := time.Now()
+		results, asked := Score(client, []string{"rep-a", "rep-slow"}, instance, ScoreOptions{
+			HedgeAfter: 50 * time.Millisecond,
+			Hedges:     types.RepGuids{"rep-hedge"},
+		})
+		Ω(time.Since(t)).Should(BeNumerically("<", 500*time.Millisecond))
+		Ω(time.Since(t)).Should(BeNumerically(">=", 50*time.Millisecond))
+		Ω(results.Reps()).Should(Equal(types.RepGuids{"rep-a", "rep-hedge"}))
+		Ω(asked).Should(Equal(3))
+	})
+
+	It("should not hedge when the reps answer in time", func() {
+		results, asked := Score(client, []string{"rep-a", "rep-b"}, instance, ScoreOptions{
+			HedgeAfter: 50 * time.Millisecond,
+			Hedges:     types.RepGuids{"rep-hedge"},
+		})
+		Ω(results.Reps()).Should(Equal(types.RepGuids{"rep-a", "rep-b"}))
+		Ω(asked).Should(Equal(2))
+		Consistently(client.Asked, 100*time.Millisecond).ShouldNot(ContainElement("rep-hedge"))
+	})
+
+	It("should release reservations that arrive after the round has finished", func() {
+		client.delays["rep-slow"] = 100 * time.Millisecond
+
+		results, _ := ScoreThenTentativelyReserve(client, []string{"rep-a", "rep-slow"}, instance, ScoreOptions{Quorum: 1})
+		Ω(results.Reps()).Should(Equal(types.RepGuids{"rep-a"}))
+		Ω(client.Released()).Should(BeEmpty())
+
+		Eventually(client.Released).Should(Equal([]string{"rep-slow"}))
+	})
+
+	Context("when reps are busy", func() {
+		BeforeEach(func() {
+			client.busy["rep-busy"] = true
+		})
+
+		It("should ask hedges in their place, in order", func() {
+			results, asked := Score(client, []string{"rep-a", "rep-busy"}, instance, ScoreOptions{
+				Hedges: types.RepGuids{"rep-hedge", "rep-unasked"},
+			})
+			Ω(results.Reps()).Should(Equal(types.RepGuids{"rep-a", "rep-busy", "rep-hedge"}))
+			Ω(results.FilterErrors()).Should(HaveLen(2))
+			Ω(asked).Should(Equal(3))
+			Ω(client.Asked()).ShouldNot(ContainElement("rep-unasked"))
+		})
+
+		It("should not count busy bids towards the quorum", func() {
+			client.delays["rep-slow"] = 100 * time.Millisecond
+
+			results, asked := Score(client, []string{"rep-busy", "rep-slow"}, instance, ScoreOptions{
+				Quorum: 1,
+				Hedges: types.RepGuids{"rep-hedge"},
+			})
+			Ω(results.Reps()).Should(Equal(types.RepGuids{"rep-busy", "rep-hedge"}))
+			Ω(asked).Should(Equal(3))
+		})
+
+		It("should settle for the busy bids when there are no hedges left", func() {
+			results, asked := ScoreThenTentativelyReserve(client, []string{"rep-busy"}, instance, ScoreOptions{Quorum: 1})
+			Ω(results.Busy().Reps()).Should(Equal(types.RepGuids{"rep-busy"}))
+			Ω(asked).Should(Equal(1))
+		})
+	})
+})
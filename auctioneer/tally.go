@@ -0,0 +1,84 @@
+package auctioneer
+
+import (
+	"sync"
+
+	"github.com/onsi/auction/types"
+)
+
+// tallyingClient counts the failed bids in the results its client returns, by
+// error, so that an auction can report why bids failed.  Bids may come back
+// concurrently, and even after the auction is over.
+type tallyingClient struct {
+	types.RepPoolClient
+
+	lock   *sync.Mutex
+	failed map[types.ScoreError]int
+}
+
+// tallyingBroadcastClient keeps the client's ability to broadcast.
+type tallyingBroadcastClient struct {
+	*tallyingClient
+	broadcaster types.BroadcastRepPoolClient
+}
+
+// tally wraps client, returning the wrapped client and its tally.
+func tally(client types.RepPoolClient) (types.RepPoolClient, *tallyingClient) {
+	tally := &tallyingClient{
+		RepPoolClient: client,
+		lock:          &sync.Mutex{},
+		failed:        map[types.ScoreError]int{},
+	}
+
+	if broadcaster, ok := client.(types.BroadcastRepPoolClient); ok {
+		return &tallyingBroadcastClient{tallyingClient: tally, broadcaster: broadcaster}, tally
+	}
+
+	return tally, tally
+}
+
+func (c *tallyingClient) count(results types.ScoreResults) types.ScoreResults {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, result := range results {
+		if result.Error != "" {
+			c.failed[result.Error]++
+		}
+	}
+
+	return results
+}
+
+// FailedBids is nil if no bids failed.
+func (c *tallyingClient) FailedBids() map[types.ScoreError]int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if len(c.failed) == 0 {
+		return nil
+	}
+
+	failed := map[types.ScoreError]int{}
+	for code, count := range c.failed {
+		failed[code] = count
+	}
+
+	return failed
+}
+
+func (c *tallyingClient) Score(guids []string, instance types.Instance) types.ScoreResults {
+	return c.count(c.RepPoolClient.Score(guids, instance))
+}
+
+func (c *tallyingClient) ScoreThenTentativelyReserve(guids []string, instance types.Instance) types.ScoreResults {
+	return c.count(c.RepPoolClient.ScoreThenTentativelyReserve(guids, instance))
+}
+
+func (c *tallyingClient) TentativelyReserveIfUnchanged(scores types.ScoreResults, instance types.Instance) types.ScoreResults {
+	return c.count(c.RepPoolClient.TentativelyReserveIfUnchanged(scores, instance))
+}
+
+func (c *tallyingBroadcastClient) BroadcastScore(k int, instance types.Instance) types.ScoreResults {
+	return c.count(c.broadcaster.BroadcastScore(k, instance))
+}
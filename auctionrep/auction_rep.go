@@ -6,6 +6,9 @@ import (
 	"github.com/onsi/auction/types"
 )
 
+// Read-only methods (RemainingResources, TotalResources, NumInstancesForAppGuid)
+// may be called concurrently and must be safe to do so.  Methods that mutate
+// state are never called concurrently with one another or with reads.
 type AuctionRepDelegate interface {
 	RemainingResources() types.Resources
 	TotalResources() types.Resources
@@ -24,16 +27,17 @@ type SimulationAuctionRepDelegate interface {
 }
 
 type AuctionRep struct {
-	guid     string
-	delegate AuctionRepDelegate
-	lock     *sync.Mutex
+	guid       string
+	delegate   AuctionRepDelegate
+	lock       *sync.RWMutex
+	generation uint64
 }
 
 func New(guid string, delegate AuctionRepDelegate) *AuctionRep {
 	return &AuctionRep{
 		guid:     guid,
 		delegate: delegate,
-		lock:     &sync.Mutex{},
+		lock:     &sync.RWMutex{},
 	}
 }
 
@@ -41,51 +45,62 @@ func (rep *AuctionRep) Guid() string {
 	return rep.guid
 }
 
-func (rep *AuctionRep) Score(instance types.Instance) (float64, error) {
-	rep.lock.Lock()
-	defer rep.lock.Unlock()
+// Score only reads state so any number of Score calls can run at once.
+// Reservations, releases and claims take the write lock and wait for in-flight scores.
+//
+// The returned generation identifies the state the score was computed against and
+// can be handed back to TentativelyReserveIfUnchanged.
+func (rep *AuctionRep) Score(instance types.Instance) (float64, uint64, error) {
+	rep.lock.RLock()
+	defer rep.lock.RUnlock()
 
 	remaining := rep.delegate.RemainingResources()
-	if !rep.hasRoomFor(instance.Resources, remaining) {
-		return 0, types.InsufficientResources
+	err := rep.roomFor(instance.Resources, remaining)
+	if err != nil {
+		return 0, rep.generation, err
 	}
 
 	total := rep.delegate.TotalResources()
 	nInstances := rep.delegate.NumInstancesForAppGuid(instance.AppGuid)
 
-	return rep.score(remaining, total, nInstances), nil
+	return rep.score(remaining, total, nInstances), rep.generation, nil
 }
 
 func (rep *AuctionRep) ScoreThenTentativelyReserve(instance types.Instance) (float64, error) {
 	rep.lock.Lock()
 	defer rep.lock.Unlock()
 
-	remaining := rep.delegate.RemainingResources()
-	if !rep.hasRoomFor(instance.Resources, remaining) {
-		return 0, types.InsufficientResources
-	}
+	return rep.scoreThenTentativelyReserve(instance)
+}
 
-	//score first
-	total := rep.delegate.TotalResources()
-	nInstances := rep.delegate.NumInstancesForAppGuid(instance.AppGuid)
-	score := rep.score(remaining, total, nInstances)
+// TentativelyReserveIfUnchanged reserves only if nothing has changed since the
+// score that handed out generation.  Otherwise it returns types.StaleScore.
+func (rep *AuctionRep) TentativelyReserveIfUnchanged(instance types.Instance, generation uint64) (float64, error) {
+	rep.lock.Lock()
+	defer rep.lock.Unlock()
 
-	//then reserve
-	err := rep.delegate.Reserve(instance)
-	if err != nil {
-		return 0, err
+	if generation != rep.generation {
+		return 0, types.StaleScore
 	}
 
-	return score, nil
+	return rep.scoreThenTentativelyReserve(instance)
 }
 
 func (rep *AuctionRep) ReleaseReservation(instance types.Instance) error {
 	rep.lock.Lock()
 	defer rep.lock.Unlock()
 
-	return rep.delegate.ReleaseReservation(instance)
+	err := rep.delegate.ReleaseReservation(instance)
+	if err != nil {
+		return err
+	}
+	rep.generation++
+
+	return nil
 }
 
+// Claiming does not change the resources a reservation already accounts for,
+// so it leaves the generation alone.
 func (rep *AuctionRep) Claim(instance types.Instance) error {
 	rep.lock.Lock()
 	defer rep.lock.Unlock()
@@ -106,6 +121,7 @@ func (rep *AuctionRep) Reset() {
 		println("not reseting")
 		return
 	}
+	rep.generation++
 	simDelegate.SetInstances([]types.Instance{})
 }
 
@@ -118,12 +134,13 @@ func (rep *AuctionRep) SetInstances(instances []types.Instance) {
 		println("not setting instances")
 		return
 	}
+	rep.generation++
 	simDelegate.SetInstances(instances)
 }
 
 func (rep *AuctionRep) Instances() []types.Instance {
-	rep.lock.Lock()
-	defer rep.lock.Unlock()
+	rep.lock.RLock()
+	defer rep.lock.RUnlock()
 
 	simDelegate, ok := rep.delegate.(SimulationAuctionRepDelegate)
 	if !ok {
@@ -135,12 +152,40 @@ func (rep *AuctionRep) Instances() []types.Instance {
 
 // internals -- no locks here the operations above should be atomic
 
-func (rep *AuctionRep) hasRoomFor(required types.Resources, remaining types.Resources) bool {
-	hasEnoughMemory := remaining.MemoryMB >= required.MemoryMB
-	hasEnoughDisk := remaining.DiskMB >= required.DiskMB
-	hasEnoughContainers := remaining.Containers > 0
+func (rep *AuctionRep) scoreThenTentativelyReserve(instance types.Instance) (float64, error) {
+	remaining := rep.delegate.RemainingResources()
+	err := rep.roomFor(instance.Resources, remaining)
+	if err != nil {
+		return 0, err
+	}
+
+	//score first
+	total := rep.delegate.TotalResources()
+	nInstances := rep.delegate.NumInstancesForAppGuid(instance.AppGuid)
+	score := rep.score(remaining, total, nInstances)
+
+	//then reserve
+	err = rep.delegate.Reserve(instance)
+	if err != nil {
+		return 0, err
+	}
+	rep.generation++
+
+	return score, nil
+}
+
+// roomFor says which resource, if any, the rep is short of.
+func (rep *AuctionRep) roomFor(required types.Resources, remaining types.Resources) error {
+	switch {
+	case remaining.MemoryMB < required.MemoryMB:
+		return types.InsufficientMemory
+	case remaining.DiskMB < required.DiskMB:
+		return types.InsufficientDisk
+	case remaining.Containers <= 0:
+		return types.InsufficientContainers
+	}
 
-	return hasEnoughMemory && hasEnoughDisk && hasEnoughContainers
+	return nil
 }
 
 func (rep *AuctionRep) score(remaining types.Resources, total types.Resources, nInstances int) float64 {
@@ -0,0 +1,186 @@
+package auctionrep_test
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	. "github.com/onsi/auction/auctionrep"
+	"github.com/onsi/auction/auctionrep/fakedelegate"
+	"github.com/onsi/auction/simulation/simulationrepdelegate"
+	"github.com/onsi/auction/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AuctionRep", func() {
+	var rep *AuctionRep
+
+	newInstance := func(i int) types.Instance {
+		return types.Instance{
+			AppGuid:      "app",
+			InstanceGuid: fmt.Sprintf("instance-%d", i),
+			Resources: types.Resources{
+				MemoryMB: 1,
+				DiskMB:   1,
+			},
+		}
+	}
+
+	Describe("scoring", func() {
+		It("should allow scores to be computed concurrently", func() {
+			arrived := int32(0)
+			release := make(chan struct{})
+			rep = New("rep", fakedelegate.BlockingDelegate{Arrived: &arrived, Release: release})
+
+			wg := &sync.WaitGroup{}
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					rep.Score(newInstance(i))
+				}(i)
+			}
+
+			Eventually(func() int32 {
+				return atomic.LoadInt32(&arrived)
+			}).Should(BeNumerically("==", 10))
+
+			close(release)
+			wg.Wait()
+		})
+
+		It("should say which resource the rep is short of", func() {
+			rep = New("rep", simulationrepdelegate.New(types.Resources{
+				MemoryMB:   100,
+				DiskMB:     100,
+				Containers: 1,
+			}))
+
+			instance := newInstance(0)
+			instance.Resources.MemoryMB = 101
+			_, _, err := rep.Score(instance)
+			Ω(err).Should(Equal(types.InsufficientMemory))
+
+			instance.Resources = types.Resources{MemoryMB: 1, DiskMB: 101}
+			_, _, err = rep.Score(instance)
+			Ω(err).Should(Equal(types.InsufficientDisk))
+
+			_, err = rep.ScoreThenTentativelyReserve(newInstance(1))
+			Ω(err).ShouldNot(HaveOccurred())
+			_, err = rep.ScoreThenTentativelyReserve(newInstance(2))
+			Ω(err).Should(Equal(types.InsufficientContainers))
+		})
+	})
+
+	Describe("reserving against a generation", func() {
+		BeforeEach(func() {
+			rep = New("rep", simulationrepdelegate.New(types.Resources{
+				MemoryMB:   100,
+				DiskMB:     100,
+				Containers: 50,
+			}))
+		})
+
+		It("should reserve when nothing has changed since the score", func() {
+			score, generation, err := rep.Score(newInstance(0))
+			Ω(err).ShouldNot(HaveOccurred())
+
+			recast, err := rep.TentativelyReserveIfUnchanged(newInstance(0), generation)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(recast).Should(Equal(score))
+			Ω(rep.Instances()).Should(HaveLen(1))
+		})
+
+		It("should return a stale error when the rep has changed since the score", func() {
+			_, generation, err := rep.Score(newInstance(0))
+			Ω(err).ShouldNot(HaveOccurred())
+
+			_, err = rep.ScoreThenTentativelyReserve(newInstance(1))
+			Ω(err).ShouldNot(HaveOccurred())
+
+			_, err = rep.TentativelyReserveIfUnchanged(newInstance(0), generation)
+			Ω(err).Should(Equal(types.StaleScore))
+			Ω(rep.Instances()).Should(HaveLen(1))
+		})
+
+		It("should not consider other scores or claims a change", func() {
+			_, err := rep.ScoreThenTentativelyReserve(newInstance(1))
+			Ω(err).ShouldNot(HaveOccurred())
+
+			_, generation, err := rep.Score(newInstance(0))
+			Ω(err).ShouldNot(HaveOccurred())
+
+			rep.Score(newInstance(2))
+			Ω(rep.Claim(newInstance(1))).ShouldNot(HaveOccurred())
+
+			_, err = rep.TentativelyReserveIfUnchanged(newInstance(0), generation)
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+	})
+
+	Describe("under concurrent load", func() {
+		var delegate SimulationAuctionRepDelegate
+
+		BeforeEach(func() {
+			delegate = simulationrepdelegate.New(types.Resources{
+				MemoryMB:   100,
+				DiskMB:     100,
+				Containers: 50,
+			})
+			rep = New("rep", delegate)
+		})
+
+		It("should hold exactly the instances it reserved and didn't release", func() {
+			lock := &sync.Mutex{}
+			held := map[string]bool{}
+
+			wg := &sync.WaitGroup{}
+			for i := 0; i < 200; i++ {
+				wg.Add(2)
+				go func(i int) {
+					defer GinkgoRecover()
+					defer wg.Done()
+					instance := newInstance(i)
+					_, err := rep.ScoreThenTentativelyReserve(instance)
+					if err != nil {
+						Ω(err.(types.ScoreError).Insufficient()).Should(BeTrue())
+						return
+					}
+
+					if i%3 == 0 {
+						Ω(rep.ReleaseReservation(instance)).ShouldNot(HaveOccurred())
+						return
+					}
+
+					Ω(rep.Claim(instance)).ShouldNot(HaveOccurred())
+					lock.Lock()
+					held[instance.InstanceGuid] = true
+					lock.Unlock()
+				}(i)
+
+				go func(i int) {
+					defer GinkgoRecover()
+					defer wg.Done()
+					score, _, err := rep.Score(newInstance(i))
+					if err == nil {
+						Ω(score).Should(BeNumerically(">=", 0))
+					}
+					rep.Instances()
+				}(i)
+			}
+			wg.Wait()
+
+			instances := rep.Instances()
+			Ω(instances).Should(HaveLen(len(held)))
+			for _, instance := range instances {
+				Ω(held).Should(HaveKey(instance.InstanceGuid))
+			}
+
+			remaining := delegate.RemainingResources()
+			Ω(remaining.Containers).Should(Equal(50 - len(held)))
+			Ω(remaining.MemoryMB).Should(Equal(100 - float64(len(held))))
+			Ω(remaining.DiskMB).Should(Equal(100 - float64(len(held))))
+		})
+	})
+})
@@ -0,0 +1,13 @@
+package auctionrep_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestAuctionRep(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "AuctionRep Suite")
+}
@@ -0,0 +1,31 @@
+// Package fakedelegate has auctionrep delegates for the tests of reps and of
+// the servers that front them.
+package fakedelegate
+
+import (
+	"sync/atomic"
+
+	"github.com/onsi/auction/types"
+)
+
+// BlockingDelegate counts every RemainingResources call in Arrived and parks
+// it until Release is closed.  Delegates may share Arrived and Release.
+type BlockingDelegate struct {
+	Arrived *int32
+	Release chan struct{}
+}
+
+func (d BlockingDelegate) RemainingResources() types.Resources {
+	atomic.AddInt32(d.Arrived, 1)
+	<-d.Release
+	return types.Resources{MemoryMB: 100, DiskMB: 100, Containers: 100}
+}
+
+func (d BlockingDelegate) TotalResources() types.Resources {
+	return types.Resources{MemoryMB: 100, DiskMB: 100, Containers: 100}
+}
+
+func (d BlockingDelegate) NumInstancesForAppGuid(guid string) int           { return 0 }
+func (d BlockingDelegate) Reserve(instance types.Instance) error            { return nil }
+func (d BlockingDelegate) ReleaseReservation(instance types.Instance) error { return nil }
+func (d BlockingDelegate) Claim(instance types.Instance) error              { return nil }
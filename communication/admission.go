@@ -0,0 +1,71 @@
+package communication
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// Admission limits the bids (scores and reservations) a rep works on at once,
+// so that a storm of auctions can't slow every one of them down.  Up to
+// maxConcurrent bids run at once and up to maxQueued more wait for them; any
+// more are turned away and the rep answers that it is busy (types.RepBusy).
+// One Admission should be shared by all of a rep's servers.  A nil Admission
+// admits everything.
+//
+// maxConcurrent must be at least 1 (no bid could ever run otherwise) and
+// maxQueued can't be negative.
+type Admission struct {
+	busy     uint64
+	running  chan struct{}
+	admitted chan struct{}
+}
+
+func NewAdmission(maxConcurrent int, maxQueued int) (*Admission, error) {
+	if maxConcurrent < 1 {
+		return nil, fmt.Errorf("admission must let at least 1 bid run at once, not %d", maxConcurrent)
+	}
+	if maxQueued < 0 {
+		return nil, fmt.Errorf("admission can't queue %d bids", maxQueued)
+	}
+
+	return &Admission{
+		running:  make(chan struct{}, maxConcurrent),
+		admitted: make(chan struct{}, maxConcurrent+maxQueued),
+	}, nil
+}
+
+// Admit waits for the bid's turn, or returns false at once if the queue is
+// full.  Bids that are admitted must be finished with Done.
+func (a *Admission) Admit() bool {
+	if a == nil {
+		return true
+	}
+
+	select {
+	case a.admitted <- struct{}{}:
+	default:
+		atomic.AddUint64(&a.busy, 1)
+		return false
+	}
+
+	a.running <- struct{}{}
+	return true
+}
+
+func (a *Admission) Done() {
+	if a == nil {
+		return
+	}
+
+	<-a.running
+	<-a.admitted
+}
+
+// Busy is the number of bids turned away.
+func (a *Admission) Busy() uint64 {
+	if a == nil {
+		return 0
+	}
+
+	return atomic.LoadUint64(&a.busy)
+}
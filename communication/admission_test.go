@@ -0,0 +1,54 @@
+package communication_test
+
+import (
+	. "github.com/onsi/auction/communication"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Admission", func() {
+	It("should admit everything when nil", func() {
+		var admission *Admission
+		for i := 0; i < 10; i++ {
+			Ω(admission.Admit()).Should(BeTrue())
+		}
+		admission.Done()
+		Ω(admission.Busy()).Should(BeZero())
+	})
+
+	It("should queue bids beyond the concurrency limit until one is done", func() {
+		admission, err := NewAdmission(1, 1)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		Ω(admission.Admit()).Should(BeTrue())
+
+		queued := make(chan bool)
+		go func() {
+			queued <- admission.Admit()
+		}()
+		Consistently(queued).ShouldNot(Receive())
+
+		admission.Done()
+		Eventually(queued).Should(Receive(BeTrue()))
+	})
+
+	It("should turn bids away once the queue is full, and count them", func() {
+		admission, err := NewAdmission(1, 0)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		Ω(admission.Admit()).Should(BeTrue())
+		Ω(admission.Admit()).Should(BeFalse())
+		Ω(admission.Busy()).Should(Equal(uint64(1)))
+
+		admission.Done()
+		Ω(admission.Admit()).Should(BeTrue())
+	})
+
+	It("should refuse limits that would never admit a bid", func() {
+		for _, limits := range [][]int{{0, 0}, {-1, 5}, {1, -1}} {
+			admission, err := NewAdmission(limits[0], limits[1])
+			Ω(err).Should(HaveOccurred())
+			Ω(admission).Should(BeNil())
+		}
+	})
+})
@@ -0,0 +1,317 @@
+// Package circuitbreaker wraps a RepPoolClient with a circuit breaker per rep,
+// so that reps that keep timing out stop being asked to bid.
+//
+// A rep's circuit is closed while it answers.  Once enough of its recent
+// requests have timed out (or failed to reach it) the circuit opens: the rep is
+// left out of auctions and requests for it fail at once with CircuitOpenError.
+// After a cooldown the circuit is half-open and the next request to the rep is
+// let through as a probe.  If the rep answers the circuit closes, otherwise it
+// opens for another cooldown.
+package circuitbreaker
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/onsi/auction/communication"
+	"github.com/onsi/auction/types"
+)
+
+var CircuitOpenError = errors.New("circuit open")
+
+type State int
+
+const (
+	Closed State = iota
+	Open
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Requests that time out or can't reach the rep count against it; reps that
+// answer with an error (because they are full, say) are working as they should.
+type Config struct {
+	// Window is the number of recent requests each rep is judged on.  A
+	// circuit can't open until it has seen this many.
+	Window int
+	// FailureRate is the fraction of the window that must time out for the
+	// circuit to open.
+	FailureRate float64
+	// Cooldown is how long a circuit stays open before it is probed.
+	Cooldown time.Duration
+}
+
+var DefaultConfig = Config{
+	Window:      10,
+	FailureRate: 0.5,
+	Cooldown:    5 * time.Second,
+}
+
+// Status describes a rep's breaker: its state, and how many of the requests
+// in its current window succeeded and timed out.
+type Status struct {
+	State     State `json:"state"`
+	Successes int   `json:"successes"`
+	Timeouts  int   `json:"timeouts"`
+}
+
+type breaker struct {
+	state    State
+	outcomes []bool //true for each request in the window that timed out
+	openedAt time.Time
+	probing  bool
+}
+
+func (b *breaker) status() Status {
+	status := Status{State: b.state}
+	for _, timedOut := range b.outcomes {
+		if timedOut {
+			status.Timeouts++
+		} else {
+			status.Successes++
+		}
+	}
+	return status
+}
+
+// Client is a RepPoolClient whose requests go through per-rep breakers.  Claims
+// and releases are always passed on: they follow a successful reservation and
+// must reach the rep if they can.
+type Client struct {
+	types.RepPoolClient
+
+	config   Config
+	breakers map[string]*breaker
+	lock     *sync.Mutex
+}
+
+func New(client types.RepPoolClient, config Config) *Client {
+	return &Client{
+		RepPoolClient: client,
+		config:        config,
+		breakers:      map[string]*breaker{},
+		lock:          &sync.Mutex{},
+	}
+}
+
+// BroadcastClient is a Client around a client that can broadcast.  Broadcast
+// scores are passed on, and the replies that come back are recorded.
+type BroadcastClient struct {
+	*Client
+	broadcaster types.BroadcastRepPoolClient
+}
+
+func NewBroadcast(client types.BroadcastRepPoolClient, config Config) *BroadcastClient {
+	return &BroadcastClient{
+		Client:      New(client, config),
+		broadcaster: client,
+	}
+}
+
+func (c *BroadcastClient) BroadcastScore(k int, instance types.Instance) types.ScoreResults {
+	results := c.broadcaster.BroadcastScore(k, instance)
+	for _, result := range results {
+		c.record(result)
+	}
+	return results
+}
+
+// Available returns the reps worth asking to bid: those whose circuit is closed
+// or ready to be probed.
+func (c *Client) Available(guids types.RepGuids) types.RepGuids {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	out := types.RepGuids{}
+	for _, guid := range guids {
+		b, ok := c.breakers[guid]
+		if !ok {
+			out = append(out, guid)
+			continue
+		}
+
+		switch b.state {
+		case Closed:
+			out = append(out, guid)
+		case Open:
+			if time.Since(b.openedAt) >= c.config.Cooldown {
+				out = append(out, guid)
+			}
+		case HalfOpen:
+			if !b.probing {
+				out = append(out, guid)
+			}
+		}
+	}
+
+	return out
+}
+
+// Statuses returns the status of every rep the client has sent a request to.
+func (c *Client) Statuses() map[string]Status {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	statuses := map[string]Status{}
+	for guid, b := range c.breakers {
+		statuses[guid] = b.status()
+	}
+	return statuses
+}
+
+// State returns the state of a rep's circuit.
+func (c *Client) State(guid string) State {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	b, ok := c.breakers[guid]
+	if !ok {
+		return Closed
+	}
+	return b.state
+}
+
+func (c *Client) breaker(guid string) *breaker {
+	b, ok := c.breakers[guid]
+	if !ok {
+		b = &breaker{}
+		c.breakers[guid] = b
+	}
+	return b
+}
+
+// allow decides whether a request may be sent to the rep.  Only one probe is
+// let through a half-open circuit at a time.
+func (c *Client) allow(guid string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	b := c.breaker(guid)
+	switch b.state {
+	case Open:
+		if time.Since(b.openedAt) < c.config.Cooldown {
+			return false
+		}
+		b.state = HalfOpen
+		b.probing = true
+		return true
+	case HalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+
+	return true
+}
+
+func (c *Client) record(result types.ScoreResult) {
+	timedOut := result.Error.Unanswered()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	b := c.breaker(result.Rep)
+	switch b.state {
+	case HalfOpen:
+		b.probing = false
+		b.outcomes = nil
+		if timedOut {
+			b.state = Open
+			b.openedAt = time.Now()
+		} else {
+			b.state = Closed
+		}
+	case Closed:
+		b.outcomes = append(b.outcomes, timedOut)
+		if len(b.outcomes) > c.config.Window {
+			b.outcomes = b.outcomes[1:]
+		}
+		if len(b.outcomes) == c.config.Window && float64(b.status().Timeouts) >= c.config.FailureRate*float64(c.config.Window) {
+			b.state = Open
+			b.openedAt = time.Now()
+			b.outcomes = nil
+		}
+	}
+}
+
+// guard sends the request to the reps whose circuits allow it and records how
+// they did.  Reps whose circuits are open get a CircuitOpenError result, so
+// there is still one result per rep, in order.
+func (c *Client) guard(guids []string, request func(allowed []string) types.ScoreResults) types.ScoreResults {
+	allowed := []string{}
+	for _, guid := range guids {
+		if c.allow(guid) {
+			allowed = append(allowed, guid)
+		}
+	}
+
+	answered := map[string]types.ScoreResult{}
+	if len(allowed) > 0 {
+		for _, result := range request(allowed) {
+			c.record(result)
+			answered[result.Rep] = result
+		}
+	}
+
+	results := make(types.ScoreResults, len(guids))
+	for i, guid := range guids {
+		result, ok := answered[guid]
+		if !ok {
+			result = communication.ErrorResult(guid, CircuitOpenError)
+		}
+		results[i] = result
+	}
+
+	return results
+}
+
+func (c *Client) Score(guids []string, instance types.Instance) types.ScoreResults {
+	return c.guard(guids, func(allowed []string) types.ScoreResults {
+		return c.RepPoolClient.Score(allowed, instance)
+	})
+}
+
+func (c *Client) ScoreThenTentativelyReserve(guids []string, instance types.Instance) types.ScoreResults {
+	return c.guard(guids, func(allowed []string) types.ScoreResults {
+		return c.RepPoolClient.ScoreThenTentativelyReserve(allowed, instance)
+	})
+}
+
+func (c *Client) TentativelyReserveIfUnchanged(scores types.ScoreResults, instance types.Instance) types.ScoreResults {
+	return c.guard(scores.Reps(), func(allowed []string) types.ScoreResults {
+		return c.RepPoolClient.TentativelyReserveIfUnchanged(onlyFrom(allowed, scores), instance)
+	})
+}
+
+func onlyFrom(guids []string, scores types.ScoreResults) types.ScoreResults {
+	lookup := map[string]bool{}
+	for _, guid := range guids {
+		lookup[guid] = true
+	}
+
+	out := types.ScoreResults{}
+	for _, score := range scores {
+		if lookup[score.Rep] {
+			out = append(out, score)
+		}
+	}
+	return out
+}
@@ -0,0 +1,13 @@
+package circuitbreaker_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CircuitBreaker Suite")
+}
@@ -0,0 +1,348 @@
+package circuitbreaker_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/onsi/auction/auctioneer"
+	"github.com/onsi/auction/auctionrep"
+	"github.com/onsi/auction/communication"
+	. "github.com/onsi/auction/communication/circuitbreaker"
+	"github.com/onsi/auction/communication/conformance"
+	"github.com/onsi/auction/communication/http/rephttpclient"
+	"github.com/onsi/auction/communication/http/rephttpserver"
+	"github.com/onsi/auction/simulation/simulationrepdelegate"
+	"github.com/onsi/auction/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// stubClient answers for every rep at once, except the ones that are down,
+// which time out, and the ones that are full.
+type stubClient struct {
+	down  map[string]bool
+	full  map[string]bool
+	asked []string
+	lock  *sync.Mutex
+}
+
+func newStubClient() *stubClient {
+	return &stubClient{
+		down: map[string]bool{},
+		full: map[string]bool{},
+		lock: &sync.Mutex{},
+	}
+}
+
+func (c *stubClient) setDown(guid string, down bool) {
+	c.lock.Lock()
+	c.down[guid] = down
+	c.lock.Unlock()
+}
+
+func (c *stubClient) wasAsked(guid string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, asked := range c.asked {
+		if asked == guid {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *stubClient) forget() {
+	c.lock.Lock()
+	c.asked = nil
+	c.lock.Unlock()
+}
+
+func (c *stubClient) results(guids []string) types.ScoreResults {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	results := types.ScoreResults{}
+	for _, guid := range guids {
+		c.asked = append(c.asked, guid)
+		switch {
+		case c.down[guid]:
+			results = append(results, communication.ErrorResult(guid, communication.TimeoutError))
+		case c.full[guid]:
+			results = append(results, communication.ErrorResult(guid, types.InsufficientResources))
+		default:
+			results = append(results, types.ScoreResult{Rep: guid, Score: 0.5})
+		}
+	}
+	return results
+}
+
+func (c *stubClient) Score(guids []string, instance types.Instance) types.ScoreResults {
+	return c.results(guids)
+}
+
+func (c *stubClient) ScoreThenTentativelyReserve(guids []string, instance types.Instance) types.ScoreResults {
+	return c.results(guids)
+}
+
+func (c *stubClient) TentativelyReserveIfUnchanged(scores types.ScoreResults, instance types.Instance) types.ScoreResults {
+	return c.results(scores.Reps())
+}
+
+func (c *stubClient) ReleaseReservation(guids []string, instance types.Instance) {
+	c.results(guids)
+}
+
+func (c *stubClient) Claim(guid string, instance types.Instance) {
+	c.results([]string{guid})
+}
+
+func (c *stubClient) BroadcastScore(k int, instance types.Instance) types.ScoreResults {
+	c.lock.Lock()
+	guids := []string{}
+	for guid := range c.full {
+		guids = append(guids, guid)
+	}
+	c.lock.Unlock()
+	return c.results(append(guids, "rep-a", "rep-b"))
+}
+
+var _ = Describe("CircuitBreaker", func() {
+	var stub *stubClient
+	var client *Client
+	var instance types.Instance
+	var config Config
+
+	BeforeEach(func() {
+		stub = newStubClient()
+		config = Config{Window: 4, FailureRate: 0.5, Cooldown: 50 * time.Millisecond}
+		client = New(stub, config)
+		instance = types.Instance{AppGuid: "app-guid", InstanceGuid: "instance-guid"}
+	})
+
+	trip := func(guid string) {
+		stub.setDown(guid, true)
+		for i := 0; i < config.Window; i++ {
+			client.Score([]string{guid}, instance)
+		}
+		Ω(client.State(guid)).Should(Equal(Open))
+		stub.forget()
+	}
+
+	Describe("around a real transport", func() {
+		var servers []*httptest.Server
+
+		BeforeEach(func() {
+			repAddrs := map[string]string{}
+			servers = []*httptest.Server{}
+			for _, guid := range []string{"rep-a", "rep-b"} {
+				rep := auctionrep.New(guid, simulationrepdelegate.New(types.Resources{MemoryMB: 100, DiskMB: 100, Containers: 10}))
+				server := httptest.NewServer(rephttpserver.Handler(rep, nil, nil))
+				servers = append(servers, server)
+				repAddrs[guid] = strings.TrimPrefix(server.URL, "http://")
+			}
+
+			slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(time.Second)
+			}))
+			servers = append(servers, slowServer)
+			repAddrs["rep-slow"] = strings.TrimPrefix(slowServer.URL, "http://")
+
+			client = New(rephttpclient.New(repAddrs, 100*time.Millisecond, communication.JSON, nil), DefaultConfig)
+		})
+
+		AfterEach(func() {
+			for _, server := range servers {
+				server.CloseClientConnections()
+				server.Close()
+			}
+		})
+
+		conformance.ItBehavesLikeARepPoolClient(func() conformance.Fixture {
+			return conformance.Fixture{
+				Client:       client,
+				Timeout:      100 * time.Millisecond,
+				RepResources: types.Resources{MemoryMB: 100, DiskMB: 100, Containers: 10},
+				Responsive:   []string{"rep-a", "rep-b"},
+				Unresponsive: []string{"rep-slow"},
+			}
+		})
+	})
+
+	It("should pass requests on while reps answer", func() {
+		stub.full["rep-b"] = true
+		for i := 0; i < 2*config.Window; i++ {
+			results := client.Score([]string{"rep-a", "rep-b"}, instance)
+			Ω(results[0].Error).Should(BeEmpty())
+			Ω(results[1].Error).Should(Equal(types.InsufficientResources))
+		}
+
+		Ω(client.State("rep-a")).Should(Equal(Closed))
+		Ω(client.State("rep-b")).Should(Equal(Closed))
+		Ω(client.Available(types.RepGuids{"rep-a", "rep-b"})).Should(Equal(types.RepGuids{"rep-a", "rep-b"}))
+	})
+
+	It("should track successes and timeouts over the window", func() {
+		stub.setDown("rep-a", true)
+		client.Score([]string{"rep-a"}, instance)
+		stub.setDown("rep-a", false)
+		client.Score([]string{"rep-a"}, instance)
+		client.Score([]string{"rep-a"}, instance)
+
+		Ω(client.Statuses()).Should(Equal(map[string]Status{
+			"rep-a": {State: Closed, Successes: 2, Timeouts: 1},
+		}))
+	})
+
+	It("should only open once the window is full", func() {
+		stub.setDown("rep-a", true)
+		for i := 0; i < config.Window-1; i++ {
+			client.Score([]string{"rep-a"}, instance)
+			Ω(client.State("rep-a")).Should(Equal(Closed))
+		}
+
+		client.Score([]string{"rep-a"}, instance)
+		Ω(client.State("rep-a")).Should(Equal(Open))
+	})
+
+	It("should stay closed while timeouts are below the failure rate", func() {
+		for i := 0; i < 3*config.Window; i++ {
+			stub.setDown("rep-a", i%4 == 0)
+			client.Score([]string{"rep-a"}, instance)
+		}
+
+		Ω(client.State("rep-a")).Should(Equal(Closed))
+	})
+
+	Context("when a rep's circuit is open", func() {
+		BeforeEach(func() {
+			trip("rep-a")
+		})
+
+		It("should not ask the rep, and should still return one result per rep", func() {
+			results := client.ScoreThenTentativelyReserve([]string{"rep-a", "rep-b"}, instance)
+			Ω(results.Reps()).Should(Equal(types.RepGuids{"rep-a", "rep-b"}))
+			Ω(results[0].Message).Should(Equal(CircuitOpenError.Error()))
+			Ω(results[1].Error).Should(BeEmpty())
+
+			results = client.TentativelyReserveIfUnchanged(types.ScoreResults{{Rep: "rep-a"}, {Rep: "rep-b"}}, instance)
+			Ω(results[0].Message).Should(Equal(CircuitOpenError.Error()))
+			Ω(results[1].Error).Should(BeEmpty())
+
+			Ω(stub.wasAsked("rep-a")).Should(BeFalse())
+		})
+
+		It("should leave the rep out of the available reps", func() {
+			Ω(client.Available(types.RepGuids{"rep-a", "rep-b"})).Should(Equal(types.RepGuids{"rep-b"}))
+		})
+
+		It("should still pass claims and releases on", func() {
+			client.Claim("rep-a", instance)
+			client.ReleaseReservation([]string{"rep-a"}, instance)
+			Ω(stub.wasAsked("rep-a")).Should(BeTrue())
+		})
+
+		It("should report its state", func() {
+			encoded, err := json.Marshal(client.Statuses())
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(string(encoded)).Should(ContainSubstring(`"rep-a":{"state":"open"`))
+		})
+
+		It("should keep auctions away from the rep", func() {
+			request := types.AuctionRequest{
+				Instance: instance,
+				RepGuids: types.RepGuids{"rep-a", "rep-b"},
+				Rules:    types.AuctionRules{Algorithm: "reserve_n_best", MaxRounds: 1, MaxBiddingPool: 1},
+			}
+
+			Ω(auctioneer.Auction(client, request).Winner).Should(Equal("rep-b"))
+			Ω(stub.wasAsked("rep-a")).Should(BeFalse())
+		})
+
+		Context("once the cooldown has passed", func() {
+			BeforeEach(func() {
+				time.Sleep(config.Cooldown)
+			})
+
+			It("should make the rep available to be probed", func() {
+				Ω(client.Available(types.RepGuids{"rep-a"})).Should(Equal(types.RepGuids{"rep-a"}))
+			})
+
+			It("should let a single probe through, and close if the rep answers", func() {
+				stub.setDown("rep-a", false)
+
+				Ω(client.Score([]string{"rep-a"}, instance)[0].Error).Should(BeEmpty())
+				Ω(client.State("rep-a")).Should(Equal(Closed))
+				Ω(client.Available(types.RepGuids{"rep-a"})).Should(Equal(types.RepGuids{"rep-a"}))
+			})
+
+			It("should open for another cooldown if the probe times out", func() {
+				Ω(client.Score([]string{"rep-a"}, instance)[0].Error).Should(Equal(types.Timeout))
+				Ω(client.State("rep-a")).Should(Equal(Open))
+
+				Ω(client.Score([]string{"rep-a"}, instance)[0].Message).Should(Equal(CircuitOpenError.Error()))
+
+				time.Sleep(config.Cooldown)
+				stub.setDown("rep-a", false)
+				Ω(client.Score([]string{"rep-a"}, instance)[0].Error).Should(BeEmpty())
+				Ω(client.State("rep-a")).Should(Equal(Closed))
+			})
+		})
+	})
+
+	Describe("while a probe is in flight", func() {
+		It("should not let another request through", func() {
+			blocking := &blockingClient{stubClient: stub, arrived: make(chan struct{}, 1), release: make(chan struct{})}
+			client = New(blocking, config)
+			trip("rep-a")
+			time.Sleep(config.Cooldown)
+			blocking.blocking = true
+
+			probed := make(chan types.ScoreResults, 1)
+			go func() {
+				probed <- client.Score([]string{"rep-a"}, instance)
+			}()
+			Eventually(blocking.arrived).Should(Receive())
+
+			Ω(client.State("rep-a")).Should(Equal(HalfOpen))
+			Ω(client.Available(types.RepGuids{"rep-a"})).Should(BeEmpty())
+			Ω(client.Score([]string{"rep-a"}, instance)[0].Message).Should(Equal(CircuitOpenError.Error()))
+
+			stub.setDown("rep-a", false)
+			close(blocking.release)
+			Eventually(probed).Should(Receive())
+			Ω(client.State("rep-a")).Should(Equal(Closed))
+		})
+	})
+
+	Describe("broadcasting", func() {
+		It("should pass broadcasts on and record the replies", func() {
+			broadcaster := NewBroadcast(stub, config)
+			stub.full["rep-c"] = true
+
+			Ω(broadcaster.BroadcastScore(2, instance)).Should(HaveLen(3))
+			Ω(broadcaster.Statuses()).Should(HaveLen(3))
+			Ω(broadcaster.Statuses()["rep-c"].Successes).Should(Equal(1))
+		})
+	})
+})
+
+// blockingClient holds Score requests until it is released, once it is
+// blocking.
+type blockingClient struct {
+	*stubClient
+	blocking bool
+	arrived  chan struct{}
+	release  chan struct{}
+}
+
+func (c *blockingClient) Score(guids []string, instance types.Instance) types.ScoreResults {
+	if c.blocking {
+		c.arrived <- struct{}{}
+		<-c.release
+	}
+	return c.stubClient.Score(guids, instance)
+}
@@ -0,0 +1,145 @@
+package communication
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var UnknownContentTypeError = errors.New("unknown content type")
+
+// A Codec encodes the bodies of auction messages.  Every message carries the
+// content type of its codec so reps can answer in whatever codec they were
+// spoken to in.
+type Codec interface {
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var JSON Codec = jsonCodec{}
+var MsgPack Codec = msgPackCodec{}
+
+var Codecs = []Codec{JSON, MsgPack}
+
+// CodecForContentType treats a missing content type as JSON, which is what
+// every message was before codecs existed.
+func CodecForContentType(contentType string) (Codec, error) {
+	if contentType == "" {
+		return JSON, nil
+	}
+
+	for _, codec := range Codecs {
+		if codec.ContentType() == contentType {
+			return codec, nil
+		}
+	}
+
+	return nil, UnknownContentTypeError
+}
+
+// CodecNamed looks codecs up by the short names used on the command line.
+func CodecNamed(name string) (Codec, error) {
+	switch name {
+	case "json":
+		return JSON, nil
+	case "msgpack":
+		return MsgPack, nil
+	}
+
+	return nil, UnknownContentTypeError
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return "application/json"
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// Frame prefixes body with its content type for transports, like NATS, that
+// have no headers of their own.
+func Frame(contentType string, body []byte) []byte {
+	framed := make([]byte, 0, 1+len(contentType)+len(body))
+	framed = append(framed, byte(len(contentType)))
+	framed = append(framed, contentType...)
+	return append(framed, body...)
+}
+
+// frames that carry a deadline set the top bit of the content type's length
+// and put the deadline, in Unix nanoseconds, between the content type and the
+// body
+const deadlineFlag = 0x80
+
+// FrameWithDeadline is Frame for requests that should be dropped once deadline
+// has passed.
+func FrameWithDeadline(contentType string, deadline time.Time, body []byte) []byte {
+	framed := make([]byte, 0, 1+len(contentType)+8+len(body))
+	framed = append(framed, byte(len(contentType))|deadlineFlag)
+	framed = append(framed, contentType...)
+	framed = append(framed, make([]byte, 8)...)
+	binary.BigEndian.PutUint64(framed[len(framed)-8:], uint64(deadline.UnixNano()))
+	return append(framed, body...)
+}
+
+// Unframe also accepts bare JSON objects and arrays, as sent by peers that
+// predate framing, and ignores any deadline.
+func Unframe(framed []byte) (string, []byte, error) {
+	contentType, _, body, err := UnframeWithDeadline(framed)
+	return contentType, body, err
+}
+
+// UnframeWithDeadline returns a zero deadline for frames that don't carry one.
+func UnframeWithDeadline(framed []byte) (string, time.Time, []byte, error) {
+	if len(framed) == 0 {
+		return "", time.Time{}, nil, errors.New("empty frame")
+	}
+
+	if framed[0] == '{' || framed[0] == '[' {
+		return JSON.ContentType(), time.Time{}, framed, nil
+	}
+
+	n := int(framed[0] &^ deadlineFlag)
+	if len(framed) < 1+n {
+		return "", time.Time{}, nil, errors.New("truncated frame")
+	}
+	contentType, rest := string(framed[1:1+n]), framed[1+n:]
+
+	if framed[0]&deadlineFlag == 0 {
+		return contentType, time.Time{}, rest, nil
+	}
+
+	if len(rest) < 8 {
+		return "", time.Time{}, nil, errors.New("truncated frame")
+	}
+
+	return contentType, time.Unix(0, int64(binary.BigEndian.Uint64(rest))), rest[8:], nil
+}
+
+// DecodeResponse interprets a rep's response to a request.  resp may be nil
+// when the caller only cares whether the request succeeded.
+func DecodeResponse(contentType string, body []byte, resp interface{}) error {
+	if bytes.Equal(body, ErrorResponse) {
+		return RequestFailedError
+	}
+
+	if resp == nil {
+		return nil
+	}
+
+	codec, err := CodecForContentType(contentType)
+	if err != nil {
+		return err
+	}
+
+	return codec.Unmarshal(body, resp)
+}
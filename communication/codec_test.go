@@ -0,0 +1,222 @@
+package communication_test
+
+import (
+	"time"
+
+	. "github.com/onsi/auction/communication"
+	"github.com/onsi/auction/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Codecs", func() {
+	var instance types.Instance
+
+	BeforeEach(func() {
+		instance = types.Instance{
+			AppGuid:      "app-guid",
+			InstanceGuid: "instance-guid",
+			Resources:    types.Resources{MemoryMB: 128, DiskMB: 1024, Containers: 1},
+		}
+	})
+
+	for _, codec := range Codecs {
+		codec := codec
+
+		Describe(codec.ContentType(), func() {
+			It("should round trip instances", func() {
+				encoded, err := codec.Marshal(instance)
+				Ω(err).ShouldNot(HaveOccurred())
+
+				var decoded types.Instance
+				Ω(codec.Unmarshal(encoded, &decoded)).ShouldNot(HaveOccurred())
+				Ω(decoded).Should(Equal(instance))
+			})
+
+			It("should round trip lists of instances", func() {
+				instances := []types.Instance{instance, instance}
+				encoded, err := codec.Marshal(instances)
+				Ω(err).ShouldNot(HaveOccurred())
+
+				var decoded []types.Instance
+				Ω(codec.Unmarshal(encoded, &decoded)).ShouldNot(HaveOccurred())
+				Ω(decoded).Should(Equal(instances))
+			})
+
+			It("should round trip score results", func() {
+				result := types.ScoreResult{Rep: "rep-guid", Score: 0.75, Generation: 1 << 40, Error: types.RequestFailed, Message: "bam"}
+				encoded, err := codec.Marshal(result)
+				Ω(err).ShouldNot(HaveOccurred())
+
+				var decoded types.ScoreResult
+				Ω(codec.Unmarshal(encoded, &decoded)).ShouldNot(HaveOccurred())
+				Ω(decoded).Should(Equal(result))
+			})
+
+			It("should round trip reserve-if-unchanged requests", func() {
+				req := types.ReserveIfUnchangedRequest{Instance: instance, Generation: 17}
+				encoded, err := codec.Marshal(req)
+				Ω(err).ShouldNot(HaveOccurred())
+
+				var decoded types.ReserveIfUnchangedRequest
+				Ω(codec.Unmarshal(encoded, &decoded)).ShouldNot(HaveOccurred())
+				Ω(decoded).Should(Equal(req))
+			})
+
+			It("should fail to decode garbage", func() {
+				var decoded types.Instance
+				Ω(codec.Unmarshal([]byte{0xc1}, &decoded)).Should(HaveOccurred())
+			})
+		})
+	}
+
+	Describe("msgpack", func() {
+		It("should be smaller than JSON", func() {
+			asJSON, _ := JSON.Marshal(instance)
+			asMsgPack, _ := MsgPack.Marshal(instance)
+			Ω(len(asMsgPack)).Should(BeNumerically("<", len(asJSON)))
+		})
+
+		It("should tolerate peers that know about fewer or more fields, in any order", func() {
+			type olderScoreResult struct {
+				Score float64 `json:"s"`
+				Rep   string  `json:"r"`
+			}
+
+			type newerScoreResult struct {
+				Extra      []string `json:"x"`
+				Rep        string   `json:"r"`
+				Score      float64  `json:"s"`
+				Generation uint64   `json:"g"`
+			}
+
+			encoded, _ := MsgPack.Marshal(olderScoreResult{Rep: "rep-guid", Score: 0.5})
+			var fromOlder types.ScoreResult
+			Ω(MsgPack.Unmarshal(encoded, &fromOlder)).ShouldNot(HaveOccurred())
+			Ω(fromOlder).Should(Equal(types.ScoreResult{Rep: "rep-guid", Score: 0.5}))
+
+			encoded, _ = MsgPack.Marshal(newerScoreResult{Rep: "rep-guid", Score: 0.5, Generation: 3, Extra: []string{"a"}})
+			var fromNewer types.ScoreResult
+			Ω(MsgPack.Unmarshal(encoded, &fromNewer)).ShouldNot(HaveOccurred())
+			Ω(fromNewer).Should(Equal(types.ScoreResult{Rep: "rep-guid", Score: 0.5, Generation: 3}))
+		})
+
+		It("should write standard maps keyed by the json tags", func() {
+			encoded, err := MsgPack.Marshal(types.ScoreResult{Rep: "r1", Score: 0.5, Error: types.RepBusy})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(encoded).Should(Equal([]byte{
+				0x83,                      // map of 3 (g and em are omitted when empty)
+				0xa1, 'r', 0xa2, 'r', '1', // "r": "r1"
+				0xa1, 's', 0xcb, 0x3f, 0xe0, 0, 0, 0, 0, 0, 0, // "s": 0.5 as a float 64
+				0xa1, 'e', 0xab, 'r', 'e', 'p', ' ', 'i', 's', ' ', 'b', 'u', 's', 'y', // "e": "rep is busy"
+			}))
+
+			encoded, err = MsgPack.Marshal(types.Resources{MemoryMB: 1, DiskMB: 2, Containers: 300})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(encoded).Should(Equal([]byte{
+				0x83,
+				0xa1, 'd', 0xcb, 0x40, 0, 0, 0, 0, 0, 0, 0, // "d": 2.0
+				0xa1, 'm', 0xcb, 0x3f, 0xf0, 0, 0, 0, 0, 0, 0, // "m": 1.0
+				0xa1, 'c', 0xcd, 0x01, 0x2c, // "c": 300 as a uint 16
+			}))
+		})
+	})
+
+	Describe("score errors in JSON", func() {
+		It("should read the errors of peers that predate error codes", func() {
+			var result types.ScoreResult
+			Ω(JSON.Unmarshal([]byte(`{"r":"rep-guid","s":0,"e":"insufficient resources for instance"}`), &result)).ShouldNot(HaveOccurred())
+			Ω(result.Error).Should(Equal(types.InsufficientResources))
+			Ω(result.Error.Insufficient()).Should(BeTrue())
+		})
+
+		It("should write errors as those peers did, leaving out empty messages", func() {
+			encoded, err := JSON.Marshal(types.ScoreResult{Rep: "rep-guid", Error: types.Timeout})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(string(encoded)).Should(Equal(`{"r":"rep-guid","s":0,"e":"timeout"}`))
+		})
+	})
+
+	Describe("looking codecs up", func() {
+		It("should find codecs by content type, defaulting to JSON", func() {
+			Ω(CodecForContentType("")).Should(Equal(JSON))
+			Ω(CodecForContentType("application/json")).Should(Equal(JSON))
+			Ω(CodecForContentType("application/x-msgpack")).Should(Equal(MsgPack))
+
+			_, err := CodecForContentType("text/plain")
+			Ω(err).Should(Equal(UnknownContentTypeError))
+		})
+
+		It("should find codecs by name", func() {
+			Ω(CodecNamed("json")).Should(Equal(JSON))
+			Ω(CodecNamed("msgpack")).Should(Equal(MsgPack))
+
+			_, err := CodecNamed("xml")
+			Ω(err).Should(Equal(UnknownContentTypeError))
+		})
+	})
+
+	Describe("framing", func() {
+		It("should round trip the content type and body", func() {
+			contentType, body, err := Unframe(Frame(MsgPack.ContentType(), []byte("body")))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(contentType).Should(Equal(MsgPack.ContentType()))
+			Ω(body).Should(Equal([]byte("body")))
+		})
+
+		It("should treat bare JSON as JSON", func() {
+			contentType, body, err := Unframe([]byte(`{"a":"app-guid"}`))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(contentType).Should(Equal(JSON.ContentType()))
+			Ω(body).Should(Equal([]byte(`{"a":"app-guid"}`)))
+		})
+
+		It("should reject empty and truncated frames", func() {
+			_, _, err := Unframe([]byte{})
+			Ω(err).Should(HaveOccurred())
+
+			_, _, err = Unframe([]byte{10, 'a'})
+			Ω(err).Should(HaveOccurred())
+
+			_, _, err = Unframe(FrameWithDeadline("a", time.Now(), nil)[:5])
+			Ω(err).Should(HaveOccurred())
+		})
+
+		It("should round trip deadlines", func() {
+			deadline := time.Now().Add(time.Second)
+			contentType, decoded, body, err := UnframeWithDeadline(FrameWithDeadline(MsgPack.ContentType(), deadline, []byte("body")))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(contentType).Should(Equal(MsgPack.ContentType()))
+			Ω(decoded.Equal(deadline)).Should(BeTrue())
+			Ω(body).Should(Equal([]byte("body")))
+		})
+
+		It("should report no deadline for frames without one", func() {
+			_, deadline, body, err := UnframeWithDeadline(Frame(JSON.ContentType(), []byte("body")))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(deadline.IsZero()).Should(BeTrue())
+			Ω(body).Should(Equal([]byte("body")))
+		})
+
+		It("should let Unframe read frames with deadlines", func() {
+			contentType, body, err := Unframe(FrameWithDeadline(JSON.ContentType(), time.Now(), []byte("body")))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(contentType).Should(Equal(JSON.ContentType()))
+			Ω(body).Should(Equal([]byte("body")))
+		})
+	})
+
+	Describe("decoding responses", func() {
+		It("should turn error responses into RequestFailedError", func() {
+			var result types.ScoreResult
+			Ω(DecodeResponse(MsgPack.ContentType(), ErrorResponse, &result)).Should(Equal(RequestFailedError))
+		})
+
+		It("should decode with the response's codec", func() {
+			encoded, _ := MsgPack.Marshal(instance)
+			var decoded types.Instance
+			Ω(DecodeResponse(MsgPack.ContentType(), encoded, &decoded)).ShouldNot(HaveOccurred())
+			Ω(decoded).Should(Equal(instance))
+		})
+	})
+})
@@ -0,0 +1,311 @@
+// Package conformance holds Ginkgo specs that every RepPoolClient must pass,
+// whatever transport it uses.  Each transport's test suite calls into it with a
+// Fixture built on that transport; a new transport should run
+// ItBehavesLikeATestRepPoolClient before it is used in the simulation.
+package conformance
+
+import (
+	"sync"
+	"time"
+
+	"github.com/onsi/auction/communication"
+	"github.com/onsi/auction/types"
+	"github.com/onsi/auction/util"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// A Fixture is a client wired up to a freshly started pool of reps.
+// Responsive reps answer promptly and each has RepResources, which must allow
+// at least one container and one MB of memory and disk per container;
+// Unresponsive reps never answer within Timeout.
+type Fixture struct {
+	Client       types.RepPoolClient
+	Timeout      time.Duration
+	RepResources types.Resources
+	Responsive   []string
+	Unresponsive []string
+}
+
+func newInstance(memoryMB float64) types.Instance {
+	return types.Instance{
+		AppGuid:      "app-guid",
+		InstanceGuid: util.RandomGuid(),
+		Resources:    types.Resources{MemoryMB: memoryMB, DiskMB: 1},
+	}
+}
+
+// ItBehavesLikeARepPoolClient checks the semantics the auctioneer relies on.
+func ItBehavesLikeARepPoolClient(newFixture func() Fixture) {
+	ItReturnsOneResultPerRep(newFixture)
+
+	Describe("RepPoolClient semantics", func() {
+		var fixture Fixture
+		var client types.RepPoolClient
+		var rep string
+
+		BeforeEach(func() {
+			fixture = newFixture()
+			client = fixture.Client
+			rep = fixture.Responsive[0]
+		})
+
+		reserve := func(instance types.Instance) types.ScoreResult {
+			results := client.ScoreThenTentativelyReserve([]string{rep}, instance)
+			Ω(results).Should(HaveLen(1))
+			return results[0]
+		}
+
+		It("should propagate errors from the reps", func() {
+			results := client.Score(fixture.Responsive, newInstance(fixture.RepResources.MemoryMB+1))
+			Ω(results).Should(HaveLen(len(fixture.Responsive)))
+			for _, result := range results {
+				Ω(result.Error).Should(Equal(types.InsufficientMemory), result.Rep)
+			}
+		})
+
+		It("should hold reservations until they are released", func() {
+			filling := newInstance(fixture.RepResources.MemoryMB)
+			Ω(reserve(filling).Error).Should(BeEmpty())
+			Ω(reserve(newInstance(1)).Error).Should(Equal(types.InsufficientMemory))
+
+			client.ReleaseReservation([]string{rep}, filling)
+			Ω(reserve(newInstance(1)).Error).Should(BeEmpty())
+		})
+
+		It("should keep claimed instances", func() {
+			filling := newInstance(fixture.RepResources.MemoryMB)
+			Ω(reserve(filling).Error).Should(BeEmpty())
+			client.Claim(rep, filling)
+
+			Ω(reserve(newInstance(1)).Error).Should(Equal(types.InsufficientMemory))
+		})
+
+		It("should refuse to reserve if the rep changed since it was scored", func() {
+			scores := client.Score([]string{rep}, newInstance(1))
+			Ω(reserve(newInstance(1)).Error).Should(BeEmpty())
+
+			results := client.TentativelyReserveIfUnchanged(scores, newInstance(1))
+			Ω(results).Should(HaveLen(1))
+			Ω(results[0].Error).Should(Equal(types.StaleScore))
+		})
+
+		It("should reserve if the rep is unchanged since it was scored", func() {
+			instance := newInstance(1)
+			scores := client.Score([]string{rep}, instance)
+
+			results := client.TentativelyReserveIfUnchanged(scores, instance)
+			Ω(results).Should(HaveLen(1))
+			Ω(results[0].Error).Should(BeEmpty())
+		})
+
+		It("should count the bytes of every request and reply, if it counts them", func() {
+			counted, ok := client.(communication.WireCounted)
+			if !ok {
+				return
+			}
+
+			before := counted.WireBytes()
+			Ω(client.Score([]string{rep}, newInstance(1))).Should(HaveLen(1))
+			scored := counted.WireBytes().Since(before)
+			Ω(scored.Sent).Should(BeNumerically(">", 0))
+			Ω(scored.Received).Should(BeNumerically(">", 0))
+			Ω(scored.SentAsJSON).Should(BeNumerically(">", 0))
+			Ω(scored.ReceivedAsJSON).Should(BeNumerically(">", 0))
+
+			before = counted.WireBytes()
+			Ω(client.Score([]string{rep, rep}, newInstance(1))).Should(HaveLen(2))
+			Ω(counted.WireBytes().Since(before).Sent).Should(BeNumerically(">", scored.Sent))
+		})
+
+		It("should be safe to use concurrently", func() {
+			attempts := 3 * fixture.RepResources.Containers
+			guids := append(append([]string{}, fixture.Responsive...), fixture.Unresponsive...)
+
+			lock := &sync.Mutex{}
+			reserved, timedOut := 0, 0
+			wg := &sync.WaitGroup{}
+			wg.Add(2 * attempts)
+
+			for i := 0; i < attempts; i++ {
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+
+					results := client.Score(guids, newInstance(1))
+					Ω(results).Should(HaveLen(len(guids)))
+				}()
+
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+
+					instance := newInstance(1)
+					results := client.ScoreThenTentativelyReserve([]string{rep}, instance)
+					Ω(results).Should(HaveLen(1))
+
+					if results[0].Error == "" {
+						client.Claim(rep, instance)
+					}
+
+					lock.Lock()
+					defer lock.Unlock()
+					switch {
+					case results[0].Error == "":
+						reserved++
+					case results[0].Error.Insufficient():
+					default:
+						timedOut++
+					}
+				}()
+			}
+
+			wg.Wait()
+
+			//a reservation whose reply was lost still takes up room on the rep
+			Ω(reserved + timedOut).Should(BeNumerically(">=", fixture.RepResources.Containers))
+			Ω(reserved).Should(BeNumerically("<=", fixture.RepResources.Containers))
+		})
+	})
+}
+
+// ItBehavesLikeATestRepPoolClient adds the checks for the methods the
+// simulation uses to set up and inspect the reps.
+func ItBehavesLikeATestRepPoolClient(newFixture func() Fixture) {
+	ItBehavesLikeARepPoolClient(newFixture)
+
+	Describe("TestRepPoolClient semantics", func() {
+		var fixture Fixture
+		var client types.TestRepPoolClient
+		var rep string
+
+		BeforeEach(func() {
+			fixture = newFixture()
+			var ok bool
+			client, ok = fixture.Client.(types.TestRepPoolClient)
+			Ω(ok).Should(BeTrue(), "the fixture's client must be a TestRepPoolClient")
+			rep = fixture.Responsive[0]
+		})
+
+		It("should report the reps' total resources", func() {
+			for _, guid := range fixture.Responsive {
+				Ω(client.TotalResources(guid)).Should(Equal(fixture.RepResources))
+			}
+		})
+
+		It("should set, fetch and reset instances", func() {
+			instances := []types.Instance{newInstance(1), newInstance(1)}
+			Ω(client.SetInstances(rep, instances)).ShouldNot(HaveOccurred())
+
+			fetched, err := client.Instances(rep)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(fetched).Should(HaveLen(2))
+			Ω(fetched).Should(ContainElement(instances[0]))
+			Ω(fetched).Should(ContainElement(instances[1]))
+
+			Ω(client.Reset(rep)).ShouldNot(HaveOccurred())
+			Ω(client.Instances(rep)).Should(BeEmpty())
+		})
+
+		It("should show claimed instances and drop released ones", func() {
+			claimed := newInstance(1)
+			released := newInstance(1)
+			results := client.ScoreThenTentativelyReserve([]string{rep}, claimed)
+			Ω(results.FilterErrors()).Should(HaveLen(1))
+			results = client.ScoreThenTentativelyReserve([]string{rep}, released)
+			Ω(results.FilterErrors()).Should(HaveLen(1))
+
+			client.Claim(rep, claimed)
+			client.ReleaseReservation([]string{rep}, released)
+
+			Ω(client.Instances(rep)).Should(Equal([]types.Instance{claimed}))
+		})
+
+		It("should return errors, not panic, when a rep doesn't answer", func() {
+			for _, guid := range fixture.Unresponsive {
+				_, err := client.TotalResources(guid)
+				Ω(err).Should(HaveOccurred())
+
+				_, err = client.Instances(guid)
+				Ω(err).Should(HaveOccurred())
+
+				Ω(client.SetInstances(guid, []types.Instance{newInstance(1)})).Should(HaveOccurred())
+				Ω(client.Reset(guid)).Should(HaveOccurred())
+			}
+		})
+	})
+}
+
+// ItReturnsOneResultPerRep checks that batched requests return exactly one
+// result per requested rep, even when some of those reps don't answer.
+func ItReturnsOneResultPerRep(newFixture func() Fixture) {
+	Describe("batched requests", func() {
+		var fixture Fixture
+		var guids []string
+		var instance types.Instance
+
+		BeforeEach(func() {
+			fixture = newFixture()
+			guids = append(append([]string{}, fixture.Responsive...), fixture.Unresponsive...)
+			instance = newInstance(1)
+		})
+
+		expectOneResultPerRep := func(results types.ScoreResults) {
+			Ω(results).Should(HaveLen(len(guids)))
+
+			byRep := map[string]types.ScoreResult{}
+			for _, result := range results {
+				byRep[result.Rep] = result
+			}
+			Ω(byRep).Should(HaveLen(len(guids)))
+
+			for _, guid := range fixture.Responsive {
+				Ω(byRep[guid].Error).Should(BeEmpty(), guid)
+			}
+
+			for _, guid := range fixture.Unresponsive {
+				Ω(byRep[guid].Error).Should(Equal(types.Timeout), guid)
+			}
+		}
+
+		timed := func(f func() types.ScoreResults) types.ScoreResults {
+			t := time.Now()
+			results := f()
+			Ω(time.Since(t)).Should(BeNumerically("<", 3*fixture.Timeout))
+			return results
+		}
+
+		It("should return one result per rep when scoring", func() {
+			expectOneResultPerRep(timed(func() types.ScoreResults {
+				return fixture.Client.Score(guids, instance)
+			}))
+		})
+
+		It("should return one result per rep when reserving", func() {
+			results := timed(func() types.ScoreResults {
+				return fixture.Client.ScoreThenTentativelyReserve(guids, instance)
+			})
+			expectOneResultPerRep(results)
+			fixture.Client.ReleaseReservation(results.FilterErrors().Reps(), instance)
+		})
+
+		It("should return one result per rep when reserving if unchanged", func() {
+			scores := fixture.Client.Score(guids, instance)
+			results := timed(func() types.ScoreResults {
+				return fixture.Client.TentativelyReserveIfUnchanged(scores, instance)
+			})
+			expectOneResultPerRep(results)
+			fixture.Client.ReleaseReservation(results.FilterErrors().Reps(), instance)
+		})
+
+		It("should return an empty result set when asked about no reps", func() {
+			Ω(fixture.Client.Score([]string{}, instance)).Should(BeEmpty())
+		})
+
+		It("should not hang releasing reservations on reps that don't answer", func() {
+			t := time.Now()
+			fixture.Client.ReleaseReservation(guids, instance)
+			Ω(time.Since(t)).Should(BeNumerically("<", 3*fixture.Timeout))
+		})
+	})
+}
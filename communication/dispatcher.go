@@ -0,0 +1,334 @@
+package communication
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/onsi/auction/auctionrep"
+	"github.com/onsi/auction/types"
+)
+
+const (
+	TotalResourcesSubject                = "total_resources"
+	ResetSubject                         = "reset"
+	SetInstancesSubject                  = "set_instances"
+	InstancesSubject                     = "instances"
+	ScoreSubject                         = "score"
+	ScoreThenTentativelyReserveSubject   = "score_then_tentatively_reserve"
+	TentativelyReserveIfUnchangedSubject = "tentatively_reserve_if_unchanged"
+	ReleaseReservationSubject            = "release-reservation"
+	ClaimSubject                         = "claim"
+
+	// Transports that support broadcast scoring have every rep listen on
+	// BroadcastScoreSubject, which isn't prefixed with a rep guid, and answer
+	// it as they would ScoreSubject.
+	BroadcastScoreSubject = "reps.score"
+
+	// Auctioneers listening on NATS share AuctionSubject in the
+	// AuctioneerQueue queue group, so each auction is held by just one of
+	// them.
+	AuctionSubject  = "auctioneer.auction"
+	AuctioneerQueue = "auctioneers"
+)
+
+// Bids (scores and reservations) are dropped once their deadline has passed:
+// the auctioneer has given up on the reply, and a reservation made for it
+// would only leak.  Other requests, claims and releases among them, are
+// honoured whatever their deadline unless they are signed: a signed request
+// must have a deadline, and is dropped once it has passed, whatever its
+// subject, so that it can't be replayed later.
+var bidSubjects = map[string]bool{
+	ScoreSubject:                         true,
+	BroadcastScoreSubject:                true,
+	ScoreThenTentativelyReserveSubject:   true,
+	TentativelyReserveIfUnchangedSubject: true,
+}
+
+// Returned to version 0 peers when a request can't be decoded, the subject is
+// unknown, or the rep fails to release/claim.  Later versions get an ErrorCode
+// in the envelope instead.  Scoring failures are reported in the ScoreResult.
+var ErrorResponse = []byte("error")
+var SuccessResponse = []byte("ok")
+
+type Handler func(codec Codec, payload []byte) ([]byte, ErrorCode)
+
+// RepDispatcher maps subjects to AuctionRep operations so that every transport
+// decodes requests and encodes responses in exactly the same way.  Transports
+// only need to move envelopes to DispatchEnvelope and send back what it
+// returns.  Responses are encoded with the codec the request used.
+//
+// With a signer, the dispatcher refuses requests that aren't signed with its
+// key for its rep and their subject, or that have no deadline, replying
+// Unauthorized, and signs every reply.  With an admission, bids
+// beyond its limits are answered with a types.RepBusy score result.
+type RepDispatcher struct {
+	expired   uint64
+	rep       *auctionrep.AuctionRep
+	signer    *Signer
+	admission *Admission
+	handlers  map[string]Handler
+}
+
+// NewRepDispatcher dispatches to rep.  signer and admission may be nil.
+func NewRepDispatcher(rep *auctionrep.AuctionRep, signer *Signer, admission *Admission) *RepDispatcher {
+	d := &RepDispatcher{
+		rep:       rep,
+		signer:    signer,
+		admission: admission,
+	}
+
+	d.handlers = map[string]Handler{
+		TotalResourcesSubject:                d.totalResources,
+		ResetSubject:                         d.reset,
+		SetInstancesSubject:                  d.setInstances,
+		InstancesSubject:                     d.instances,
+		ScoreSubject:                         d.score,
+		ScoreThenTentativelyReserveSubject:   d.scoreThenTentativelyReserve,
+		TentativelyReserveIfUnchangedSubject: d.tentativelyReserveIfUnchanged,
+		ReleaseReservationSubject:            d.releaseReservation,
+		ClaimSubject:                         d.claim,
+	}
+
+	return d
+}
+
+// Subjects are the subjects addressed to the dispatcher's rep.  Transports
+// that broadcast score requests also dispatch BroadcastScoreSubject, which is
+// answered as ScoreSubject is.
+func (d *RepDispatcher) Subjects() []string {
+	subjects := []string{}
+	for subject := range d.handlers {
+		subjects = append(subjects, subject)
+	}
+	return subjects
+}
+
+// Dispatch handles a version 0 request that has no deadline.
+func (d *RepDispatcher) Dispatch(subject string, contentType string, payload []byte) []byte {
+	reply, _ := d.DispatchEnvelope(subject, Envelope{ContentType: contentType, Body: payload})
+	return reply.LegacyBody()
+}
+
+// DispatchEnvelope answers request in the version it was made in.  It returns
+// false, and counts the request as expired, if the request's deadline has
+// passed before a score or reservation (or, for signed requests, anything)
+// gets going.  Transports should not reply at all in that case.  A zero
+// deadline never passes.
+func (d *RepDispatcher) DispatchEnvelope(subject string, request Envelope) (Envelope, bool) {
+	err := d.signer.Verify(d.addressee(subject), subject, request)
+	if err == nil && d.signer != nil && request.Deadline.IsZero() {
+		err = NoDeadlineError
+	}
+	if err != nil {
+		log.Println(d.rep.Guid(), "refusing request:", err)
+		return d.Fail(subject, request, Unauthorized), true
+	}
+
+	reply, ok := d.dispatch(subject, request)
+	return d.sign(subject, reply), ok
+}
+
+// Refuse answers request without handling it, for servers that are draining:
+// bids are told that the rep is draining (types.Draining) so that auctioneers
+// look elsewhere, and anything else fails.
+func (d *RepDispatcher) Refuse(subject string, request Envelope) Envelope {
+	codec, err := CodecForContentType(request.ContentType)
+	if !bidSubjects[subject] || err != nil {
+		return d.Fail(subject, request, RequestFailed)
+	}
+
+	return d.sign(subject, request.Reply(d.encodeScore(codec, 0, 0, types.Draining)))
+}
+
+// Fail answers request with code, for transports that can't get as far as
+// handing it to DispatchEnvelope.
+func (d *RepDispatcher) Fail(subject string, request Envelope, code ErrorCode) Envelope {
+	return d.sign(subject, request.Reply(nil, code))
+}
+
+// addressee is the rep that requests on subject are addressed to: this one,
+// unless they are broadcast to every rep.
+func (d *RepDispatcher) addressee(subject string) string {
+	if subject == BroadcastScoreSubject {
+		return ""
+	}
+	return d.rep.Guid()
+}
+
+func (d *RepDispatcher) sign(subject string, reply Envelope) Envelope {
+	return d.signer.Sign(d.addressee(subject), subject, reply)
+}
+
+func (d *RepDispatcher) dispatch(subject string, request Envelope) (Envelope, bool) {
+	if d.hasExpired(subject, request) {
+		return Envelope{}, false
+	}
+
+	handler, ok := d.handlers[subject]
+	if subject == BroadcastScoreSubject {
+		handler, ok = d.score, true
+	}
+	if !ok {
+		log.Println(d.rep.Guid(), "unknown subject:", subject)
+		return request.Reply(nil, UnknownSubject), true
+	}
+
+	codec, err := CodecForContentType(request.ContentType)
+	if err != nil {
+		log.Println(d.rep.Guid(), "unknown content type:", request.ContentType)
+		return request.Reply(nil, UnknownContentType), true
+	}
+
+	if bidSubjects[subject] {
+		if !d.admission.Admit() {
+			return request.Reply(d.encodeScore(codec, 0, 0, types.RepBusy)), true
+		}
+		defer d.admission.Done()
+
+		//the bid may have expired while it waited its turn
+		if d.hasExpired(subject, request) {
+			return Envelope{}, false
+		}
+	}
+
+	return request.Reply(handler(codec, request.Body)), true
+}
+
+func (d *RepDispatcher) hasExpired(subject string, request Envelope) bool {
+	expires := bidSubjects[subject] || d.signer != nil
+	if expires && !request.Deadline.IsZero() && time.Now().After(request.Deadline) {
+		atomic.AddUint64(&d.expired, 1)
+		return true
+	}
+
+	return false
+}
+
+// Expired is the number of requests dropped because they were dispatched after
+// their deadline.
+func (d *RepDispatcher) Expired() uint64 {
+	return atomic.LoadUint64(&d.expired)
+}
+
+// Busy is the number of bids turned away by the admission.
+func (d *RepDispatcher) Busy() uint64 {
+	return d.admission.Busy()
+}
+
+func (d *RepDispatcher) totalResources(codec Codec, _ []byte) ([]byte, ErrorCode) {
+	return d.encode(codec, d.rep.TotalResources())
+}
+
+func (d *RepDispatcher) reset(codec Codec, _ []byte) ([]byte, ErrorCode) {
+	d.rep.Reset()
+	return SuccessResponse, NoError
+}
+
+func (d *RepDispatcher) setInstances(codec Codec, payload []byte) ([]byte, ErrorCode) {
+	var instances []types.Instance
+	if !d.decode(codec, SetInstancesSubject, payload, &instances) {
+		return nil, BadRequest
+	}
+
+	d.rep.SetInstances(instances)
+	return SuccessResponse, NoError
+}
+
+func (d *RepDispatcher) instances(codec Codec, _ []byte) ([]byte, ErrorCode) {
+	return d.encode(codec, d.rep.Instances())
+}
+
+func (d *RepDispatcher) score(codec Codec, payload []byte) ([]byte, ErrorCode) {
+	var instance types.Instance
+	if !d.decode(codec, ScoreSubject, payload, &instance) {
+		return nil, BadRequest
+	}
+
+	score, generation, err := d.rep.Score(instance)
+	return d.encodeScore(codec, score, generation, err)
+}
+
+func (d *RepDispatcher) scoreThenTentativelyReserve(codec Codec, payload []byte) ([]byte, ErrorCode) {
+	var instance types.Instance
+	if !d.decode(codec, ScoreThenTentativelyReserveSubject, payload, &instance) {
+		return nil, BadRequest
+	}
+
+	score, err := d.rep.ScoreThenTentativelyReserve(instance)
+	return d.encodeScore(codec, score, 0, err)
+}
+
+func (d *RepDispatcher) tentativelyReserveIfUnchanged(codec Codec, payload []byte) ([]byte, ErrorCode) {
+	var req types.ReserveIfUnchangedRequest
+	if !d.decode(codec, TentativelyReserveIfUnchangedSubject, payload, &req) {
+		return nil, BadRequest
+	}
+
+	score, err := d.rep.TentativelyReserveIfUnchanged(req.Instance, req.Generation)
+	return d.encodeScore(codec, score, 0, err)
+}
+
+func (d *RepDispatcher) releaseReservation(codec Codec, payload []byte) ([]byte, ErrorCode) {
+	var instance types.Instance
+	if !d.decode(codec, ReleaseReservationSubject, payload, &instance) {
+		return nil, BadRequest
+	}
+
+	err := d.rep.ReleaseReservation(instance)
+	if err != nil {
+		log.Println(d.rep.Guid(), "failed to release reservation:", err)
+		return nil, RequestFailed
+	}
+
+	return SuccessResponse, NoError
+}
+
+func (d *RepDispatcher) claim(codec Codec, payload []byte) ([]byte, ErrorCode) {
+	var instance types.Instance
+	if !d.decode(codec, ClaimSubject, payload, &instance) {
+		return nil, BadRequest
+	}
+
+	err := d.rep.Claim(instance)
+	if err != nil {
+		log.Println(d.rep.Guid(), "failed to claim:", err)
+		return nil, RequestFailed
+	}
+
+	return SuccessResponse, NoError
+}
+
+func (d *RepDispatcher) decode(codec Codec, subject string, payload []byte, v interface{}) bool {
+	err := codec.Unmarshal(payload, v)
+	if err != nil {
+		log.Println(d.rep.Guid(), "invalid", subject, "request:", err)
+		return false
+	}
+
+	return true
+}
+
+func (d *RepDispatcher) encode(codec Codec, v interface{}) ([]byte, ErrorCode) {
+	out, err := codec.Marshal(v)
+	if err != nil {
+		return nil, RequestFailed
+	}
+
+	return out, NoError
+}
+
+func (d *RepDispatcher) encodeScore(codec Codec, score float64, generation uint64, err error) ([]byte, ErrorCode) {
+	response := types.ScoreResult{
+		Rep:        d.rep.Guid(),
+		Generation: generation,
+	}
+
+	if err != nil {
+		response.Error, response.Message = types.ScoreErrorFor(err)
+	} else {
+		response.Score = score
+	}
+
+	return d.encode(codec, response)
+}
@@ -0,0 +1,296 @@
+package communication_test
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/onsi/auction/auctionrep"
+	. "github.com/onsi/auction/communication"
+	"github.com/onsi/auction/simulation/simulationrepdelegate"
+	"github.com/onsi/auction/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RepDispatcher", func() {
+	var dispatcher *RepDispatcher
+	var instance types.Instance
+	var instancePayload []byte
+
+	BeforeEach(func() {
+		rep := auctionrep.New("rep-guid", simulationrepdelegate.New(types.Resources{
+			MemoryMB:   100,
+			DiskMB:     100,
+			Containers: 10,
+		}))
+		dispatcher = NewRepDispatcher(rep, nil, nil)
+
+		instance = types.Instance{
+			AppGuid:      "app-guid",
+			InstanceGuid: "instance-guid",
+			Resources:    types.Resources{MemoryMB: 1, DiskMB: 1},
+		}
+		instancePayload, _ = json.Marshal(instance)
+	})
+
+	decodeScore := func(payload []byte) types.ScoreResult {
+		var result types.ScoreResult
+		Ω(json.Unmarshal(payload, &result)).ShouldNot(HaveOccurred())
+		return result
+	}
+
+	It("should handle every subject", func() {
+		Ω(dispatcher.Subjects()).Should(HaveLen(9))
+	})
+
+	It("should score instances", func() {
+		result := decodeScore(dispatcher.Dispatch(ScoreSubject, JSON.ContentType(), instancePayload))
+		Ω(result.Rep).Should(Equal("rep-guid"))
+		Ω(result.Error).Should(BeEmpty())
+	})
+
+	Describe("envelopes", func() {
+		request := func(deadline time.Time) Envelope {
+			return Envelope{
+				Version:     ProtocolVersion,
+				RequestID:   "request-id",
+				Deadline:    deadline,
+				ContentType: JSON.ContentType(),
+				Body:        instancePayload,
+			}
+		}
+
+		It("should reply in the request's version, with its request id", func() {
+			reply, ok := dispatcher.DispatchEnvelope(ScoreSubject, request(time.Time{}))
+			Ω(ok).Should(BeTrue())
+			Ω(reply.Version).Should(Equal(ProtocolVersion))
+			Ω(reply.RequestID).Should(Equal("request-id"))
+			Ω(reply.Error).Should(Equal(NoError))
+			Ω(decodeScore(reply.Body).Rep).Should(Equal("rep-guid"))
+		})
+
+		It("should reply with error codes rather than an error body", func() {
+			reply, _ := dispatcher.DispatchEnvelope("bogus", request(time.Time{}))
+			Ω(reply.Error).Should(Equal(UnknownSubject))
+
+			reply, _ = dispatcher.DispatchEnvelope(ClaimSubject, request(time.Time{}))
+			Ω(reply.Error).Should(Equal(RequestFailed))
+
+			garbage := request(time.Time{})
+			garbage.Body = []byte("{garbage")
+			reply, _ = dispatcher.DispatchEnvelope(ScoreSubject, garbage)
+			Ω(reply.Error).Should(Equal(BadRequest))
+		})
+
+		It("should not answer in a version newer than its own", func() {
+			future := request(time.Time{})
+			future.Version = ProtocolVersion + 1
+			reply, _ := dispatcher.DispatchEnvelope(ScoreSubject, future)
+			Ω(reply.Version).Should(Equal(ProtocolVersion))
+		})
+	})
+
+	Describe("signing", func() {
+		var signer *Signer
+		var request Envelope
+
+		BeforeEach(func() {
+			rep := auctionrep.New("rep-guid", simulationrepdelegate.New(types.Resources{
+				MemoryMB:   100,
+				DiskMB:     100,
+				Containers: 10,
+			}))
+			signer = NewSigner([]byte("shared-key"))
+			dispatcher = NewRepDispatcher(rep, signer, nil)
+
+			request = Envelope{
+				Version:     ProtocolVersion,
+				RequestID:   "request-id",
+				Deadline:    time.Now().Add(time.Second),
+				ContentType: JSON.ContentType(),
+				Body:        instancePayload,
+			}
+		})
+
+		expectUnauthorized := func(subject string, request Envelope) {
+			reply, ok := dispatcher.DispatchEnvelope(subject, request)
+			Ω(ok).Should(BeTrue())
+			Ω(reply.Error).Should(Equal(Unauthorized))
+			Ω(reply.Error.Err()).Should(Equal(UnauthorizedError))
+			Ω(signer.Verify("rep-guid", subject, reply)).ShouldNot(HaveOccurred())
+		}
+
+		It("should answer signed requests with signed replies", func() {
+			reply, ok := dispatcher.DispatchEnvelope(ScoreSubject, signer.Sign("rep-guid", ScoreSubject, request))
+			Ω(ok).Should(BeTrue())
+			Ω(reply.Error).Should(Equal(NoError))
+			Ω(signer.Verify("rep-guid", ScoreSubject, reply)).ShouldNot(HaveOccurred())
+		})
+
+		It("should answer broadcast score requests, which are addressed to no rep in particular", func() {
+			reply, ok := dispatcher.DispatchEnvelope(BroadcastScoreSubject, signer.Sign("", BroadcastScoreSubject, request))
+			Ω(ok).Should(BeTrue())
+			Ω(reply.Error).Should(Equal(NoError))
+			Ω(signer.Verify("", BroadcastScoreSubject, reply)).ShouldNot(HaveOccurred())
+			Ω(decodeScore(reply.Body).Rep).Should(Equal("rep-guid"))
+		})
+
+		It("should refuse unsigned and badly signed requests", func() {
+			expectUnauthorized(ScoreThenTentativelyReserveSubject, request)
+			expectUnauthorized(ScoreThenTentativelyReserveSubject, NewSigner([]byte("another-key")).Sign("rep-guid", ScoreThenTentativelyReserveSubject, request))
+		})
+
+		It("should refuse requests replayed on another subject", func() {
+			expectUnauthorized(ClaimSubject, signer.Sign("rep-guid", ScoreThenTentativelyReserveSubject, request))
+			expectUnauthorized(ReleaseReservationSubject, signer.Sign("rep-guid", ClaimSubject, request))
+		})
+
+		It("should refuse requests replayed from another rep", func() {
+			expectUnauthorized(ClaimSubject, signer.Sign("another-rep-guid", ClaimSubject, request))
+			expectUnauthorized(ClaimSubject, signer.Sign("", ClaimSubject, request))
+		})
+
+		It("should refuse signed requests without a deadline", func() {
+			request.Deadline = time.Time{}
+			expectUnauthorized(ClaimSubject, signer.Sign("rep-guid", ClaimSubject, request))
+		})
+
+		It("should drop signed requests once their deadline has passed, claims and releases included", func() {
+			request.Deadline = time.Now().Add(-time.Second)
+			for _, subject := range []string{ClaimSubject, ReleaseReservationSubject, InstancesSubject} {
+				_, ok := dispatcher.DispatchEnvelope(subject, signer.Sign("rep-guid", subject, request))
+				Ω(ok).Should(BeFalse(), subject)
+			}
+			Ω(dispatcher.Expired()).Should(Equal(uint64(3)))
+		})
+
+		It("should refuse legacy requests, which can't be signed", func() {
+			Ω(dispatcher.Dispatch(ScoreSubject, JSON.ContentType(), instancePayload)).Should(Equal(ErrorResponse))
+		})
+	})
+
+	Describe("deadlines", func() {
+		dispatch := func(deadline time.Time, subject string) ([]byte, bool) {
+			reply, ok := dispatcher.DispatchEnvelope(subject, Envelope{Deadline: deadline, ContentType: JSON.ContentType(), Body: instancePayload})
+			return reply.LegacyBody(), ok
+		}
+
+		past := func() time.Time { return time.Now().Add(-time.Second) }
+
+		It("should drop scores and reservations that have expired, and count them", func() {
+			_, ok := dispatch(past(), ScoreSubject)
+			Ω(ok).Should(BeFalse())
+			_, ok = dispatch(past(), ScoreThenTentativelyReserveSubject)
+			Ω(ok).Should(BeFalse())
+			Ω(dispatcher.Expired()).Should(Equal(uint64(2)))
+
+			Ω(dispatcher.Dispatch(InstancesSubject, JSON.ContentType(), nil)).Should(Equal([]byte("[]")))
+		})
+
+		It("should honour requests that haven't expired", func() {
+			reply, ok := dispatch(time.Now().Add(time.Second), ScoreSubject)
+			Ω(ok).Should(BeTrue())
+			Ω(decodeScore(reply).Error).Should(BeEmpty())
+			Ω(dispatcher.Expired()).Should(BeZero())
+		})
+
+		It("should honour unsigned claims and releases whatever their deadline", func() {
+			decodeScore(dispatcher.Dispatch(ScoreThenTentativelyReserveSubject, JSON.ContentType(), instancePayload))
+
+			reply, ok := dispatch(past(), ClaimSubject)
+			Ω(ok).Should(BeTrue())
+			Ω(reply).Should(Equal(SuccessResponse))
+			Ω(dispatcher.Expired()).Should(BeZero())
+		})
+	})
+
+	Describe("admission", func() {
+		var admission *Admission
+
+		BeforeEach(func() {
+			rep := auctionrep.New("rep-guid", simulationrepdelegate.New(types.Resources{
+				MemoryMB:   100,
+				DiskMB:     100,
+				Containers: 10,
+			}))
+			var err error
+			admission, err = NewAdmission(1, 0)
+			Ω(err).ShouldNot(HaveOccurred())
+			dispatcher = NewRepDispatcher(rep, nil, admission)
+		})
+
+		It("should answer bids that can't be admitted with a busy score", func() {
+			Ω(admission.Admit()).Should(BeTrue())
+
+			for _, subject := range []string{ScoreSubject, ScoreThenTentativelyReserveSubject} {
+				result := decodeScore(dispatcher.Dispatch(subject, JSON.ContentType(), instancePayload))
+				Ω(result.Rep).Should(Equal("rep-guid"))
+				Ω(result.Error).Should(Equal(types.RepBusy))
+			}
+			Ω(dispatcher.Busy()).Should(Equal(uint64(2)))
+
+			Ω(dispatcher.Dispatch(InstancesSubject, JSON.ContentType(), nil)).Should(Equal([]byte("[]")))
+
+			admission.Done()
+			Ω(decodeScore(dispatcher.Dispatch(ScoreSubject, JSON.ContentType(), instancePayload)).Error).Should(BeEmpty())
+		})
+	})
+
+	It("should reserve and then claim instances", func() {
+		result := decodeScore(dispatcher.Dispatch(ScoreThenTentativelyReserveSubject, JSON.ContentType(), instancePayload))
+		Ω(result.Error).Should(BeEmpty())
+
+		Ω(dispatcher.Dispatch(ClaimSubject, JSON.ContentType(), instancePayload)).Should(Equal(SuccessResponse))
+
+		var instances []types.Instance
+		Ω(json.Unmarshal(dispatcher.Dispatch(InstancesSubject, JSON.ContentType(), nil), &instances)).ShouldNot(HaveOccurred())
+		Ω(instances).Should(Equal([]types.Instance{instance}))
+	})
+
+	It("should report scoring failures in the score result", func() {
+		full := make([]types.Instance, 10)
+		for i := range full {
+			full[i] = types.Instance{InstanceGuid: string(rune('a' + i))}
+		}
+		payload, _ := json.Marshal(full)
+		Ω(dispatcher.Dispatch(SetInstancesSubject, JSON.ContentType(), payload)).Should(Equal(SuccessResponse))
+
+		result := decodeScore(dispatcher.Dispatch(ScoreSubject, JSON.ContentType(), instancePayload))
+		Ω(result.Rep).Should(Equal("rep-guid"))
+		Ω(result.Error).Should(Equal(types.InsufficientContainers))
+	})
+
+	It("should respond with an error when the rep can't claim or release", func() {
+		Ω(dispatcher.Dispatch(ClaimSubject, JSON.ContentType(), instancePayload)).Should(Equal(ErrorResponse))
+		Ω(dispatcher.Dispatch(ReleaseReservationSubject, JSON.ContentType(), instancePayload)).Should(Equal(ErrorResponse))
+	})
+
+	It("should respond with an error to undecodable payloads on every subject that takes one", func() {
+		for _, subject := range []string{SetInstancesSubject, ScoreSubject, ScoreThenTentativelyReserveSubject, TentativelyReserveIfUnchangedSubject, ReleaseReservationSubject, ClaimSubject} {
+			Ω(dispatcher.Dispatch(subject, JSON.ContentType(), []byte("{garbage"))).Should(Equal(ErrorResponse), subject)
+		}
+	})
+
+	It("should respond in the codec it was spoken to in", func() {
+		payload, err := MsgPack.Marshal(instance)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		var result types.ScoreResult
+		Ω(MsgPack.Unmarshal(dispatcher.Dispatch(ScoreSubject, MsgPack.ContentType(), payload), &result)).ShouldNot(HaveOccurred())
+		Ω(result.Rep).Should(Equal("rep-guid"))
+		Ω(result.Error).Should(BeEmpty())
+	})
+
+	It("should treat a missing content type as JSON", func() {
+		result := decodeScore(dispatcher.Dispatch(ScoreSubject, "", instancePayload))
+		Ω(result.Rep).Should(Equal("rep-guid"))
+	})
+
+	It("should respond with an error to unknown content types", func() {
+		Ω(dispatcher.Dispatch(ScoreSubject, "text/plain", instancePayload)).Should(Equal(ErrorResponse))
+	})
+
+	It("should respond with an error to unknown subjects", func() {
+		Ω(dispatcher.Dispatch("bogus", JSON.ContentType(), instancePayload)).Should(Equal(ErrorResponse))
+	})
+})
@@ -0,0 +1,298 @@
+package communication
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// ProtocolVersion is the version of the envelope this code speaks.  Version 0
+// is the legacy protocol: a bare (or, on NATS, framed) body, with failures
+// signalled by ErrorResponse.
+//
+// Reps understand every version and answer each request in the version it was
+// made in, so during a rolling upgrade reps should be upgraded before
+// auctioneers.
+const ProtocolVersion = 1
+
+// An ErrorCode says why a rep couldn't carry out a request.  Failures to score
+// or reserve are not error codes: they are reported in the ScoreResult.
+type ErrorCode string
+
+const (
+	NoError            ErrorCode = ""
+	RequestFailed      ErrorCode = "request_failed"
+	BadRequest         ErrorCode = "bad_request"
+	UnknownSubject     ErrorCode = "unknown_subject"
+	UnknownContentType ErrorCode = "unknown_content_type"
+	Unauthorized       ErrorCode = "unauthorized"
+)
+
+var BadRequestError = errors.New("bad request")
+var UnknownSubjectError = errors.New("unknown subject")
+var UnauthorizedError = errors.New("unauthorized")
+
+// Err is the error a client reports for the code.
+func (code ErrorCode) Err() error {
+	switch code {
+	case NoError:
+		return nil
+	case BadRequest:
+		return BadRequestError
+	case UnknownSubject:
+		return UnknownSubjectError
+	case UnknownContentType:
+		return UnknownContentTypeError
+	case Unauthorized:
+		return UnauthorizedError
+	default:
+		return RequestFailedError
+	}
+}
+
+// An Envelope wraps every request and reply.  Transports with headers carry
+// the envelope's fields in them (see Headers); NATS has none and uses Seal.
+type Envelope struct {
+	Version     int
+	RequestID   string
+	Deadline    time.Time
+	ContentType string
+	Error       ErrorCode
+	Body        []byte
+	Signature   []byte
+}
+
+// Reply returns an envelope answering e, in the same version and codec.
+func (e Envelope) Reply(body []byte, code ErrorCode) Envelope {
+	version := e.Version
+	if version > ProtocolVersion {
+		version = ProtocolVersion
+	}
+
+	return Envelope{
+		Version:     version,
+		RequestID:   e.RequestID,
+		ContentType: e.ContentType,
+		Error:       code,
+		Body:        body,
+	}
+}
+
+// LegacyBody is the body a version 0 peer expects.
+func (e Envelope) LegacyBody() []byte {
+	if e.Error != NoError {
+		return ErrorResponse
+	}
+	return e.Body
+}
+
+// Decode interprets a reply.  resp may be nil when the caller only cares
+// whether the request succeeded.
+func (e Envelope) Decode(resp interface{}) error {
+	if e.Version == 0 {
+		return DecodeResponse(e.ContentType, e.Body, resp)
+	}
+
+	if e.Error != NoError {
+		return e.Error.Err()
+	}
+
+	if resp == nil {
+		return nil
+	}
+
+	codec, err := CodecForContentType(e.ContentType)
+	if err != nil {
+		return err
+	}
+
+	return codec.Unmarshal(e.Body, resp)
+}
+
+const (
+	VersionHeader   = "X-Auction-Version"
+	RequestIDHeader = "X-Auction-Request-Id"
+	DeadlineHeader  = "X-Auction-Deadline"
+	ErrorHeader     = "X-Auction-Error"
+	SignatureHeader = "X-Auction-Signature"
+)
+
+// Headers are the envelope's fields, other than its content type and body,
+// for transports that have headers.  Version 0 envelopes have none.
+func (e Envelope) Headers() map[string]string {
+	headers := map[string]string{}
+	if e.Version == 0 {
+		return headers
+	}
+
+	headers[VersionHeader] = strconv.Itoa(e.Version)
+	if e.RequestID != "" {
+		headers[RequestIDHeader] = e.RequestID
+	}
+	if !e.Deadline.IsZero() {
+		headers[DeadlineHeader] = strconv.FormatInt(e.Deadline.UnixNano(), 10)
+	}
+	if e.Error != NoError {
+		headers[ErrorHeader] = string(e.Error)
+	}
+	if len(e.Signature) > 0 {
+		headers[SignatureHeader] = base64.StdEncoding.EncodeToString(e.Signature)
+	}
+
+	return headers
+}
+
+// Size is the number of bytes e takes on transports with headers: its body,
+// its content type and the names and values of its headers.
+func (e Envelope) Size() int {
+	size := len(e.Body) + len(e.ContentType)
+	for key, value := range e.Headers() {
+		size += len(key) + len(value)
+	}
+	return size
+}
+
+// EnvelopeFromHeaders is the inverse of Headers.  Messages without a version
+// header are version 0; so that version 0 peers can still set a deadline,
+// the deadline header is honoured either way.
+func EnvelopeFromHeaders(contentType string, header func(string) string, body []byte) Envelope {
+	e := Envelope{
+		ContentType: contentType,
+		Body:        body,
+	}
+
+	nanos, err := strconv.ParseInt(header(DeadlineHeader), 10, 64)
+	if err == nil {
+		e.Deadline = time.Unix(0, nanos)
+	}
+
+	e.Version, err = strconv.Atoi(header(VersionHeader))
+	if err != nil {
+		e.Version = 0
+		return e
+	}
+
+	e.RequestID = header(RequestIDHeader)
+	e.Error = ErrorCode(header(ErrorHeader))
+	e.Signature, _ = base64.StdEncoding.DecodeString(header(SignatureHeader))
+	if len(e.Signature) == 0 {
+		e.Signature = nil
+	}
+
+	return e
+}
+
+// Sealed envelopes start with envelopeMagic, which can't start a legacy
+// frame, then the version and a series of fields, each a tag, a uvarint length
+// and a value.  A zero tag ends the fields and the rest is the body.  Readers
+// skip tags they don't know, so later versions can add fields.
+const envelopeMagic = 0xFF
+
+const (
+	endTag byte = iota
+	requestIDTag
+	deadlineTag
+	contentTypeTag
+	errorTag
+	signatureTag
+)
+
+// Seal encodes e for transports without headers.  Version 0 envelopes are
+// framed as legacy peers expect.
+func Seal(e Envelope) []byte {
+	if e.Version == 0 {
+		if e.Deadline.IsZero() {
+			return Frame(e.ContentType, e.LegacyBody())
+		}
+		return FrameWithDeadline(e.ContentType, e.Deadline, e.LegacyBody())
+	}
+
+	sealed := &bytes.Buffer{}
+	sealed.WriteByte(envelopeMagic)
+	sealed.WriteByte(byte(e.Version))
+
+	field := func(tag byte, value []byte) {
+		length := make([]byte, binary.MaxVarintLen64)
+		sealed.WriteByte(tag)
+		sealed.Write(length[:binary.PutUvarint(length, uint64(len(value)))])
+		sealed.Write(value)
+	}
+
+	if e.RequestID != "" {
+		field(requestIDTag, []byte(e.RequestID))
+	}
+	if !e.Deadline.IsZero() {
+		deadline := make([]byte, 8)
+		binary.BigEndian.PutUint64(deadline, uint64(e.Deadline.UnixNano()))
+		field(deadlineTag, deadline)
+	}
+	field(contentTypeTag, []byte(e.ContentType))
+	if e.Error != NoError {
+		field(errorTag, []byte(e.Error))
+	}
+	if len(e.Signature) > 0 {
+		field(signatureTag, e.Signature)
+	}
+
+	sealed.WriteByte(endTag)
+	sealed.Write(e.Body)
+
+	return sealed.Bytes()
+}
+
+// Open decodes sealed envelopes, and legacy frames and bare JSON as version 0
+// envelopes.
+func Open(sealed []byte) (Envelope, error) {
+	if len(sealed) == 0 || sealed[0] != envelopeMagic {
+		contentType, deadline, body, err := UnframeWithDeadline(sealed)
+		if err != nil {
+			return Envelope{}, err
+		}
+		return Envelope{ContentType: contentType, Deadline: deadline, Body: body}, nil
+	}
+
+	if len(sealed) < 2 {
+		return Envelope{}, errors.New("truncated envelope")
+	}
+
+	e := Envelope{Version: int(sealed[1])}
+	rest := sealed[2:]
+
+	for {
+		if len(rest) == 0 {
+			return Envelope{}, errors.New("truncated envelope")
+		}
+
+		tag := rest[0]
+		rest = rest[1:]
+		if tag == endTag {
+			e.Body = rest
+			return e, nil
+		}
+
+		length, n := binary.Uvarint(rest)
+		if n <= 0 || uint64(len(rest)-n) < length {
+			return Envelope{}, errors.New("truncated envelope")
+		}
+		value := rest[n : n+int(length)]
+		rest = rest[n+int(length):]
+
+		switch tag {
+		case requestIDTag:
+			e.RequestID = string(value)
+		case deadlineTag:
+			if len(value) == 8 {
+				e.Deadline = time.Unix(0, int64(binary.BigEndian.Uint64(value)))
+			}
+		case contentTypeTag:
+			e.ContentType = string(value)
+		case errorTag:
+			e.Error = ErrorCode(value)
+		case signatureTag:
+			e.Signature = value
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package communication_test
+
+import (
+	"time"
+
+	. "github.com/onsi/auction/communication"
+	"github.com/onsi/auction/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Envelopes", func() {
+	var envelope Envelope
+
+	BeforeEach(func() {
+		envelope = Envelope{
+			Version:     ProtocolVersion,
+			RequestID:   "request-id",
+			Deadline:    time.Unix(0, time.Now().UnixNano()),
+			ContentType: MsgPack.ContentType(),
+			Error:       RequestFailed,
+			Body:        []byte("body"),
+		}
+	})
+
+	Describe("sealing", func() {
+		It("should round trip every field", func() {
+			Ω(Open(Seal(envelope))).Should(Equal(envelope))
+		})
+
+		It("should open legacy frames and bare JSON as version 0", func() {
+			opened, err := Open(Frame(MsgPack.ContentType(), []byte("body")))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(opened).Should(Equal(Envelope{ContentType: MsgPack.ContentType(), Body: []byte("body")}))
+
+			opened, err = Open([]byte(`{"a":"app-guid"}`))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(opened.Version).Should(Equal(0))
+			Ω(opened.ContentType).Should(Equal(JSON.ContentType()))
+		})
+
+		It("should seal version 0 envelopes as legacy frames", func() {
+			envelope.Version = 0
+			contentType, body, err := Unframe(Seal(envelope))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(contentType).Should(Equal(MsgPack.ContentType()))
+			Ω(body).Should(Equal(ErrorResponse))
+		})
+
+		It("should skip fields it doesn't know about", func() {
+			sealed := Seal(Envelope{Version: ProtocolVersion + 1, ContentType: JSON.ContentType(), Body: []byte("body")})
+			//splice in a field with tag 99 just after the version
+			sealed = append(append(append([]byte{}, sealed[:2]...), 99, 3, 'x', 'y', 'z'), sealed[2:]...)
+
+			opened, err := Open(sealed)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(opened.Version).Should(Equal(ProtocolVersion + 1))
+			Ω(opened.ContentType).Should(Equal(JSON.ContentType()))
+			Ω(opened.Body).Should(Equal([]byte("body")))
+		})
+
+		It("should reject truncated envelopes", func() {
+			sealed := Seal(envelope)
+			for _, n := range []int{1, 2, 5} {
+				_, err := Open(sealed[:n])
+				Ω(err).Should(HaveOccurred(), "%d", n)
+			}
+		})
+	})
+
+	Describe("headers", func() {
+		It("should round trip every field", func() {
+			headers := envelope.Headers()
+			opened := EnvelopeFromHeaders(MsgPack.ContentType(), func(key string) string { return headers[key] }, []byte("body"))
+			Ω(opened).Should(Equal(envelope))
+		})
+
+		It("should treat messages without a version header as version 0", func() {
+			opened := EnvelopeFromHeaders(JSON.ContentType(), func(string) string { return "" }, []byte("body"))
+			Ω(opened.Version).Should(Equal(0))
+			Ω(envelope.Reply(nil, NoError).Version).Should(Equal(ProtocolVersion))
+		})
+
+		It("should size the body, the content type and the headers", func() {
+			size := len("body") + len(MsgPack.ContentType())
+			for key, value := range envelope.Headers() {
+				size += len(key) + len(value)
+			}
+			Ω(envelope.Size()).Should(Equal(size))
+			Ω(Envelope{ContentType: "a/b", Body: []byte("body")}.Size()).Should(Equal(7))
+		})
+	})
+
+	Describe("decoding replies", func() {
+		It("should turn error codes into errors", func() {
+			Ω(Envelope{Version: 1, Error: RequestFailed}.Decode(nil)).Should(Equal(RequestFailedError))
+			Ω(Envelope{Version: 1, Error: BadRequest}.Decode(nil)).Should(Equal(BadRequestError))
+			Ω(Envelope{Version: 1, Error: UnknownSubject}.Decode(nil)).Should(Equal(UnknownSubjectError))
+			Ω(Envelope{Version: 1, Error: "something-new"}.Decode(nil)).Should(Equal(RequestFailedError))
+		})
+
+		It("should decode the body with the envelope's codec", func() {
+			body, _ := MsgPack.Marshal(types.ScoreResult{Rep: "rep", Score: 0.5})
+			var result types.ScoreResult
+			Ω(Envelope{Version: 1, ContentType: MsgPack.ContentType(), Body: body}.Decode(&result)).ShouldNot(HaveOccurred())
+			Ω(result).Should(Equal(types.ScoreResult{Rep: "rep", Score: 0.5}))
+		})
+
+		It("should understand legacy error responses", func() {
+			Ω(Envelope{Body: ErrorResponse}.Decode(nil)).Should(Equal(RequestFailedError))
+		})
+	})
+})
@@ -0,0 +1,49 @@
+package communication
+
+import "github.com/onsi/auction/types"
+
+var RequestFailedError error = types.RequestFailed
+var TimeoutError error = types.Timeout
+
+// TransportError is reported when a request never made it to (or back from) a
+// rep, as opposed to the rep failing to handle it.
+type TransportError struct {
+	Err error
+}
+
+func (e TransportError) Error() string {
+	return types.TransportFailure.Error() + ": " + e.Err.Error()
+}
+
+// ErrorResult is the ScoreResult reported for a rep that did not answer a
+// batched request.  Every transport returns exactly one result per requested
+// rep, so reps that time out or can't be reached show up this way rather than
+// going missing.
+func ErrorResult(guid string, err error) types.ScoreResult {
+	result := types.ScoreResult{Rep: guid}
+
+	if transportErr, ok := err.(TransportError); ok {
+		result.Error, result.Message = types.TransportFailure, transportErr.Err.Error()
+	} else {
+		result.Error, result.Message = types.ScoreErrorFor(err)
+	}
+
+	return result
+}
+
+// TransportErrorFor is the error reported when a transport fails to carry a
+// request or its reply: TimeoutError if it timed out, a TransportError
+// otherwise.
+func TransportErrorFor(err error) error {
+	if err == TimeoutError {
+		return err
+	}
+
+	if timeoutErr, ok := err.(interface {
+		Timeout() bool
+	}); ok && timeoutErr.Timeout() {
+		return TimeoutError
+	}
+
+	return TransportError{Err: err}
+}
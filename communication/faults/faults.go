@@ -0,0 +1,379 @@
+// Package faults wraps a RepPoolClient and injects the faults a real network
+// has into its requests: latency, dropped requests and replies, and
+// partitions.  It works with any client, so the auction can be chaos-tested
+// over nats and rabbit as well as in-process.  Duplicated replies are a matter
+// for the transport, which must collapse them: the fake nats bus and rabbit
+// broker can inject them.
+//
+// Faults are injected per rep: each rule applies to the reps whose guid
+// matches its pattern (as in path.Match, so "*" matches every rep), and the
+// most recently injected rule that matches a rep wins.  A request that is
+// dropped, or whose latency exceeds the timeout, fails with TimeoutError once
+// the timeout has passed, just as it would on the wire.
+package faults
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/onsi/auction/communication"
+	"github.com/onsi/auction/types"
+)
+
+// A Latency is a distribution of delays.
+type Latency interface {
+	Sample() time.Duration
+	String() string
+}
+
+type fixed time.Duration
+
+// Fixed delays every request by d.
+func Fixed(d time.Duration) Latency {
+	return fixed(d)
+}
+
+func (l fixed) Sample() time.Duration { return time.Duration(l) }
+func (l fixed) String() string        { return time.Duration(l).String() }
+
+type uniform struct {
+	min, max time.Duration
+}
+
+// Uniform delays requests by anything from min to max.
+func Uniform(min time.Duration, max time.Duration) Latency {
+	return uniform{min: min, max: max}
+}
+
+func (l uniform) Sample() time.Duration {
+	return l.min + time.Duration(rand.Float64()*float64(l.max-l.min))
+}
+
+func (l uniform) String() string {
+	return fmt.Sprintf("%s-%s", l.min, l.max)
+}
+
+type normal struct {
+	mean, stddev time.Duration
+}
+
+// Normal delays requests by a normally distributed amount, never less than 0.
+func Normal(mean time.Duration, stddev time.Duration) Latency {
+	return normal{mean: mean, stddev: stddev}
+}
+
+func (l normal) Sample() time.Duration {
+	return time.Duration(math.Max(0, rand.NormFloat64()*float64(l.stddev)+float64(l.mean)))
+}
+
+func (l normal) String() string {
+	return fmt.Sprintf("%s±%s", l.mean, l.stddev)
+}
+
+type exponential struct {
+	min, mean time.Duration
+}
+
+// Exponential delays requests by at least min, with a long tail: the delay
+// beyond min is exponentially distributed with the given mean.
+func Exponential(min time.Duration, mean time.Duration) Latency {
+	return exponential{min: min, mean: mean}
+}
+
+func (l exponential) Sample() time.Duration {
+	return l.min + time.Duration(rand.ExpFloat64()*float64(l.mean))
+}
+
+func (l exponential) String() string {
+	return fmt.Sprintf("%s+exp(%s)", l.min, l.mean)
+}
+
+// Faults are the faults injected into requests to a rep.  The rates are
+// probabilities, from 0 to 1, applied to each request independently.
+type Faults struct {
+	Latency Latency
+	// DropRequests is the rate at which requests never reach the rep.
+	DropRequests float64
+	// DropReplies is the rate at which the rep handles a request but its
+	// reply is lost.
+	DropReplies float64
+}
+
+func (f Faults) String() string {
+	components := []string{}
+	if f.Latency != nil {
+		components = append(components, "latency "+f.Latency.String())
+	}
+	if f.DropRequests > 0 {
+		components = append(components, fmt.Sprintf("%.1f%% requests dropped", f.DropRequests*100))
+	}
+	if f.DropReplies > 0 {
+		components = append(components, fmt.Sprintf("%.1f%% replies dropped", f.DropReplies*100))
+	}
+	if len(components) == 0 {
+		return "none"
+	}
+	return strings.Join(components, ", ")
+}
+
+type rule struct {
+	pattern string
+	faults  Faults
+}
+
+// A Partition cuts a set of reps off from the client until it is healed.
+type Partition struct {
+	client   *Client
+	patterns []string
+}
+
+// Heal reconnects the partitioned reps.
+func (p *Partition) Heal() {
+	p.client.lock.Lock()
+	defer p.client.lock.Unlock()
+	delete(p.client.partitions, p)
+}
+
+// Client injects faults into the requests it passes on.  Until a fault is
+// injected it passes requests on untouched.
+type Client struct {
+	types.RepPoolClient
+
+	timeout    time.Duration
+	rules      []rule
+	partitions map[*Partition]bool
+	lock       *sync.Mutex
+}
+
+// New wraps client, whose requests time out after timeout.
+func New(client types.RepPoolClient, timeout time.Duration) *Client {
+	return &Client{
+		RepPoolClient: client,
+		timeout:       timeout,
+		partitions:    map[*Partition]bool{},
+		lock:          &sync.Mutex{},
+	}
+}
+
+// TestClient is a Client around a TestRepPoolClient.  The test methods are
+// passed on untouched, since they are used to set up and inspect the reps
+// rather than to hold auctions.
+type TestClient struct {
+	*Client
+	test types.TestRepPoolClient
+}
+
+func NewTest(client types.TestRepPoolClient, timeout time.Duration) *TestClient {
+	return &TestClient{
+		Client: New(client, timeout),
+		test:   client,
+	}
+}
+
+func (c *TestClient) TotalResources(guid string) (types.Resources, error) {
+	return c.test.TotalResources(guid)
+}
+
+func (c *TestClient) Instances(guid string) ([]types.Instance, error) {
+	return c.test.Instances(guid)
+}
+
+func (c *TestClient) SetInstances(guid string, instances []types.Instance) error {
+	return c.test.SetInstances(guid, instances)
+}
+
+func (c *TestClient) Reset(guid string) error {
+	return c.test.Reset(guid)
+}
+
+// Inject applies faults to every rep whose guid matches pattern, overriding
+// any faults injected earlier.
+func (c *Client) Inject(pattern string, faults Faults) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.rules = append(c.rules, rule{pattern: pattern, faults: faults})
+}
+
+// Partition cuts the reps matching any of the patterns off: every request to
+// them is dropped, whatever faults have been injected.
+func (c *Client) Partition(patterns ...string) *Partition {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	partition := &Partition{client: c, patterns: patterns}
+	c.partitions[partition] = true
+	return partition
+}
+
+// Clear removes every fault and heals every partition.
+func (c *Client) Clear() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.rules = nil
+	c.partitions = map[*Partition]bool{}
+}
+
+func (c *Client) String() string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	descriptions := []string{}
+	for _, rule := range c.rules {
+		descriptions = append(descriptions, fmt.Sprintf("%s: %s", rule.pattern, rule.faults))
+	}
+	for partition := range c.partitions {
+		descriptions = append(descriptions, fmt.Sprintf("partitioned: %s", strings.Join(partition.patterns, ", ")))
+	}
+	descriptions = append(descriptions, fmt.Sprintf("timeout %s", c.timeout))
+
+	return strings.Join(descriptions, "; ")
+}
+
+func matches(pattern string, guid string) bool {
+	matched, err := path.Match(pattern, guid)
+	return err == nil && matched
+}
+
+// fate decides what will happen to a request to a rep
+type fate struct {
+	latency     time.Duration
+	dropRequest bool
+	dropReply   bool
+}
+
+func (c *Client) injecting() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.rules) > 0 || len(c.partitions) > 0
+}
+
+func (c *Client) fate(guid string) fate {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for partition := range c.partitions {
+		for _, pattern := range partition.patterns {
+			if matches(pattern, guid) {
+				return fate{dropRequest: true}
+			}
+		}
+	}
+
+	for i := len(c.rules) - 1; i >= 0; i-- {
+		if !matches(c.rules[i].pattern, guid) {
+			continue
+		}
+
+		faults := c.rules[i].faults
+		f := fate{
+			dropRequest: rand.Float64() < faults.DropRequests,
+			dropReply:   rand.Float64() < faults.DropReplies,
+		}
+		if faults.Latency != nil {
+			f.latency = faults.Latency.Sample()
+		}
+		return f
+	}
+
+	return fate{}
+}
+
+// send makes a request to a single rep, subject to its fate, and returns the
+// rep's result.
+func (c *Client) send(guid string, request func() types.ScoreResult) types.ScoreResult {
+	f := c.fate(guid)
+
+	if f.dropRequest || f.latency >= c.timeout {
+		time.Sleep(c.timeout)
+		return communication.ErrorResult(guid, communication.TimeoutError)
+	}
+
+	time.Sleep(f.latency)
+	result := request()
+
+	if f.dropReply {
+		time.Sleep(c.timeout - f.latency)
+		return communication.ErrorResult(guid, communication.TimeoutError)
+	}
+
+	return result
+}
+
+// batch sends each rep its request concurrently and returns the results in the
+// order the reps were given.
+func (c *Client) batch(guids []string, request func(i int) types.ScoreResult) types.ScoreResults {
+	results := make(types.ScoreResults, len(guids))
+
+	wg := &sync.WaitGroup{}
+	wg.Add(len(guids))
+	for i, guid := range guids {
+		go func(i int, guid string) {
+			defer wg.Done()
+			results[i] = c.send(guid, func() types.ScoreResult {
+				return request(i)
+			})
+		}(i, guid)
+	}
+	wg.Wait()
+
+	return results
+}
+
+func (c *Client) Score(guids []string, instance types.Instance) types.ScoreResults {
+	if !c.injecting() {
+		return c.RepPoolClient.Score(guids, instance)
+	}
+
+	return c.batch(guids, func(i int) types.ScoreResult {
+		return c.RepPoolClient.Score(guids[i:i+1], instance)[0]
+	})
+}
+
+func (c *Client) ScoreThenTentativelyReserve(guids []string, instance types.Instance) types.ScoreResults {
+	if !c.injecting() {
+		return c.RepPoolClient.ScoreThenTentativelyReserve(guids, instance)
+	}
+
+	return c.batch(guids, func(i int) types.ScoreResult {
+		return c.RepPoolClient.ScoreThenTentativelyReserve(guids[i:i+1], instance)[0]
+	})
+}
+
+func (c *Client) TentativelyReserveIfUnchanged(scores types.ScoreResults, instance types.Instance) types.ScoreResults {
+	if !c.injecting() {
+		return c.RepPoolClient.TentativelyReserveIfUnchanged(scores, instance)
+	}
+
+	return c.batch(scores.Reps(), func(i int) types.ScoreResult {
+		return c.RepPoolClient.TentativelyReserveIfUnchanged(scores[i:i+1], instance)[0]
+	})
+}
+
+func (c *Client) ReleaseReservation(guids []string, instance types.Instance) {
+	if !c.injecting() {
+		c.RepPoolClient.ReleaseReservation(guids, instance)
+		return
+	}
+
+	c.batch(guids, func(i int) types.ScoreResult {
+		c.RepPoolClient.ReleaseReservation(guids[i:i+1], instance)
+		return types.ScoreResult{Rep: guids[i]}
+	})
+}
+
+func (c *Client) Claim(guid string, instance types.Instance) {
+	if !c.injecting() {
+		c.RepPoolClient.Claim(guid, instance)
+		return
+	}
+
+	c.send(guid, func() types.ScoreResult {
+		c.RepPoolClient.Claim(guid, instance)
+		return types.ScoreResult{Rep: guid}
+	})
+}
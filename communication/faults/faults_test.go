@@ -0,0 +1,150 @@
+package faults_test
+
+import (
+	"time"
+
+	"github.com/onsi/auction/auctionrep"
+	"github.com/onsi/auction/communication/conformance"
+	. "github.com/onsi/auction/communication/faults"
+	"github.com/onsi/auction/simulation/communication/inprocess"
+	"github.com/onsi/auction/simulation/simulationrepdelegate"
+	"github.com/onsi/auction/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Faults", func() {
+	var client *TestClient
+	var instance types.Instance
+	timeout := 100 * time.Millisecond
+	guids := []string{"rep-a", "rep-b", "other-c"}
+
+	BeforeEach(func() {
+		reps := map[string]*auctionrep.AuctionRep{}
+		for _, guid := range guids {
+			reps[guid] = auctionrep.New(guid, simulationrepdelegate.New(types.Resources{
+				MemoryMB:   100,
+				DiskMB:     100,
+				Containers: 10,
+			}))
+		}
+
+		client = NewTest(inprocess.New(reps, timeout), timeout)
+
+		instance = types.Instance{
+			AppGuid:      "app-guid",
+			InstanceGuid: "instance-guid",
+			Resources:    types.Resources{MemoryMB: 1, DiskMB: 1},
+		}
+	})
+
+	Describe("with latency injected", func() {
+		BeforeEach(func() {
+			client.Inject("*", Faults{Latency: Uniform(0, time.Millisecond)})
+		})
+
+		conformance.ItBehavesLikeATestRepPoolClient(func() conformance.Fixture {
+			return conformance.Fixture{
+				Client:       client,
+				Timeout:      timeout,
+				RepResources: types.Resources{MemoryMB: 100, DiskMB: 100, Containers: 10},
+				Responsive:   guids,
+				Unresponsive: []string{"rep-gone"},
+			}
+		})
+	})
+
+	It("should pass requests on untouched until faults are injected", func() {
+		t := time.Now()
+		results := client.Score(guids, instance)
+		Ω(time.Since(t)).Should(BeNumerically("<", timeout/2))
+		Ω(results.FilterErrors()).Should(HaveLen(3))
+	})
+
+	It("should delay requests", func() {
+		client.Inject("*", Faults{Latency: Fixed(20 * time.Millisecond)})
+
+		t := time.Now()
+		results := client.Score(guids, instance)
+		Ω(time.Since(t)).Should(BeNumerically("~", 20*time.Millisecond, 15*time.Millisecond))
+		Ω(results.FilterErrors()).Should(HaveLen(3))
+	})
+
+	It("should time out requests whose latency exceeds the timeout", func() {
+		client.Inject("rep-a", Faults{Latency: Fixed(time.Second)})
+
+		t := time.Now()
+		results := client.Score(guids, instance)
+		Ω(time.Since(t)).Should(BeNumerically("~", timeout, 50*time.Millisecond))
+		Ω(results[0].Error).Should(Equal(types.Timeout))
+		Ω(results.FilterErrors().Reps()).Should(Equal(types.RepGuids{"rep-b", "other-c"}))
+	})
+
+	It("should drop requests before they reach the rep", func() {
+		client.Inject("rep-a", Faults{DropRequests: 1})
+
+		results := client.ScoreThenTentativelyReserve(guids, instance)
+		Ω(results[0].Error).Should(Equal(types.Timeout))
+
+		client.Claim("rep-a", instance)
+		Ω(client.Instances("rep-a")).Should(BeEmpty())
+	})
+
+	It("should drop replies after the rep has handled the request", func() {
+		client.Inject("rep-a", Faults{DropReplies: 1})
+
+		results := client.ScoreThenTentativelyReserve([]string{"rep-a"}, instance)
+		Ω(results[0].Error).Should(Equal(types.Timeout))
+
+		client.Claim("rep-a", instance)
+		Ω(client.Instances("rep-a")).Should(Equal([]types.Instance{instance}))
+	})
+
+	It("should apply faults by pattern, with later rules overriding earlier ones", func() {
+		client.Inject("*", Faults{DropRequests: 1})
+		client.Inject("rep-*", Faults{})
+
+		results := client.Score(guids, instance)
+		Ω(results.FilterErrors().Reps()).Should(Equal(types.RepGuids{"rep-a", "rep-b"}))
+		Ω(results[2].Error).Should(Equal(types.Timeout))
+	})
+
+	Describe("partitions", func() {
+		It("should cut the reps off until the partition is healed", func() {
+			partition := client.Partition("rep-a", "other-*")
+
+			results := client.Score(guids, instance)
+			Ω(results.FilterErrors().Reps()).Should(Equal(types.RepGuids{"rep-b"}))
+
+			partition.Heal()
+			results = client.Score(guids, instance)
+			Ω(results.FilterErrors()).Should(HaveLen(3))
+		})
+
+		It("should override injected faults", func() {
+			client.Inject("rep-a", Faults{Latency: Fixed(time.Millisecond)})
+			client.Partition("rep-a")
+
+			Ω(client.Score([]string{"rep-a"}, instance)[0].Error).Should(Equal(types.Timeout))
+		})
+	})
+
+	It("should clear every fault and partition", func() {
+		client.Inject("*", Faults{DropRequests: 1})
+		client.Partition("rep-b")
+		client.Clear()
+
+		Ω(client.Score(guids, instance).FilterErrors()).Should(HaveLen(3))
+	})
+
+	It("should describe the faults it injects", func() {
+		client.Inject("rep-*", Faults{Latency: Uniform(time.Millisecond, 2*time.Millisecond), DropRequests: 0.1})
+		Ω(client.String()).Should(Equal("rep-*: latency 1ms-2ms, 10.0% requests dropped; timeout 100ms"))
+	})
+
+	It("should leave the test methods alone", func() {
+		client.Partition("*")
+		Ω(client.SetInstances("rep-a", []types.Instance{instance})).ShouldNot(HaveOccurred())
+		Ω(client.Instances("rep-a")).Should(Equal([]types.Instance{instance}))
+	})
+})
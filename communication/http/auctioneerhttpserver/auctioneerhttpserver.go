@@ -0,0 +1,96 @@
+package auctioneerhttpserver
+
+import (
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/onsi/auction/auctioneer"
+	"github.com/onsi/auction/communication"
+	"github.com/onsi/auction/types"
+)
+
+// Handler holds the auctions POSTed to /auction against repClient, at most
+// maxConcurrent at a time.  Requests are enveloped as they are for reps (see
+// communication.EnvelopeFromHeaders), so a bare JSON AuctionRequest is still
+// accepted and answered with a bare JSON AuctionResult.
+//
+// With a signer (which may be nil) only signed requests with a deadline are
+// accepted: others get a 401.  Replies are signed.  Requests are read, checked
+// and decoded before they wait for their turn, so only auctions that will be
+// held take up a turn.  Auctions whose deadline passes while they wait get a
+// 408 instead of being held.
+func Handler(repClient types.RepPoolClient, maxConcurrent int, signer *communication.Signer) http.Handler {
+	semaphore := make(chan bool, maxConcurrent)
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/auction", func(w http.ResponseWriter, r *http.Request) {
+		payload, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		contentType := r.Header.Get("Content-Type")
+		if contentType == "" {
+			contentType = communication.JSON.ContentType()
+		}
+		request := communication.EnvelopeFromHeaders(contentType, r.Header.Get, payload)
+
+		reply := func(status int, body []byte, code communication.ErrorCode) {
+			envelope := signer.Sign("", communication.AuctionSubject, request.Reply(body, code))
+			for key, value := range envelope.Headers() {
+				w.Header().Set(key, value)
+			}
+			w.Header().Set("Content-Type", contentType)
+			w.WriteHeader(status)
+			w.Write(body)
+		}
+
+		if signer.Verify("", communication.AuctionSubject, request) != nil || (signer != nil && request.Deadline.IsZero()) {
+			reply(http.StatusUnauthorized, nil, communication.Unauthorized)
+			return
+		}
+
+		expired := func() bool {
+			return !request.Deadline.IsZero() && time.Now().After(request.Deadline)
+		}
+
+		if expired() {
+			reply(http.StatusRequestTimeout, nil, communication.RequestFailed)
+			return
+		}
+
+		codec, err := communication.CodecForContentType(contentType)
+		if err != nil {
+			reply(http.StatusBadRequest, nil, communication.UnknownContentType)
+			return
+		}
+
+		var auctionRequest types.AuctionRequest
+		err = codec.Unmarshal(payload, &auctionRequest)
+		if err != nil {
+			reply(http.StatusBadRequest, nil, communication.BadRequest)
+			return
+		}
+
+		semaphore <- true
+		if expired() {
+			<-semaphore
+			reply(http.StatusRequestTimeout, nil, communication.RequestFailed)
+			return
+		}
+		result := auctioneer.Auction(repClient, auctionRequest)
+		<-semaphore
+
+		body, err := codec.Marshal(result)
+		if err != nil {
+			reply(http.StatusInternalServerError, nil, communication.RequestFailed)
+			return
+		}
+
+		reply(http.StatusOK, body, communication.NoError)
+	})
+
+	return mux
+}
@@ -0,0 +1,13 @@
+package auctioneerhttpserver_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestAuctioneerHTTPServer(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "AuctioneerHTTPServer Suite")
+}
@@ -0,0 +1,237 @@
+package auctioneerhttpserver_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/onsi/auction/auctionrep"
+	"github.com/onsi/auction/communication"
+	. "github.com/onsi/auction/communication/http/auctioneerhttpserver"
+	"github.com/onsi/auction/communication/testcerts"
+	"github.com/onsi/auction/simulation/communication/inprocess"
+	"github.com/onsi/auction/simulation/simulationrepdelegate"
+	"github.com/onsi/auction/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// gatedClient holds every round of bids until its gate is opened
+type gatedClient struct {
+	types.RepPoolClient
+	bidding chan struct{}
+	gate    chan struct{}
+}
+
+func (c gatedClient) wait() {
+	c.bidding <- struct{}{}
+	<-c.gate
+}
+
+func (c gatedClient) Score(guids []string, instance types.Instance) types.ScoreResults {
+	c.wait()
+	return c.RepPoolClient.Score(guids, instance)
+}
+
+func (c gatedClient) ScoreThenTentativelyReserve(guids []string, instance types.Instance) types.ScoreResults {
+	c.wait()
+	return c.RepPoolClient.ScoreThenTentativelyReserve(guids, instance)
+}
+
+var _ = Describe("AuctioneerHTTPServer", func() {
+	var repClient types.RepPoolClient
+	var auctionRequest types.AuctionRequest
+	var payload []byte
+
+	BeforeEach(func() {
+		repClient = inprocess.New(map[string]*auctionrep.AuctionRep{
+			"rep-a": auctionrep.New("rep-a", simulationrepdelegate.New(types.Resources{MemoryMB: 100, DiskMB: 100, Containers: 10})),
+		}, 100*time.Millisecond)
+
+		auctionRequest = types.AuctionRequest{
+			Instance: types.Instance{AppGuid: "app-guid", InstanceGuid: "instance-guid", Resources: types.Resources{MemoryMB: 1, DiskMB: 1}},
+			RepGuids: types.RepGuids{"rep-a"},
+			Rules:    types.AuctionRules{Algorithm: "reserve_n_best", MaxRounds: 1, MaxBiddingPool: 1},
+		}
+		payload, _ = json.Marshal(auctionRequest)
+	})
+
+	decodeResult := func(res *http.Response) types.AuctionResult {
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		var result types.AuctionResult
+		Ω(json.Unmarshal(body, &result)).ShouldNot(HaveOccurred())
+		return result
+	}
+
+	It("should hold bare JSON auctions and reply with bare JSON results", func() {
+		server := httptest.NewServer(Handler(repClient, 1, nil))
+		defer server.Close()
+
+		res, err := http.Post(server.URL+"/auction", "application/json", bytes.NewReader(payload))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(res.StatusCode).Should(Equal(http.StatusOK))
+
+		result := decodeResult(res)
+		Ω(result.Winner).Should(Equal("rep-a"))
+		Ω(result.Instance).Should(Equal(auctionRequest.Instance))
+	})
+
+	It("should refuse requests it can't decode", func() {
+		server := httptest.NewServer(Handler(repClient, 1, nil))
+		defer server.Close()
+
+		res, err := http.Post(server.URL+"/auction", "application/json", bytes.NewReader([]byte("{garbage")))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(res.StatusCode).Should(Equal(http.StatusBadRequest))
+	})
+
+	Describe("signing", func() {
+		var signer *communication.Signer
+		var server *httptest.Server
+
+		BeforeEach(func() {
+			signer = communication.NewSigner([]byte("shared-key"))
+			server = httptest.NewServer(Handler(repClient, 1, signer))
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		post := func(envelope communication.Envelope) *http.Response {
+			req, _ := http.NewRequest("POST", server.URL+"/auction", bytes.NewReader(envelope.Body))
+			req.Header.Set("Content-Type", envelope.ContentType)
+			for key, value := range envelope.Headers() {
+				req.Header.Set(key, value)
+			}
+
+			client := &http.Client{Timeout: time.Second}
+			res, err := client.Do(req)
+			Ω(err).ShouldNot(HaveOccurred())
+			return res
+		}
+
+		request := func() communication.Envelope {
+			return communication.Envelope{
+				Version:     communication.ProtocolVersion,
+				RequestID:   "request-id",
+				Deadline:    time.Now().Add(time.Second),
+				ContentType: communication.JSON.ContentType(),
+				Body:        payload,
+			}
+		}
+
+		sign := func(envelope communication.Envelope) communication.Envelope {
+			return signer.Sign("", communication.AuctionSubject, envelope)
+		}
+
+		It("should hold signed auctions and sign its replies", func() {
+			res := post(sign(request()))
+			Ω(res.StatusCode).Should(Equal(http.StatusOK))
+
+			body, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			Ω(err).ShouldNot(HaveOccurred())
+
+			reply := communication.EnvelopeFromHeaders(res.Header.Get("Content-Type"), res.Header.Get, body)
+			Ω(signer.Verify("", communication.AuctionSubject, reply)).ShouldNot(HaveOccurred())
+
+			var result types.AuctionResult
+			Ω(reply.Decode(&result)).ShouldNot(HaveOccurred())
+			Ω(result.Winner).Should(Equal("rep-a"))
+		})
+
+		It("should refuse unsigned and badly signed auctions", func() {
+			noDeadline := request()
+			noDeadline.Deadline = time.Time{}
+			unauthorized := []communication.Envelope{
+				request(),
+				communication.NewSigner([]byte("another-key")).Sign("", communication.AuctionSubject, request()),
+				signer.Sign("rep-a", communication.ScoreSubject, request()),
+				sign(noDeadline),
+			}
+
+			for _, unauthorized := range unauthorized {
+				res := post(unauthorized)
+				res.Body.Close()
+				Ω(res.StatusCode).Should(Equal(http.StatusUnauthorized))
+				Ω(res.Header.Get(communication.ErrorHeader)).Should(Equal(string(communication.Unauthorized)))
+			}
+		})
+
+		It("should turn away unsigned auctions without waiting for a turn", func() {
+			gated := gatedClient{RepPoolClient: repClient, bidding: make(chan struct{}, 10), gate: make(chan struct{})}
+			server.Close()
+			server = httptest.NewServer(Handler(gated, 1, signer))
+
+			held := make(chan int, 1)
+			go func() {
+				defer GinkgoRecover()
+				res := post(sign(request()))
+				res.Body.Close()
+				held <- res.StatusCode
+			}()
+			Eventually(gated.bidding).Should(Receive())
+
+			res := post(request())
+			res.Body.Close()
+			Ω(res.StatusCode).Should(Equal(http.StatusUnauthorized))
+
+			close(gated.gate)
+			Eventually(held).Should(Receive(Equal(http.StatusOK)))
+		})
+
+		It("should not hold auctions whose deadline has passed", func() {
+			expired := request()
+			expired.Deadline = time.Now().Add(-time.Second)
+
+			res := post(sign(expired))
+			res.Body.Close()
+			Ω(res.StatusCode).Should(Equal(http.StatusRequestTimeout))
+		})
+	})
+
+	Describe("serving over TLS", func() {
+		var certs *testcerts.Certs
+		var server *httptest.Server
+
+		BeforeEach(func() {
+			var err error
+			certs, err = testcerts.Generate("127.0.0.1")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			server = httptest.NewUnstartedServer(Handler(repClient, 1, nil))
+			server.TLS = certs.ServerConfig()
+			server.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+			server.StartTLS()
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("should serve clients that trust its certificate authority", func() {
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: certs.ClientConfig()}}
+			res, err := client.Post(server.URL+"/auction", "application/json", bytes.NewReader(payload))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(decodeResult(res).Winner).Should(Equal("rep-a"))
+		})
+
+		It("should be refused by clients that don't", func() {
+			otherCerts, err := testcerts.Generate("127.0.0.1")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: otherCerts.ClientConfig()}}
+			_, err = client.Post(server.URL+"/auction", "application/json", bytes.NewReader(payload))
+			Ω(err).Should(HaveOccurred())
+			Ω(err.Error()).Should(ContainSubstring("certificate"))
+		})
+	})
+})
@@ -0,0 +1,116 @@
+package rephttpclient
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/onsi/auction/communication"
+	"github.com/onsi/auction/util"
+)
+
+var UnknownRepError = errors.New("unknown rep")
+var RequestFailedError = communication.RequestFailedError
+var TimeoutError = communication.TimeoutError
+
+type RepHTTPClient struct {
+	communication.RepClient
+
+	repAddrs map[string]string
+	client   *http.Client
+	codec    communication.Codec
+	signer   *communication.Signer
+	wire     *communication.WireCounter
+}
+
+// New takes the host:port each rep guid is listening on.  Connections to each
+// rep are kept alive and reused; every request is bounded by timeout and
+// encoded with codec.  With a signer (which may be nil) requests are signed,
+// and only replies signed with the same key are accepted.
+func New(repAddrs map[string]string, timeout time.Duration, codec communication.Codec, signer *communication.Signer) *RepHTTPClient {
+	rep := &RepHTTPClient{
+		repAddrs: repAddrs,
+		codec:    codec,
+		signer:   signer,
+		wire:     communication.NewWireCounter(),
+		client: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				MaxIdleConnsPerHost: 100,
+			},
+		},
+	}
+	rep.RepClient = communication.NewRepClient(rep.request)
+
+	return rep
+}
+
+func (rep *RepHTTPClient) request(guid string, subject string, req interface{}, resp interface{}) (err error) {
+	addr, ok := rep.repAddrs[guid]
+	if !ok {
+		return UnknownRepError
+	}
+
+	payload := []byte{}
+	if req != nil {
+		payload, err = rep.codec.Marshal(req)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rep.post(addr, guid, subject, payload, resp)
+}
+
+func (rep *RepHTTPClient) post(addr string, guid string, subject string, payload []byte, resp interface{}) error {
+	req, err := http.NewRequest("POST", fmt.Sprintf("http://%s/%s/%s", addr, guid, subject), bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", rep.codec.ContentType())
+
+	envelope := rep.signer.Sign(guid, subject, communication.Envelope{
+		Version:     communication.ProtocolVersion,
+		RequestID:   util.RandomGuid(),
+		Deadline:    time.Now().Add(rep.client.Timeout),
+		ContentType: rep.codec.ContentType(),
+		Body:        payload,
+	})
+	for key, value := range envelope.Headers() {
+		req.Header.Set(key, value)
+	}
+	rep.wire.CountSent(envelope.Size(), envelope)
+
+	res, err := rep.client.Do(req)
+	if err != nil {
+		return communication.TransportErrorFor(err)
+	}
+	defer res.Body.Close()
+
+	response, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return communication.TransportErrorFor(err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return RequestFailedError
+	}
+
+	reply := communication.EnvelopeFromHeaders(res.Header.Get("Content-Type"), res.Header.Get, response)
+	rep.wire.CountReceived(reply.Size(), reply)
+	err = rep.signer.Verify(guid, subject, reply)
+	if err != nil {
+		return err
+	}
+
+	return reply.Decode(resp)
+}
+
+// WireBytes counts the requests sent and the replies received, each its body
+// and envelope headers.
+func (rep *RepHTTPClient) WireBytes() communication.WireBytes {
+	return rep.wire.Bytes()
+}
@@ -0,0 +1,200 @@
+package rephttpclient_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/onsi/auction/auctionrep"
+	"github.com/onsi/auction/communication"
+	"github.com/onsi/auction/communication/conformance"
+	. "github.com/onsi/auction/communication/http/rephttpclient"
+	"github.com/onsi/auction/communication/http/rephttpserver"
+	"github.com/onsi/auction/simulation/simulationrepdelegate"
+	"github.com/onsi/auction/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RepHTTPClient", func() {
+	var servers []*httptest.Server
+	var client *RepHTTPClient
+	var instance types.Instance
+
+	BeforeEach(func() {
+		repAddrs := map[string]string{}
+		servers = []*httptest.Server{}
+		for _, guid := range []string{"rep-a", "rep-b"} {
+			rep := auctionrep.New(guid, simulationrepdelegate.New(types.Resources{
+				MemoryMB:   100,
+				DiskMB:     100,
+				Containers: 10,
+			}))
+			server := httptest.NewServer(rephttpserver.Handler(rep, nil, nil))
+			servers = append(servers, server)
+			repAddrs[guid] = strings.TrimPrefix(server.URL, "http://")
+		}
+
+		slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(time.Second)
+		}))
+		servers = append(servers, slowServer)
+		repAddrs["rep-slow"] = strings.TrimPrefix(slowServer.URL, "http://")
+
+		client = New(repAddrs, 100*time.Millisecond, communication.JSON, nil)
+
+		instance = types.Instance{
+			AppGuid:      "app-guid",
+			InstanceGuid: "instance-guid",
+			Resources:    types.Resources{MemoryMB: 1, DiskMB: 1},
+		}
+	})
+
+	AfterEach(func() {
+		for _, server := range servers {
+			server.CloseClientConnections()
+			server.Close()
+		}
+	})
+
+	conformance.ItBehavesLikeATestRepPoolClient(func() conformance.Fixture {
+		return conformance.Fixture{
+			Client:       client,
+			Timeout:      100 * time.Millisecond,
+			RepResources: types.Resources{MemoryMB: 100, DiskMB: 100, Containers: 10},
+			Responsive:   []string{"rep-a", "rep-b"},
+			Unresponsive: []string{"rep-slow"},
+		}
+	})
+
+	It("should score every rep", func() {
+		results := client.Score([]string{"rep-a", "rep-b"}, instance)
+		Ω(results).Should(HaveLen(2))
+		Ω(results.FilterErrors().Reps()).Should(ContainElement("rep-a"))
+		Ω(results.FilterErrors().Reps()).Should(ContainElement("rep-b"))
+	})
+
+	It("should reserve, claim and release", func() {
+		results := client.ScoreThenTentativelyReserve([]string{"rep-a", "rep-b"}, instance)
+		Ω(results.FilterErrors()).Should(HaveLen(2))
+
+		client.Claim("rep-a", instance)
+		client.ReleaseReservation([]string{"rep-b"}, instance)
+
+		Ω(client.Instances("rep-a")).Should(Equal([]types.Instance{instance}))
+		Ω(client.Instances("rep-b")).Should(BeEmpty())
+	})
+
+	It("should speak msgpack when asked to", func() {
+		client = New(map[string]string{"rep-a": servers[0].Listener.Addr().String()}, 100*time.Millisecond, communication.MsgPack, nil)
+
+		results := client.ScoreThenTentativelyReserve([]string{"rep-a"}, instance)
+		Ω(results.FilterErrors()).Should(HaveLen(1))
+		Ω(results[0].Rep).Should(Equal("rep-a"))
+
+		client.Claim("rep-a", instance)
+		Ω(client.Instances("rep-a")).Should(Equal([]types.Instance{instance}))
+	})
+
+	It("should return an errored result for reps it doesn't know about", func() {
+		results := client.Score([]string{"rep-a", "rep-nope"}, instance)
+		Ω(results).Should(HaveLen(2))
+		Ω(results.FilterErrors().Reps()).Should(Equal(types.RepGuids{"rep-a"}))
+	})
+
+	It("should return errors instead of panicking when a rep doesn't respond", func() {
+		_, err := client.TotalResources("rep-slow")
+		Ω(err).Should(HaveOccurred())
+
+		_, err = client.Instances("rep-slow")
+		Ω(err).Should(HaveOccurred())
+
+		Ω(client.SetInstances("rep-slow", []types.Instance{instance})).Should(HaveOccurred())
+		Ω(client.Reset("rep-nope")).Should(Equal(UnknownRepError))
+	})
+
+	It("should time out on reps that don't respond in time", func() {
+		t := time.Now()
+		results := client.Score([]string{"rep-a", "rep-slow"}, instance)
+		Ω(time.Since(t)).Should(BeNumerically("<", 500*time.Millisecond))
+		Ω(results).Should(HaveLen(2))
+		Ω(results.FilterErrors().Reps()).Should(Equal(types.RepGuids{"rep-a"}))
+	})
+
+	Describe("deadlines", func() {
+		It("should stamp every request with one", func() {
+			deadlines := make(chan string, 1)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				deadlines <- r.Header.Get(communication.DeadlineHeader)
+				w.Write(communication.ErrorResponse)
+			}))
+			servers = append(servers, server)
+
+			client = New(map[string]string{"rep-a": server.Listener.Addr().String()}, 100*time.Millisecond, communication.JSON, nil)
+			client.Score([]string{"rep-a"}, instance)
+
+			var deadline string
+			Eventually(deadlines).Should(Receive(&deadline))
+			nanos, err := strconv.ParseInt(deadline, 10, 64)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(time.Unix(0, nanos).Sub(time.Now())).Should(BeNumerically("~", 100*time.Millisecond, 50*time.Millisecond))
+		})
+
+		It("should be dropped by reps once they've passed", func() {
+			handler := rephttpserver.Handler(auctionrep.New("rep-c", simulationrepdelegate.New(types.Resources{MemoryMB: 100, DiskMB: 100, Containers: 10})), nil, nil)
+			server := httptest.NewServer(handler)
+			servers = append(servers, server)
+
+			req, _ := http.NewRequest("POST", server.URL+"/rep-c/"+communication.ScoreSubject, strings.NewReader(`{"a":"app-guid"}`))
+			req.Header.Set(communication.DeadlineHeader, strconv.FormatInt(time.Now().Add(-time.Second).UnixNano(), 10))
+			res, err := http.DefaultClient.Do(req)
+			Ω(err).ShouldNot(HaveOccurred())
+			res.Body.Close()
+
+			Ω(res.StatusCode).Should(Equal(http.StatusRequestTimeout))
+			Ω(handler.Expired()).Should(Equal(uint64(1)))
+		})
+	})
+
+	Describe("envelopes", func() {
+		var server *httptest.Server
+
+		BeforeEach(func() {
+			server = httptest.NewServer(rephttpserver.Handler(auctionrep.New("rep-c", simulationrepdelegate.New(types.Resources{MemoryMB: 100, DiskMB: 100, Containers: 10})), nil, nil))
+			servers = append(servers, server)
+		})
+
+		post := func(subject string, header map[string]string) (*http.Response, string) {
+			req, _ := http.NewRequest("POST", server.URL+"/rep-c/"+subject, strings.NewReader(`{"a":"app-guid"}`))
+			for key, value := range header {
+				req.Header.Set(key, value)
+			}
+			res, err := http.DefaultClient.Do(req)
+			Ω(err).ShouldNot(HaveOccurred())
+			defer res.Body.Close()
+
+			body, _ := ioutil.ReadAll(res.Body)
+			return res, string(body)
+		}
+
+		It("should answer in the request's version, with its request id and error code", func() {
+			res, body := post(communication.ClaimSubject, map[string]string{
+				communication.VersionHeader:   "1",
+				communication.RequestIDHeader: "request-id",
+			})
+			Ω(res.Header.Get(communication.VersionHeader)).Should(Equal("1"))
+			Ω(res.Header.Get(communication.RequestIDHeader)).Should(Equal("request-id"))
+			Ω(res.Header.Get(communication.ErrorHeader)).Should(Equal(string(communication.RequestFailed)))
+			Ω(body).Should(BeEmpty())
+		})
+
+		It("should answer legacy requests as it always has", func() {
+			res, body := post(communication.ClaimSubject, nil)
+			Ω(res.Header.Get(communication.VersionHeader)).Should(BeEmpty())
+			Ω(body).Should(Equal(string(communication.ErrorResponse)))
+		})
+	})
+})
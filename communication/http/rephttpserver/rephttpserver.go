@@ -0,0 +1,151 @@
+package rephttpserver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/onsi/auction/auctionrep"
+	"github.com/onsi/auction/communication"
+)
+
+// Server serves a RepHandler over http until it is stopped.
+type Server struct {
+	listener   net.Listener
+	httpServer *http.Server
+	handler    *RepHandler
+	stopOnce   *sync.Once
+	stopped    chan struct{}
+}
+
+// Start listens on httpAddr and serves rep (see Handler) until Stop is called.
+func Start(httpAddr string, rep *auctionrep.AuctionRep, signer *communication.Signer, admission *communication.Admission) (*Server, error) {
+	listener, err := net.Listen("tcp", httpAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	server := Serve(listener, rep, signer, admission)
+
+	fmt.Printf("[%s] listening for http on %s\n", rep.Guid(), listener.Addr())
+
+	return server, nil
+}
+
+// Serve serves rep on connections accepted from listener, which Stop closes.
+func Serve(listener net.Listener, rep *auctionrep.AuctionRep, signer *communication.Signer, admission *communication.Admission) *Server {
+	handler := Handler(rep, signer, admission)
+	server := &Server{
+		listener:   listener,
+		httpServer: &http.Server{Handler: handler},
+		handler:    handler,
+		stopOnce:   &sync.Once{},
+		stopped:    make(chan struct{}),
+	}
+
+	go server.httpServer.Serve(listener)
+
+	return server
+}
+
+// Stop stops accepting connections, lets requests that are already being
+// handled reply (bids that arrive meanwhile are told the rep is draining), and
+// then closes every connection.
+func (server *Server) Stop() {
+	server.stopOnce.Do(func() {
+		server.listener.Close()
+		server.handler.inFlight.Drain()
+		server.httpServer.Close()
+		close(server.stopped)
+	})
+}
+
+// Expired is the number of requests dropped because their deadline had passed.
+func (server *Server) Expired() uint64 {
+	return server.handler.Expired()
+}
+
+// Wait blocks until the server has stopped.
+func (server *Server) Wait() {
+	<-server.stopped
+}
+
+type RepHandler struct {
+	http.Handler
+	dispatcher *communication.RepDispatcher
+	inFlight   *communication.InFlight
+}
+
+// Handler serves each subject at POST /<rep-guid>/<subject>.  The request's
+// headers and body make up its envelope (see communication.EnvelopeFromHeaders)
+// and the reply's are written back the same way, with the request's
+// Content-Type.  Requests whose deadline header has passed get a 408 and no
+// body.  With a signer (which may be nil) the handler only answers requests
+// signed with its key, and with an admission (ditto) it turns away bids beyond
+// its limits.
+func Handler(rep *auctionrep.AuctionRep, signer *communication.Signer, admission *communication.Admission) *RepHandler {
+	dispatcher := communication.NewRepDispatcher(rep, signer, admission)
+	inFlight := communication.NewInFlight()
+	mux := http.NewServeMux()
+
+	for _, subject := range dispatcher.Subjects() {
+		subject := subject
+		mux.HandleFunc("/"+rep.Guid()+"/"+subject, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "POST" {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+
+			payload, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write(communication.ErrorResponse)
+				return
+			}
+
+			contentType := r.Header.Get("Content-Type")
+			if contentType == "" {
+				contentType = communication.JSON.ContentType()
+			}
+
+			request := communication.EnvelopeFromHeaders(contentType, r.Header.Get, payload)
+
+			var reply communication.Envelope
+			if inFlight.Begin() {
+				var ok bool
+				reply, ok = dispatcher.DispatchEnvelope(subject, request)
+				inFlight.End()
+				if !ok {
+					w.WriteHeader(http.StatusRequestTimeout)
+					return
+				}
+			} else {
+				reply = dispatcher.Refuse(subject, request)
+			}
+
+			for key, value := range reply.Headers() {
+				w.Header().Set(key, value)
+			}
+			w.Header().Set("Content-Type", contentType)
+
+			if reply.Version == 0 {
+				w.Write(reply.LegacyBody())
+			} else {
+				w.Write(reply.Body)
+			}
+		})
+	}
+
+	return &RepHandler{
+		Handler:    mux,
+		dispatcher: dispatcher,
+		inFlight:   inFlight,
+	}
+}
+
+// Expired is the number of requests dropped because their deadline had passed.
+func (handler *RepHandler) Expired() uint64 {
+	return handler.dispatcher.Expired()
+}
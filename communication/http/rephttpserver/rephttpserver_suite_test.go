@@ -0,0 +1,13 @@
+package rephttpserver_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestRephttpserver(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Rephttpserver Suite")
+}
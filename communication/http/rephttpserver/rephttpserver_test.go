@@ -0,0 +1,172 @@
+package rephttpserver_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/onsi/auction/auctionrep"
+	"github.com/onsi/auction/auctionrep/fakedelegate"
+	"github.com/onsi/auction/communication"
+	. "github.com/onsi/auction/communication/http/rephttpserver"
+	"github.com/onsi/auction/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RepHTTPServer", func() {
+	var release chan struct{}
+	var arrived int32
+	var addr string
+	var server *Server
+
+	BeforeEach(func() {
+		release = make(chan struct{})
+		arrived = 0
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Ω(err).ShouldNot(HaveOccurred())
+		addr = listener.Addr().String()
+
+		server = Serve(listener, auctionrep.New("rep", fakedelegate.BlockingDelegate{Arrived: &arrived, Release: release}), nil, nil)
+	})
+
+	AfterEach(func() {
+		select {
+		case <-release:
+		default:
+			close(release)
+		}
+		server.Stop()
+	})
+
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", addr)
+		Ω(err).ShouldNot(HaveOccurred())
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		return conn
+	}
+
+	post := func(conn net.Conn, subject string, body interface{}) (communication.Envelope, error) {
+		payload, _ := json.Marshal(body)
+		request := communication.Envelope{
+			Version:     communication.ProtocolVersion,
+			ContentType: communication.JSON.ContentType(),
+			Body:        payload,
+		}
+
+		req, _ := http.NewRequest("POST", "http://"+addr+"/rep/"+subject, bytes.NewReader(payload))
+		req.Header.Set("Content-Type", request.ContentType)
+		for key, value := range request.Headers() {
+			req.Header.Set(key, value)
+		}
+
+		err := req.Write(conn)
+		if err != nil {
+			return communication.Envelope{}, err
+		}
+
+		res, err := http.ReadResponse(bufio.NewReader(conn), req)
+		if err != nil {
+			return communication.Envelope{}, err
+		}
+		defer res.Body.Close()
+
+		replyBody, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			return communication.Envelope{}, err
+		}
+
+		return communication.EnvelopeFromHeaders(res.Header.Get("Content-Type"), res.Header.Get, replyBody), nil
+	}
+
+	score := func(conn net.Conn) (types.ScoreResult, error) {
+		var result types.ScoreResult
+		reply, err := post(conn, communication.ScoreSubject, types.Instance{InstanceGuid: "instance", Resources: types.Resources{MemoryMB: 1, DiskMB: 1}})
+		if err == nil {
+			err = reply.Decode(&result)
+		}
+		return result, err
+	}
+
+	It("should answer requests", func() {
+		close(release)
+
+		conn := dial()
+		defer conn.Close()
+
+		result, err := score(conn)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(result.Rep).Should(Equal("rep"))
+		Ω(result.Error).Should(BeEmpty())
+	})
+
+	Describe("stopping", func() {
+		It("should let in-flight requests reply, and tell bids that arrive meanwhile that it is draining", func() {
+			//a connection the server has already answered on, so that it is
+			//still served once the server stops listening
+			idle := dial()
+			defer idle.Close()
+			_, err := post(idle, communication.TotalResourcesSubject, nil)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			busy := dial()
+			defer busy.Close()
+			inFlight := make(chan types.ScoreResult, 1)
+			go func() {
+				defer GinkgoRecover()
+				result, err := score(busy)
+				Ω(err).ShouldNot(HaveOccurred())
+				inFlight <- result
+			}()
+			Eventually(func() int32 { return atomic.LoadInt32(&arrived) }).Should(Equal(int32(1)))
+
+			stopped := make(chan struct{})
+			go func() {
+				server.Stop()
+				close(stopped)
+			}()
+			Consistently(stopped).ShouldNot(BeClosed())
+
+			refused, err := score(idle)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(refused.Rep).Should(Equal("rep"))
+			Ω(refused.Error).Should(Equal(types.Draining))
+
+			close(release)
+			var result types.ScoreResult
+			Eventually(inFlight).Should(Receive(&result))
+			Ω(result.Error).Should(BeEmpty())
+			Eventually(stopped).Should(BeClosed())
+		})
+
+		It("should stop listening and let Wait return", func() {
+			close(release)
+
+			waited := make(chan struct{})
+			go func() {
+				server.Wait()
+				close(waited)
+			}()
+			Consistently(waited).ShouldNot(BeClosed())
+
+			server.Stop()
+			server.Stop()
+
+			Eventually(waited).Should(BeClosed())
+
+			_, err := net.Dial("tcp", addr)
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+
+	It("should fail to start on an address it can't listen on", func() {
+		_, err := Start(addr, auctionrep.New("other", fakedelegate.BlockingDelegate{Arrived: &arrived, Release: release}), nil, nil)
+		Ω(err).Should(HaveOccurred())
+	})
+})
@@ -0,0 +1,70 @@
+package communication
+
+import "sync"
+
+// ConnectionState is reported to a rep server's StateCallback whenever its
+// connection to the message bus changes.
+type ConnectionState int
+
+const (
+	Connected ConnectionState = iota
+	Disconnected
+	Stopped
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case Connected:
+		return "connected"
+	case Disconnected:
+		return "disconnected"
+	case Stopped:
+		return "stopped"
+	}
+	return "unknown"
+}
+
+type StateCallback func(state ConnectionState)
+
+// InFlight counts the requests a server is handling so that it can stop
+// taking new ones and wait for the rest to finish.
+type InFlight struct {
+	lock     *sync.Mutex
+	requests *sync.WaitGroup
+	draining bool
+}
+
+func NewInFlight() *InFlight {
+	return &InFlight{
+		lock:     &sync.Mutex{},
+		requests: &sync.WaitGroup{},
+	}
+}
+
+// Begin returns false once the server has started draining.  Rep servers then
+// answer the request with RepDispatcher.Refuse, so that bidders know to look
+// elsewhere, instead of handling it.
+func (f *InFlight) Begin() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.draining {
+		return false
+	}
+
+	f.requests.Add(1)
+	return true
+}
+
+func (f *InFlight) End() {
+	f.requests.Done()
+}
+
+// Drain refuses new requests and waits for those already begun to end.
+func (f *InFlight) Drain() {
+	f.lock.Lock()
+	f.draining = true
+	f.lock.Unlock()
+
+	f.requests.Wait()
+}
@@ -0,0 +1,36 @@
+package communication
+
+import (
+	"bytes"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// msgPackCodec speaks standard MessagePack.  Structs are written as maps keyed
+// by their (short) json tags, omitempty included, so a message looks the same
+// in either codec and fields can be added or reordered without breaking peers.
+type msgPackCodec struct{}
+
+func (msgPackCodec) ContentType() string {
+	return "application/x-msgpack"
+}
+
+func (msgPackCodec) Marshal(v interface{}) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	encoder := msgpack.NewEncoder(buffer)
+	encoder.SetCustomStructTag("json")
+	encoder.UseCompactInts(true)
+
+	err := encoder.Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (msgPackCodec) Unmarshal(data []byte, v interface{}) error {
+	decoder := msgpack.NewDecoder(bytes.NewReader(data))
+	decoder.SetCustomStructTag("json")
+
+	return decoder.Decode(v)
+}
@@ -0,0 +1,49 @@
+package communication
+
+import (
+	"errors"
+	"strings"
+)
+
+// A Namespace keeps the reps and auctioneers of one cluster apart from those
+// of other clusters that share a broker, even when their rep guids overlap.
+// Every NATS subject and every rabbit queue a cluster uses is qualified with
+// its namespace.  The empty namespace qualifies nothing, so clusters without
+// one use the same subjects and queues as ever.
+type Namespace string
+
+var InvalidNamespaceError = errors.New("namespaces can't contain dots, wildcards or whitespace")
+
+// ParseNamespace checks that name can stand as a single token at the front of
+// a NATS subject.
+func ParseNamespace(name string) (Namespace, error) {
+	if strings.ContainsAny(name, ".*> \t\r\n") {
+		return "", InvalidNamespaceError
+	}
+
+	return Namespace(name), nil
+}
+
+// Qualify puts name in the namespace.
+func (n Namespace) Qualify(name string) string {
+	if n == "" {
+		return name
+	}
+
+	return string(n) + "." + name
+}
+
+// Unqualify takes name back out of the namespace, returning false if it was
+// never in it.
+func (n Namespace) Unqualify(name string) (string, bool) {
+	if n == "" {
+		return name, true
+	}
+
+	prefix := string(n) + "."
+	if !strings.HasPrefix(name, prefix) {
+		return name, false
+	}
+
+	return strings.TrimPrefix(name, prefix), true
+}
@@ -0,0 +1,42 @@
+package communication_test
+
+import (
+	. "github.com/onsi/auction/communication"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Namespace", func() {
+	It("should only accept names that make a single subject token", func() {
+		namespace, err := ParseNamespace("cell-a")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(namespace).Should(Equal(Namespace("cell-a")))
+
+		for _, name := range []string{"cell.a", "cell-*", "cell->", "cell a"} {
+			_, err := ParseNamespace(name)
+			Ω(err).Should(Equal(InvalidNamespaceError), name)
+		}
+	})
+
+	It("should qualify and unqualify names", func() {
+		namespace := Namespace("cell-a")
+		Ω(namespace.Qualify("rep-a.score")).Should(Equal("cell-a.rep-a.score"))
+
+		name, ok := namespace.Unqualify("cell-a.rep-a.score")
+		Ω(ok).Should(BeTrue())
+		Ω(name).Should(Equal("rep-a.score"))
+
+		_, ok = namespace.Unqualify("cell-b.rep-a.score")
+		Ω(ok).Should(BeFalse())
+		_, ok = namespace.Unqualify("rep-a.score")
+		Ω(ok).Should(BeFalse())
+	})
+
+	It("should leave names alone in the empty namespace", func() {
+		Ω(Namespace("").Qualify("rep-a.score")).Should(Equal("rep-a.score"))
+
+		name, ok := Namespace("").Unqualify("rep-a.score")
+		Ω(ok).Should(BeTrue())
+		Ω(name).Should(Equal("rep-a.score"))
+	})
+})
@@ -0,0 +1,88 @@
+package auctioneernatsclient
+
+import (
+	"time"
+
+	"github.com/cloudfoundry/yagnats"
+	"github.com/onsi/auction/communication"
+	"github.com/onsi/auction/types"
+	"github.com/onsi/auction/util"
+)
+
+var TimeoutError = communication.TimeoutError
+
+// AuctioneerNatsClient submits auctions to whichever auctioneer NATS hands
+// them to.  Auctions are few and long compared to rep requests, so each one
+// gets an inbox of its own.
+type AuctioneerNatsClient struct {
+	client  yagnats.NATSClient
+	timeout time.Duration
+	codec   communication.Codec
+	signer  *communication.Signer
+}
+
+// New returns a client that gives up on an auction after timeout, which
+// should allow for every round of the auction and for waiting behind the
+// auctioneer's other auctions.  With a signer (which may be nil) auctions are
+// signed, and only results signed with the same key are accepted.
+func New(client yagnats.NATSClient, timeout time.Duration, codec communication.Codec, signer *communication.Signer) *AuctioneerNatsClient {
+	return &AuctioneerNatsClient{
+		client:  client,
+		timeout: timeout,
+		codec:   codec,
+		signer:  signer,
+	}
+}
+
+func (a *AuctioneerNatsClient) Auction(auctionRequest types.AuctionRequest) (types.AuctionResult, error) {
+	body, err := a.codec.Marshal(auctionRequest)
+	if err != nil {
+		return types.AuctionResult{}, err
+	}
+
+	requestID := util.RandomGuid()
+	replyTo := "_INBOX." + requestID
+
+	c := make(chan []byte, 1)
+	subscriptionID, err := a.client.Subscribe(replyTo, func(msg *yagnats.Message) {
+		select {
+		case c <- msg.Payload:
+		default:
+		}
+	})
+	if err != nil {
+		return types.AuctionResult{}, communication.TransportError{Err: err}
+	}
+	defer a.client.Unsubscribe(subscriptionID)
+
+	err = a.client.PublishWithReplyTo(communication.AuctionSubject, replyTo, communication.Seal(a.signer.Sign("", communication.AuctionSubject, communication.Envelope{
+		Version:     communication.ProtocolVersion,
+		RequestID:   requestID,
+		Deadline:    time.Now().Add(a.timeout),
+		ContentType: a.codec.ContentType(),
+		Body:        body,
+	})))
+	if err != nil {
+		return types.AuctionResult{}, communication.TransportError{Err: err}
+	}
+
+	select {
+	case payload := <-c:
+		reply, err := communication.Open(payload)
+		if err != nil {
+			return types.AuctionResult{}, err
+		}
+
+		err = a.signer.Verify("", communication.AuctionSubject, reply)
+		if err != nil {
+			return types.AuctionResult{}, err
+		}
+
+		var auctionResult types.AuctionResult
+		err = reply.Decode(&auctionResult)
+		return auctionResult, err
+
+	case <-time.After(a.timeout):
+		return types.AuctionResult{}, TimeoutError
+	}
+}
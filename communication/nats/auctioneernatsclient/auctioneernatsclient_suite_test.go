@@ -0,0 +1,13 @@
+package auctioneernatsclient_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestAuctioneerNatsClient(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "AuctioneerNatsClient Suite")
+}
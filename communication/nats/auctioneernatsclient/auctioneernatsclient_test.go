@@ -0,0 +1,83 @@
+package auctioneernatsclient_test
+
+import (
+	"time"
+
+	"github.com/onsi/auction/auctionrep"
+	"github.com/onsi/auction/communication"
+	. "github.com/onsi/auction/communication/nats/auctioneernatsclient"
+	"github.com/onsi/auction/communication/nats/auctioneernatsserver"
+	"github.com/onsi/auction/communication/nats/fakenats"
+	"github.com/onsi/auction/simulation/communication/inprocess"
+	"github.com/onsi/auction/simulation/simulationrepdelegate"
+	"github.com/onsi/auction/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AuctioneerNatsClient", func() {
+	var bus *fakenats.Bus
+	var natsClient *fakenats.Client
+	var repClient *inprocess.InprocessClient
+	var auctionRequest types.AuctionRequest
+
+	BeforeEach(func() {
+		bus = fakenats.NewBus()
+		natsClient = bus.NewClient()
+
+		reps := map[string]*auctionrep.AuctionRep{}
+		for _, guid := range []string{"rep-a", "rep-b"} {
+			reps[guid] = auctionrep.New(guid, simulationrepdelegate.New(types.Resources{MemoryMB: 100, DiskMB: 100, Containers: 10}))
+		}
+		repClient = inprocess.New(reps, 100*time.Millisecond)
+
+		auctionRequest = types.AuctionRequest{
+			Instance: types.Instance{AppGuid: "app-guid", InstanceGuid: "instance-guid", Resources: types.Resources{MemoryMB: 1, DiskMB: 1}},
+			RepGuids: types.RepGuids{"rep-a", "rep-b"},
+			Rules:    types.AuctionRules{Algorithm: "reserve_n_best", MaxRounds: 1, MaxBiddingPool: 1},
+		}
+	})
+
+	Context("when auctioneers are listening", func() {
+		BeforeEach(func() {
+			for i := 0; i < 2; i++ {
+				_, err := auctioneernatsserver.Serve(bus.NewClient(), repClient, 10, nil)
+				Ω(err).ShouldNot(HaveOccurred())
+			}
+		})
+
+		It("should hold the auction in either codec", func() {
+			for _, codec := range communication.Codecs {
+				auctionRequest.Instance.InstanceGuid = "instance-" + codec.ContentType()
+
+				auctionResult, err := New(natsClient, time.Second, codec, nil).Auction(auctionRequest)
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(auctionResult.Instance).Should(Equal(auctionRequest.Instance))
+				Ω(auctionResult.NumRounds).Should(Equal(1))
+				Ω(auctionRequest.RepGuids).Should(ContainElement(auctionResult.Winner))
+
+				instances, err := repClient.Instances(auctionResult.Winner)
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(instances).Should(ContainElement(auctionRequest.Instance))
+			}
+		})
+
+		It("should clean up its inbox", func() {
+			New(natsClient, time.Second, communication.JSON, nil).Auction(auctionRequest)
+			Ω(bus.Subscribers("_INBOX.*")).Should(BeZero())
+		})
+	})
+
+	It("should time out when no auctioneer replies", func() {
+		t := time.Now()
+		_, err := New(natsClient, 50*time.Millisecond, communication.JSON, nil).Auction(auctionRequest)
+		Ω(err).Should(Equal(TimeoutError))
+		Ω(time.Since(t)).Should(BeNumerically(">=", 50*time.Millisecond))
+	})
+
+	It("should return a transport error when it isn't connected", func() {
+		natsClient.Disconnect()
+		_, err := New(natsClient, 50*time.Millisecond, communication.JSON, nil).Auction(auctionRequest)
+		Ω(err).Should(BeAssignableToTypeOf(communication.TransportError{}))
+	})
+})
@@ -0,0 +1,139 @@
+package auctioneernatsserver
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudfoundry/yagnats"
+	"github.com/onsi/auction/auctioneer"
+	"github.com/onsi/auction/communication"
+	"github.com/onsi/auction/types"
+)
+
+// Server holds the auctions submitted on communication.AuctionSubject.  Every
+// server subscribes in communication.AuctioneerQueue, so NATS hands each
+// auction to just one of them, picked at random.
+type Server struct {
+	client         yagnats.NATSClient
+	repClient      types.RepPoolClient
+	signer         *communication.Signer
+	semaphore      chan bool
+	subscriptionID int64
+	inFlight       *communication.InFlight
+	expired        uint64
+	stopOnce       *sync.Once
+	stopped        chan struct{}
+}
+
+// Serve holds auctions against repClient, at most maxConcurrent at a time,
+// on an already connected client.  With a signer (which may be nil) it only
+// holds auctions whose requests are signed with its key and have a deadline,
+// and signs its replies.
+func Serve(client yagnats.NATSClient, repClient types.RepPoolClient, maxConcurrent int, signer *communication.Signer) (*Server, error) {
+	server := &Server{
+		client:    client,
+		repClient: repClient,
+		signer:    signer,
+		semaphore: make(chan bool, maxConcurrent),
+		inFlight:  communication.NewInFlight(),
+		stopOnce:  &sync.Once{},
+		stopped:   make(chan struct{}),
+	}
+
+	subscriptionID, err := client.SubscribeWithQueue(communication.AuctionSubject, communication.AuctioneerQueue, server.handle)
+	if err != nil {
+		return nil, err
+	}
+	server.subscriptionID = subscriptionID
+
+	return server, nil
+}
+
+func (server *Server) handle(msg *yagnats.Message) {
+	if !server.inFlight.Begin() {
+		return
+	}
+	defer server.inFlight.End()
+
+	request, err := communication.Open(msg.Payload)
+	if err != nil {
+		server.reply(msg.ReplyTo, request.Reply(nil, communication.BadRequest))
+		return
+	}
+
+	err = server.signer.Verify("", communication.AuctionSubject, request)
+	if err == nil && server.signer != nil && request.Deadline.IsZero() {
+		err = communication.NoDeadlineError
+	}
+	if err != nil {
+		server.reply(msg.ReplyTo, request.Reply(nil, communication.Unauthorized))
+		return
+	}
+
+	codec, err := communication.CodecForContentType(request.ContentType)
+	if err != nil {
+		server.reply(msg.ReplyTo, request.Reply(nil, communication.UnknownContentType))
+		return
+	}
+
+	var auctionRequest types.AuctionRequest
+	err = codec.Unmarshal(request.Body, &auctionRequest)
+	if err != nil {
+		server.reply(msg.ReplyTo, request.Reply(nil, communication.BadRequest))
+		return
+	}
+
+	server.semaphore <- true
+	defer func() {
+		<-server.semaphore
+	}()
+
+	//the auction may have waited its turn for longer than the submitter was
+	//willing to wait for the result
+	if !request.Deadline.IsZero() && time.Now().After(request.Deadline) {
+		atomic.AddUint64(&server.expired, 1)
+		return
+	}
+
+	auctionResult := auctioneer.Auction(server.repClient, auctionRequest)
+
+	body, err := codec.Marshal(auctionResult)
+	if err != nil {
+		server.reply(msg.ReplyTo, request.Reply(nil, communication.RequestFailed))
+		return
+	}
+
+	server.reply(msg.ReplyTo, request.Reply(body, communication.NoError))
+}
+
+// reply publishes reply to replyTo, unless the submitter gave no reply subject
+// because it doesn't want to hear back.
+func (server *Server) reply(replyTo string, reply communication.Envelope) {
+	if replyTo == "" {
+		return
+	}
+
+	server.client.Publish(replyTo, communication.Seal(server.signer.Sign("", communication.AuctionSubject, reply)))
+}
+
+// Stop unsubscribes, so that new auctions go to the other auctioneers, and
+// lets the auctions already under way finish and reply.
+func (server *Server) Stop() {
+	server.stopOnce.Do(func() {
+		server.client.Unsubscribe(server.subscriptionID)
+		server.inFlight.Drain()
+		close(server.stopped)
+	})
+}
+
+// Expired is the number of auctions dropped because their deadline had passed
+// before they could be held.
+func (server *Server) Expired() uint64 {
+	return atomic.LoadUint64(&server.expired)
+}
+
+// Wait blocks until the server has stopped.
+func (server *Server) Wait() {
+	<-server.stopped
+}
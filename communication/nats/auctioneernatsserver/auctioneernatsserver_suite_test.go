@@ -0,0 +1,13 @@
+package auctioneernatsserver_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestAuctioneerNatsServer(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "AuctioneerNatsServer Suite")
+}
@@ -0,0 +1,195 @@
+package auctioneernatsserver_test
+
+import (
+	"encoding/json"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudfoundry/yagnats"
+	"github.com/onsi/auction/auctionrep"
+	"github.com/onsi/auction/communication"
+	. "github.com/onsi/auction/communication/nats/auctioneernatsserver"
+	"github.com/onsi/auction/communication/nats/fakenats"
+	"github.com/onsi/auction/simulation/communication/inprocess"
+	"github.com/onsi/auction/simulation/simulationrepdelegate"
+	"github.com/onsi/auction/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// stubClient counts the auctions its reps win and, when release is set, holds
+// every Score request until release is closed.
+type stubClient struct {
+	types.RepPoolClient
+	claims  *int32
+	release chan struct{}
+}
+
+func (c stubClient) Score(guids []string, instance types.Instance) types.ScoreResults {
+	if c.release != nil {
+		<-c.release
+	}
+	return c.RepPoolClient.Score(guids, instance)
+}
+
+func (c stubClient) Claim(guid string, instance types.Instance) {
+	atomic.AddInt32(c.claims, 1)
+	c.RepPoolClient.Claim(guid, instance)
+}
+
+var _ = Describe("AuctioneerNatsServer", func() {
+	var bus *fakenats.Bus
+	var natsClient *fakenats.Client
+	var repClient types.RepPoolClient
+	var replies chan communication.Envelope
+	var auctionRequest types.AuctionRequest
+
+	BeforeEach(func() {
+		bus = fakenats.NewBus()
+		natsClient = bus.NewClient()
+
+		repClient = inprocess.New(map[string]*auctionrep.AuctionRep{
+			"rep-a": auctionrep.New("rep-a", simulationrepdelegate.New(types.Resources{MemoryMB: 100, DiskMB: 100, Containers: 10})),
+		}, 100*time.Millisecond)
+
+		replies = make(chan communication.Envelope, 10)
+		received := replies
+		natsClient.Subscribe("reply", func(msg *yagnats.Message) {
+			reply, _ := communication.Open(msg.Payload)
+			received <- reply
+		})
+
+		auctionRequest = types.AuctionRequest{
+			Instance: types.Instance{AppGuid: "app-guid", InstanceGuid: "instance-guid", Resources: types.Resources{MemoryMB: 1, DiskMB: 1}},
+			RepGuids: types.RepGuids{"rep-a"},
+			Rules:    types.AuctionRules{Algorithm: "reserve_n_best", MaxRounds: 1, MaxBiddingPool: 1},
+		}
+	})
+
+	submit := func(deadline time.Time) {
+		body, _ := json.Marshal(auctionRequest)
+		natsClient.PublishWithReplyTo(communication.AuctionSubject, "reply", communication.Seal(communication.Envelope{
+			Version:     communication.ProtocolVersion,
+			RequestID:   "request-id",
+			Deadline:    deadline,
+			ContentType: communication.JSON.ContentType(),
+			Body:        body,
+		}))
+	}
+
+	It("should hold the auction and reply with the result", func() {
+		_, err := Serve(bus.NewClient(), repClient, 10, nil)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		submit(time.Now().Add(time.Second))
+
+		var reply communication.Envelope
+		Eventually(replies).Should(Receive(&reply))
+		Ω(reply.RequestID).Should(Equal("request-id"))
+
+		var auctionResult types.AuctionResult
+		err = reply.Decode(&auctionResult)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(auctionResult.Winner).Should(Equal("rep-a"))
+		Ω(auctionResult.Instance).Should(Equal(auctionRequest.Instance))
+	})
+
+	It("should share each auction out to just one of the auctioneers", func() {
+		var claims int32
+		for i := 0; i < 3; i++ {
+			_, err := Serve(bus.NewClient(), stubClient{RepPoolClient: repClient, claims: &claims}, 10, nil)
+			Ω(err).ShouldNot(HaveOccurred())
+		}
+		Ω(bus.Subscribers(communication.AuctionSubject)).Should(Equal(3))
+
+		for i := 0; i < 5; i++ {
+			submit(time.Now().Add(time.Second))
+		}
+
+		for i := 0; i < 5; i++ {
+			Eventually(replies).Should(Receive())
+		}
+		Consistently(replies).ShouldNot(Receive())
+		Ω(atomic.LoadInt32(&claims)).Should(Equal(int32(5)))
+	})
+
+	It("should hold auctions submitted without a reply subject, and publish nothing", func() {
+		var claims int32
+		_, err := Serve(bus.NewClient(), stubClient{RepPoolClient: repClient, claims: &claims}, 10, nil)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		body, _ := json.Marshal(auctionRequest)
+		bus.ResetOperations()
+		natsClient.Publish(communication.AuctionSubject, communication.Seal(communication.Envelope{
+			Version:     communication.ProtocolVersion,
+			RequestID:   "request-id",
+			ContentType: communication.JSON.ContentType(),
+			Body:        body,
+		}))
+
+		Eventually(func() int32 { return atomic.LoadInt32(&claims) }).Should(Equal(int32(1)))
+		Consistently(bus.Operations).Should(Equal(1))
+	})
+
+	It("should reply with an error to requests it can't decode", func() {
+		_, err := Serve(bus.NewClient(), repClient, 10, nil)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		natsClient.PublishWithReplyTo(communication.AuctionSubject, "reply", communication.Seal(communication.Envelope{
+			Version:     communication.ProtocolVersion,
+			ContentType: communication.JSON.ContentType(),
+			Body:        []byte("{"),
+		}))
+
+		var reply communication.Envelope
+		Eventually(replies).Should(Receive(&reply))
+		Ω(reply.Error).Should(Equal(communication.BadRequest))
+	})
+
+	Context("when auctions have to wait their turn", func() {
+		var server *Server
+		var release chan struct{}
+
+		BeforeEach(func() {
+			release = make(chan struct{})
+
+			var claims int32
+			var err error
+			server, err = Serve(bus.NewClient(), stubClient{RepPoolClient: repClient, claims: &claims, release: release}, 1, nil)
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		It("should drop the ones whose deadline passes while they wait", func() {
+			submit(time.Now().Add(time.Second))
+			time.Sleep(10 * time.Millisecond)
+			submit(time.Now().Add(10 * time.Millisecond))
+			time.Sleep(20 * time.Millisecond)
+			close(release)
+
+			Eventually(replies).Should(Receive())
+			Consistently(replies).ShouldNot(Receive())
+			Ω(server.Expired()).Should(Equal(uint64(1)))
+		})
+
+		It("should stop taking auctions, and let the one under way finish, when stopped", func() {
+			submit(time.Now().Add(time.Second))
+			time.Sleep(10 * time.Millisecond)
+
+			stopped := make(chan struct{})
+			go func() {
+				server.Stop()
+				close(stopped)
+			}()
+
+			Eventually(func() int {
+				return bus.Subscribers(communication.AuctionSubject)
+			}).Should(Equal(0))
+			Consistently(stopped).ShouldNot(BeClosed())
+
+			close(release)
+			Eventually(stopped).Should(BeClosed())
+			Eventually(replies).Should(Receive())
+			server.Wait()
+		})
+	})
+})
@@ -0,0 +1,262 @@
+// Package fakenats is an in-process stand-in for gnatsd.  Clients made from the
+// same Bus implement yagnats.NATSClient and talk to each other with the routing
+// semantics of a real server: subjects are dot-separated tokens, "*" matches
+// any one token and ">" the rest of the subject, each queue group receives a
+// message once, unsubscribed subscriptions receive nothing more, and every
+// callback runs on its own goroutine, as yagnats runs them.
+package fakenats
+
+import (
+	"errors"
+	"math/rand"
+	"strings"
+	"sync"
+
+	"github.com/cloudfoundry/yagnats"
+)
+
+var NotConnectedError = errors.New("not connected")
+
+type subscription struct {
+	id       int64
+	client   *Client
+	subject  []string
+	queue    string
+	callback yagnats.Callback
+}
+
+func (s *subscription) matches(subject []string) bool {
+	for i, token := range s.subject {
+		if token == ">" {
+			return len(subject) > i
+		}
+		if i >= len(subject) || (token != "*" && token != subject[i]) {
+			return false
+		}
+	}
+	return len(subject) == len(s.subject)
+}
+
+// Bus routes messages between the clients made from it.  It counts the
+// protocol operations (PUB, SUB and UNSUB) its clients send, as gnatsd would
+// see them.
+type Bus struct {
+	subscriptions map[int64]*subscription
+	nextID        int64
+	operations    int
+	duplicateRate float64
+	lock          *sync.Mutex
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		subscriptions: map[int64]*subscription{},
+		lock:          &sync.Mutex{},
+	}
+}
+
+// NewClient returns a client that is already connected to the bus.
+func (b *Bus) NewClient() *Client {
+	return &Client{
+		bus:       b,
+		connected: true,
+		lock:      &sync.Mutex{},
+	}
+}
+
+// Operations is the number of protocol operations the bus has been sent.
+func (b *Bus) Operations() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.operations
+}
+
+func (b *Bus) ResetOperations() {
+	b.lock.Lock()
+	b.operations = 0
+	b.lock.Unlock()
+}
+
+// DuplicateReplies makes the bus deliver rate (from 0 to 1) of the replies it
+// carries, the messages published without a reply subject, twice, as a
+// network that retransmits might.
+func (b *Bus) DuplicateReplies(rate float64) {
+	b.lock.Lock()
+	b.duplicateRate = rate
+	b.lock.Unlock()
+}
+
+// Subscribers is the number of subscriptions a message on subject would be
+// offered to, counting each queue group's members individually.
+func (b *Bus) Subscribers(subject string) int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return len(b.matching(strings.Split(subject, ".")))
+}
+
+func (b *Bus) matching(subject []string) []*subscription {
+	matching := []*subscription{}
+	for _, sub := range b.subscriptions {
+		if sub.matches(subject) {
+			matching = append(matching, sub)
+		}
+	}
+	return matching
+}
+
+func (b *Bus) subscribe(sub *subscription) int64 {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.nextID++
+	sub.id = b.nextID
+	b.subscriptions[sub.id] = sub
+	return sub.id
+}
+
+func (b *Bus) unsubscribe(client *Client, id int64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	sub, ok := b.subscriptions[id]
+	if ok && sub.client == client {
+		delete(b.subscriptions, id)
+	}
+}
+
+func (b *Bus) unsubscribeAll(client *Client, subject string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for id, sub := range b.subscriptions {
+		if sub.client == client && (subject == "" || strings.Join(sub.subject, ".") == subject) {
+			delete(b.subscriptions, id)
+		}
+	}
+}
+
+// publish offers the message to every plain subscription that matches and to
+// one member, chosen at random, of each queue group that matches, and offers
+// it to them all again if it is a reply chosen for duplication.
+func (b *Bus) publish(subject string, reply string, payload []byte) {
+	b.lock.Lock()
+	recipients := []*subscription{}
+	queues := map[string][]*subscription{}
+	for _, sub := range b.matching(strings.Split(subject, ".")) {
+		if sub.queue == "" {
+			recipients = append(recipients, sub)
+		} else {
+			queues[sub.queue] = append(queues[sub.queue], sub)
+		}
+	}
+	for _, members := range queues {
+		recipients = append(recipients, members[rand.Intn(len(members))])
+	}
+	if reply == "" && rand.Float64() < b.duplicateRate {
+		recipients = append(recipients, recipients...)
+	}
+	b.lock.Unlock()
+
+	for _, sub := range recipients {
+		go sub.callback(&yagnats.Message{
+			Subject: subject,
+			ReplyTo: reply,
+			Payload: append([]byte{}, payload...),
+		})
+	}
+}
+
+// Client is a connection to a Bus.
+type Client struct {
+	bus       *Bus
+	connected bool
+	lock      *sync.Mutex
+}
+
+func (c *Client) operate() error {
+	c.lock.Lock()
+	connected := c.connected
+	c.lock.Unlock()
+
+	if !connected {
+		return NotConnectedError
+	}
+
+	c.bus.lock.Lock()
+	c.bus.operations++
+	c.bus.lock.Unlock()
+	return nil
+}
+
+func (c *Client) Ping() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.connected
+}
+
+func (c *Client) Connect(connectionProvider yagnats.ConnectionProvider) error {
+	c.lock.Lock()
+	c.connected = true
+	c.lock.Unlock()
+	return nil
+}
+
+// Disconnect drops the client's subscriptions, as the server does when a
+// connection closes.
+func (c *Client) Disconnect() {
+	c.lock.Lock()
+	c.connected = false
+	c.lock.Unlock()
+
+	c.bus.unsubscribeAll(c, "")
+}
+
+func (c *Client) Publish(subject string, payload []byte) error {
+	return c.PublishWithReplyTo(subject, "", payload)
+}
+
+func (c *Client) PublishWithReplyTo(subject, reply string, payload []byte) error {
+	err := c.operate()
+	if err != nil {
+		return err
+	}
+
+	c.bus.publish(subject, reply, payload)
+	return nil
+}
+
+func (c *Client) Subscribe(subject string, callback yagnats.Callback) (int64, error) {
+	return c.SubscribeWithQueue(subject, "", callback)
+}
+
+func (c *Client) SubscribeWithQueue(subject, queue string, callback yagnats.Callback) (int64, error) {
+	err := c.operate()
+	if err != nil {
+		return 0, err
+	}
+
+	return c.bus.subscribe(&subscription{
+		client:   c,
+		subject:  strings.Split(subject, "."),
+		queue:    queue,
+		callback: callback,
+	}), nil
+}
+
+func (c *Client) Unsubscribe(subscriptionID int64) error {
+	err := c.operate()
+	if err != nil {
+		return err
+	}
+
+	c.bus.unsubscribe(c, subscriptionID)
+	return nil
+}
+
+func (c *Client) UnsubscribeAll(subject string) {
+	if c.operate() != nil {
+		return
+	}
+
+	c.bus.unsubscribeAll(c, subject)
+}
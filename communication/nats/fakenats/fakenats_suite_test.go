@@ -0,0 +1,13 @@
+package fakenats_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestFakeNats(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "FakeNats Suite")
+}
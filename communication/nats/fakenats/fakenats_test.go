@@ -0,0 +1,148 @@
+package fakenats_test
+
+import (
+	"time"
+
+	"github.com/cloudfoundry/yagnats"
+	. "github.com/onsi/auction/communication/nats/fakenats"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("FakeNats", func() {
+	var bus *Bus
+	var publisher, subscriber *Client
+
+	BeforeEach(func() {
+		bus = NewBus()
+		publisher = bus.NewClient()
+		subscriber = bus.NewClient()
+	})
+
+	receiver := func() (yagnats.Callback, chan *yagnats.Message) {
+		received := make(chan *yagnats.Message, 10)
+		return func(msg *yagnats.Message) {
+			received <- msg
+		}, received
+	}
+
+	It("should deliver messages to every subscriber of the subject, with their reply subject", func() {
+		callback, received := receiver()
+		subscriber.Subscribe("foo.bar", callback)
+		subscriber.Subscribe("foo.bar", callback)
+		subscriber.Subscribe("foo.baz", callback)
+
+		Ω(publisher.PublishWithReplyTo("foo.bar", "inbox", []byte("hello"))).ShouldNot(HaveOccurred())
+
+		var msg *yagnats.Message
+		Eventually(received).Should(Receive(&msg))
+		Ω(msg.Subject).Should(Equal("foo.bar"))
+		Ω(msg.ReplyTo).Should(Equal("inbox"))
+		Ω(string(msg.Payload)).Should(Equal("hello"))
+
+		Eventually(received).Should(Receive())
+		Consistently(received).ShouldNot(Receive())
+	})
+
+	It("should match * against a single token", func() {
+		callback, received := receiver()
+		subscriber.Subscribe("foo.*", callback)
+
+		publisher.Publish("foo.bar", nil)
+		publisher.Publish("foo.bar.baz", nil)
+		publisher.Publish("foo", nil)
+
+		Eventually(received).Should(Receive())
+		Consistently(received).ShouldNot(Receive())
+	})
+
+	It("should match > against the rest of the subject", func() {
+		callback, received := receiver()
+		subscriber.Subscribe("foo.>", callback)
+
+		publisher.Publish("foo.bar", nil)
+		publisher.Publish("foo.bar.baz", nil)
+		publisher.Publish("foo", nil)
+
+		Eventually(received).Should(Receive())
+		Eventually(received).Should(Receive())
+		Consistently(received).ShouldNot(Receive())
+	})
+
+	It("should deliver to one member of each queue group", func() {
+		callback, received := receiver()
+		subscriber.SubscribeWithQueue("foo", "workers", callback)
+		subscriber.SubscribeWithQueue("foo", "workers", callback)
+		subscriber.SubscribeWithQueue("foo", "others", callback)
+		subscriber.Subscribe("foo", callback)
+
+		publisher.Publish("foo", nil)
+
+		for i := 0; i < 3; i++ {
+			Eventually(received).Should(Receive())
+		}
+		Consistently(received).ShouldNot(Receive())
+	})
+
+	It("should deliver replies twice when asked to duplicate them", func() {
+		bus.DuplicateReplies(1)
+		callback, received := receiver()
+		subscriber.Subscribe("foo", callback)
+
+		publisher.PublishWithReplyTo("foo", "inbox", nil)
+		Eventually(received).Should(Receive())
+		Consistently(received).ShouldNot(Receive())
+
+		publisher.Publish("foo", nil)
+		Eventually(received).Should(Receive())
+		Eventually(received).Should(Receive())
+		Consistently(received).ShouldNot(Receive())
+	})
+
+	It("should stop delivering once unsubscribed", func() {
+		callback, received := receiver()
+		id, err := subscriber.Subscribe("foo", callback)
+		Ω(err).ShouldNot(HaveOccurred())
+		subscriber.Subscribe("bar", callback)
+
+		Ω(subscriber.Unsubscribe(id)).ShouldNot(HaveOccurred())
+		publisher.Publish("foo", nil)
+		Consistently(received, 100*time.Millisecond).ShouldNot(Receive())
+
+		subscriber.UnsubscribeAll("bar")
+		Ω(bus.Subscribers("bar")).Should(BeZero())
+	})
+
+	It("should not let clients unsubscribe each other", func() {
+		callback, received := receiver()
+		id, _ := subscriber.Subscribe("foo", callback)
+
+		publisher.Unsubscribe(id)
+		publisher.Publish("foo", nil)
+		Eventually(received).Should(Receive())
+	})
+
+	It("should drop a client's subscriptions, and refuse its requests, when it disconnects", func() {
+		callback, _ := receiver()
+		subscriber.Subscribe("foo", callback)
+		subscriber.Disconnect()
+
+		Ω(bus.Subscribers("foo")).Should(BeZero())
+		Ω(subscriber.Ping()).Should(BeFalse())
+		Ω(subscriber.Publish("foo", nil)).Should(Equal(NotConnectedError))
+
+		Ω(subscriber.Connect(nil)).ShouldNot(HaveOccurred())
+		Ω(subscriber.Publish("foo", nil)).ShouldNot(HaveOccurred())
+	})
+
+	It("should count the operations it is sent", func() {
+		callback, _ := receiver()
+		id, _ := subscriber.Subscribe("foo", callback)
+		publisher.Publish("foo", nil)
+		subscriber.Unsubscribe(id)
+		Ω(bus.Operations()).Should(Equal(3))
+
+		bus.ResetOperations()
+		Ω(bus.Operations()).Should(BeZero())
+	})
+})
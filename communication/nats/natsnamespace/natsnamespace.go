@@ -0,0 +1,76 @@
+// Package natsnamespace confines a NATS client to a communication.Namespace.
+// Everything on NATS, from rep subjects and the broadcast score subject to
+// auctions and reply inboxes, is a subject, so the rep and auctioneer servers
+// and clients are namespaced by handing them a namespaced client.
+package natsnamespace
+
+import (
+	"github.com/cloudfoundry/yagnats"
+	"github.com/onsi/auction/communication"
+)
+
+// Client qualifies every subject and reply subject it publishes or subscribes
+// to with its namespace, and unqualifies the subjects and reply subjects of the
+// messages it delivers, so its callers never see the namespace.  Messages whose
+// reply subject lies outside the namespace are delivered without one: they
+// can't be answered from inside it.
+type Client struct {
+	yagnats.NATSClient
+	namespace communication.Namespace
+}
+
+// New returns client itself for the empty namespace.
+func New(client yagnats.NATSClient, namespace communication.Namespace) yagnats.NATSClient {
+	if namespace == "" {
+		return client
+	}
+
+	return &Client{
+		NATSClient: client,
+		namespace:  namespace,
+	}
+}
+
+func (c *Client) Publish(subject string, payload []byte) error {
+	return c.NATSClient.Publish(c.namespace.Qualify(subject), payload)
+}
+
+func (c *Client) PublishWithReplyTo(subject, reply string, payload []byte) error {
+	if reply != "" {
+		reply = c.namespace.Qualify(reply)
+	}
+	return c.NATSClient.PublishWithReplyTo(c.namespace.Qualify(subject), reply, payload)
+}
+
+func (c *Client) Subscribe(subject string, callback yagnats.Callback) (int64, error) {
+	return c.NATSClient.Subscribe(c.namespace.Qualify(subject), c.unqualify(callback))
+}
+
+func (c *Client) SubscribeWithQueue(subject, queue string, callback yagnats.Callback) (int64, error) {
+	return c.NATSClient.SubscribeWithQueue(c.namespace.Qualify(subject), queue, c.unqualify(callback))
+}
+
+func (c *Client) UnsubscribeAll(subject string) {
+	c.NATSClient.UnsubscribeAll(c.namespace.Qualify(subject))
+}
+
+func (c *Client) unqualify(callback yagnats.Callback) yagnats.Callback {
+	return func(msg *yagnats.Message) {
+		subject, _ := c.namespace.Unqualify(msg.Subject)
+
+		reply := msg.ReplyTo
+		if reply != "" {
+			var inNamespace bool
+			reply, inNamespace = c.namespace.Unqualify(reply)
+			if !inNamespace {
+				return
+			}
+		}
+
+		callback(&yagnats.Message{
+			Subject: subject,
+			ReplyTo: reply,
+			Payload: msg.Payload,
+		})
+	}
+}
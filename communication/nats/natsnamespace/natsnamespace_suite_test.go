@@ -0,0 +1,13 @@
+package natsnamespace_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestNATSNamespace(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "NATSNamespace Suite")
+}
@@ -0,0 +1,149 @@
+package natsnamespace_test
+
+import (
+	"time"
+
+	"github.com/cloudfoundry/yagnats"
+	"github.com/onsi/auction/auctionrep"
+	"github.com/onsi/auction/communication"
+	"github.com/onsi/auction/communication/nats/auctioneernatsclient"
+	"github.com/onsi/auction/communication/nats/auctioneernatsserver"
+	"github.com/onsi/auction/communication/nats/fakenats"
+	. "github.com/onsi/auction/communication/nats/natsnamespace"
+	"github.com/onsi/auction/communication/nats/repnatsclient"
+	"github.com/onsi/auction/communication/nats/repnatsserver"
+	"github.com/onsi/auction/simulation/simulationrepdelegate"
+	"github.com/onsi/auction/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NATSNamespace", func() {
+	var bus *fakenats.Bus
+
+	BeforeEach(func() {
+		bus = fakenats.NewBus()
+	})
+
+	It("should return the client itself for the empty namespace", func() {
+		client := bus.NewClient()
+		Ω(New(client, "")).Should(Equal(client))
+	})
+
+	Describe("subjects", func() {
+		var messages chan *yagnats.Message
+
+		BeforeEach(func() {
+			messages = make(chan *yagnats.Message, 10)
+			received := messages
+			_, err := New(bus.NewClient(), "cell-a").Subscribe("rep-a.score", func(msg *yagnats.Message) {
+				received <- msg
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		It("should qualify what it publishes and unqualify what it delivers", func() {
+			raw := make(chan *yagnats.Message, 10)
+			bus.NewClient().Subscribe("cell-a.>", func(msg *yagnats.Message) {
+				raw <- msg
+			})
+
+			New(bus.NewClient(), "cell-a").PublishWithReplyTo("rep-a.score", "_INBOX.reply", []byte("payload"))
+
+			var msg *yagnats.Message
+			Eventually(raw).Should(Receive(&msg))
+			Ω(msg.Subject).Should(Equal("cell-a.rep-a.score"))
+			Ω(msg.ReplyTo).Should(Equal("cell-a._INBOX.reply"))
+
+			Eventually(messages).Should(Receive(&msg))
+			Ω(msg.Subject).Should(Equal("rep-a.score"))
+			Ω(msg.ReplyTo).Should(Equal("_INBOX.reply"))
+			Ω(msg.Payload).Should(Equal([]byte("payload")))
+		})
+
+		It("should not deliver messages from other namespaces", func() {
+			New(bus.NewClient(), "cell-b").Publish("rep-a.score", []byte("payload"))
+			bus.NewClient().Publish("rep-a.score", []byte("payload"))
+			Consistently(messages).ShouldNot(Receive())
+		})
+
+		It("should drop requests whose replies couldn't get back out of the namespace", func() {
+			bus.NewClient().PublishWithReplyTo("cell-a.rep-a.score", "_INBOX.reply", []byte("payload"))
+			Consistently(messages).ShouldNot(Receive())
+		})
+	})
+
+	Describe("clusters sharing a bus", func() {
+		var cellA, cellB *repnatsclient.RepNatsClient
+		var instance types.Instance
+
+		serveReps := func(namespace communication.Namespace, containers int, guids ...string) {
+			for _, guid := range guids {
+				_, err := repnatsserver.Serve(New(bus.NewClient(), namespace), auctionrep.New(guid, simulationrepdelegate.New(types.Resources{
+					MemoryMB:   100,
+					DiskMB:     100,
+					Containers: containers,
+				})), nil, nil, nil)
+				Ω(err).ShouldNot(HaveOccurred())
+			}
+		}
+
+		BeforeEach(func() {
+			serveReps("cell-a", 10, "rep-a")
+			serveReps("cell-b", 20, "rep-a", "rep-b")
+
+			cellA = repnatsclient.New(New(bus.NewClient(), "cell-a"), 100*time.Millisecond, communication.JSON, nil)
+			cellB = repnatsclient.New(New(bus.NewClient(), "cell-b"), 100*time.Millisecond, communication.JSON, nil)
+
+			instance = types.Instance{
+				AppGuid:      "app-guid",
+				InstanceGuid: "instance-guid",
+				Resources:    types.Resources{MemoryMB: 1, DiskMB: 1},
+			}
+		})
+
+		It("should keep reps with the same guid apart", func() {
+			resources, err := cellA.TotalResources("rep-a")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(resources.Containers).Should(Equal(10))
+
+			resources, err = cellB.TotalResources("rep-a")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(resources.Containers).Should(Equal(20))
+
+			cellA.ScoreThenTentativelyReserve([]string{"rep-a"}, instance)
+			cellA.Claim("rep-a", instance)
+			Ω(cellA.Instances("rep-a")).Should(HaveLen(1))
+			Ω(cellB.Instances("rep-a")).Should(BeEmpty())
+		})
+
+		It("should only broadcast to reps in the namespace", func() {
+			Ω(cellA.BroadcastScore(3, instance)).Should(HaveLen(1))
+			Ω(cellB.BroadcastScore(3, instance)).Should(HaveLen(2))
+		})
+
+		It("should not reach reps from outside every namespace", func() {
+			outsider := repnatsclient.New(bus.NewClient(), 100*time.Millisecond, communication.JSON, nil)
+			_, err := outsider.TotalResources("rep-a")
+			Ω(err).Should(Equal(communication.TimeoutError))
+		})
+
+		It("should only hand auctions to auctioneers in the namespace", func() {
+			_, err := auctioneernatsserver.Serve(New(bus.NewClient(), "cell-a"), cellA, 10, nil)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			auctionRequest := types.AuctionRequest{
+				Instance: instance,
+				RepGuids: types.RepGuids{"rep-a"},
+				Rules:    types.AuctionRules{Algorithm: "reserve_n_best", MaxRounds: 1, MaxBiddingPool: 1},
+			}
+
+			result, err := auctioneernatsclient.New(New(bus.NewClient(), "cell-a"), time.Second, communication.JSON, nil).Auction(auctionRequest)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(result.Winner).Should(Equal("rep-a"))
+
+			_, err = auctioneernatsclient.New(New(bus.NewClient(), "cell-b"), 100*time.Millisecond, communication.JSON, nil).Auction(auctionRequest)
+			Ω(err).Should(Equal(communication.TimeoutError))
+		})
+	})
+})
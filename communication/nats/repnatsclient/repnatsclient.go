@@ -1,209 +1,378 @@
 package repnatsclient
 
 import (
-	"encoding/json"
-	"errors"
 	"log"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/cloudfoundry/yagnats"
+	"github.com/onsi/auction/communication"
 	"github.com/onsi/auction/types"
 	"github.com/onsi/auction/util"
 )
 
-var TimeoutError = errors.New("timeout")
-var RequestFailedError = errors.New("request failed")
+var TimeoutError = communication.TimeoutError
+var RequestFailedError = communication.RequestFailedError
 
+const inboxPrefix = "_INBOX."
+
+// RepNatsClient receives every reply on a single wildcard subscription, its
+// inbox, and routes each one to the caller waiting on its correlation id.  The
+// inbox is subscribed to on the first request and stays subscribed; yagnats
+// resubscribes it if the connection drops.
 type RepNatsClient struct {
 	client  yagnats.NATSClient
 	timeout time.Duration
+	codec   communication.Codec
+	signer  *communication.Signer
+	wire    *communication.WireCounter
+
+	inbox      string
+	subscribed bool
+	nextID     uint64
+	waiting    map[string]func(payload []byte)
+	lock       *sync.Mutex
 }
 
-func New(client yagnats.NATSClient, timeout time.Duration) *RepNatsClient {
+// New makes requests over client.  With a signer (which may be nil) requests
+// are signed, and only replies signed with the same key are accepted.
+func New(client yagnats.NATSClient, timeout time.Duration, codec communication.Codec, signer *communication.Signer) *RepNatsClient {
 	return &RepNatsClient{
 		client:  client,
 		timeout: timeout,
+		codec:   codec,
+		signer:  signer,
+		wire:    communication.NewWireCounter(),
+		inbox:   inboxPrefix + util.RandomGuid(),
+		waiting: map[string]func(payload []byte){},
+		lock:    &sync.Mutex{},
 	}
 }
 
-func (rep *RepNatsClient) publishWithTimeout(guid string, subject string, req interface{}, resp interface{}) (err error) {
-	replyTo := util.RandomGuid()
-	c := make(chan []byte, 1)
+func (rep *RepNatsClient) subscribeToInbox() error {
+	rep.lock.Lock()
+	defer rep.lock.Unlock()
 
-	subscriptionID, err := rep.client.Subscribe(replyTo, func(msg *yagnats.Message) {
-		c <- msg.Payload
-	})
+	if rep.subscribed {
+		return nil
+	}
+
+	_, err := rep.client.Subscribe(rep.inbox+".*", rep.route)
 	if err != nil {
 		return err
 	}
-	defer rep.client.Unsubscribe(subscriptionID)
 
-	payload := []byte{}
-	if req != nil {
-		payload, err = json.Marshal(req)
-		if err != nil {
-			return err
-		}
-	}
+	rep.subscribed = true
+	return nil
+}
 
-	rep.client.PublishWithReplyTo(guid+"."+subject, replyTo, payload)
+func (rep *RepNatsClient) route(msg *yagnats.Message) {
+	//replies that can't be opened still count, as they are
+	reply, _ := communication.Open(msg.Payload)
+	rep.wire.CountReceived(len(msg.Payload), reply)
 
-	select {
-	case payload := <-c:
-		if string(payload) == "error" {
-			return RequestFailedError
-		}
+	id := strings.TrimPrefix(msg.Subject, rep.inbox+".")
 
-		if resp != nil {
-			return json.Unmarshal(payload, resp)
-		}
+	rep.lock.Lock()
+	callback, ok := rep.waiting[id]
+	rep.lock.Unlock()
 
-		return nil
+	if ok {
+		callback(msg.Payload)
+	}
+}
 
-	case <-time.After(rep.timeout):
-		return TimeoutError
+// await returns a fresh reply subject whose replies are handed to callback
+// until forget is called.
+func (rep *RepNatsClient) await(callback func(payload []byte)) (replyTo string, forget func()) {
+	rep.lock.Lock()
+	rep.nextID++
+	id := strconv.FormatUint(rep.nextID, 36)
+	rep.waiting[id] = callback
+	rep.lock.Unlock()
+
+	return rep.inbox + "." + id, func() {
+		rep.lock.Lock()
+		delete(rep.waiting, id)
+		rep.lock.Unlock()
 	}
 }
 
-func (rep *RepNatsClient) TotalResources(guid string) types.Resources {
-	var totalResources types.Resources
-	err := rep.publishWithTimeout(guid, "total_resources", nil, &totalResources)
-	if err != nil {
-		panic(err)
+func (rep *RepNatsClient) encode(req interface{}) []byte {
+	payload := []byte{}
+	if req != nil {
+		payload, _ = rep.codec.Marshal(req)
 	}
+	return payload
+}
 
-	return totalResources
+// seal stamps every request to guid (or, for broadcasts, to no rep in
+// particular) with a deadline, after which we'll have stopped listening for the
+// reply and reps needn't bother.  Its reply subject, less the inbox prefix,
+// doubles as its request id.
+func (rep *RepNatsClient) seal(guid string, subject string, replyTo string, body []byte) communication.Envelope {
+	return rep.signer.Sign(guid, subject, communication.Envelope{
+		Version:     communication.ProtocolVersion,
+		RequestID:   strings.TrimPrefix(replyTo, inboxPrefix),
+		Deadline:    time.Now().Add(rep.timeout),
+		ContentType: rep.codec.ContentType(),
+		Body:        body,
+	})
 }
 
-func (rep *RepNatsClient) Instances(guid string) []types.Instance {
-	var instances []types.Instance
-	err := rep.publishWithTimeout(guid, "instances", nil, &instances)
+func (rep *RepNatsClient) publish(subject string, replyTo string, request communication.Envelope) error {
+	sealed := communication.Seal(request)
+	rep.wire.CountSent(len(sealed), request)
+	return rep.client.PublishWithReplyTo(subject, replyTo, sealed)
+}
+
+// WireBytes counts the sealed requests published and the replies received.
+func (rep *RepNatsClient) WireBytes() communication.WireBytes {
+	return rep.wire.Bytes()
+}
+
+func (rep *RepNatsClient) decode(guid string, subject string, sealed []byte, resp interface{}) error {
+	reply, err := communication.Open(sealed)
+	if err != nil {
+		return err
+	}
+
+	err = rep.signer.Verify(guid, subject, reply)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	return instances
+	return reply.Decode(resp)
 }
 
-func (rep *RepNatsClient) Reset(guid string) {
-	err := rep.publishWithTimeout(guid, "reset", nil, nil)
+func (rep *RepNatsClient) publishWithTimeout(guid string, subject string, req interface{}, resp interface{}) (err error) {
+	err = rep.subscribeToInbox()
 	if err != nil {
-		panic(err)
+		return communication.TransportError{Err: err}
 	}
-}
 
-func (rep *RepNatsClient) SetInstances(guid string, instances []types.Instance) {
-	err := rep.publishWithTimeout(guid, "set_instances", instances, nil)
+	c := make(chan []byte, 1)
+	replyTo, forget := rep.await(func(payload []byte) {
+		select {
+		case c <- payload:
+		default:
+		}
+	})
+	defer forget()
+
+	err = rep.publish(guid+"."+subject, replyTo, rep.seal(guid, subject, replyTo, rep.encode(req)))
 	if err != nil {
-		panic(err)
+		return communication.TransportError{Err: err}
+	}
+
+	select {
+	case payload := <-c:
+		return rep.decode(guid, subject, payload, resp)
+
+	case <-time.After(rep.timeout):
+		return TimeoutError
 	}
 }
 
-func (rep *RepNatsClient) batch(subject string, guids []string, instance types.Instance) types.ScoreResults {
-	replyTo := util.RandomGuid()
+func (rep *RepNatsClient) TotalResources(guid string) (types.Resources, error) {
+	var totalResources types.Resources
+	err := rep.publishWithTimeout(guid, communication.TotalResourcesSubject, nil, &totalResources)
+	return totalResources, err
+}
 
-	allReceived := new(sync.WaitGroup)
-	allReceived.Add(len(guids))
-	responses := make(chan types.ScoreResult, len(guids))
+func (rep *RepNatsClient) Instances(guid string) ([]types.Instance, error) {
+	var instances []types.Instance
+	err := rep.publishWithTimeout(guid, communication.InstancesSubject, nil, &instances)
+	return instances, err
+}
 
-	n := 0
-	subscriptionID, err := rep.client.Subscribe(replyTo, func(msg *yagnats.Message) {
-		n++
-		defer allReceived.Done()
+func (rep *RepNatsClient) Reset(guid string) error {
+	return rep.publishWithTimeout(guid, communication.ResetSubject, nil, nil)
+}
+
+func (rep *RepNatsClient) SetInstances(guid string, instances []types.Instance) error {
+	return rep.publishWithTimeout(guid, communication.SetInstancesSubject, instances, nil)
+}
+
+func (rep *RepNatsClient) batch(subject string, guids []string, payloads [][]byte) types.ScoreResults {
+	return rep.gather(subject, guids, payloads, func(guid string, payload []byte) types.ScoreResult {
 		var result types.ScoreResult
-		err := json.Unmarshal(msg.Payload, &result)
+		err := rep.decode(guid, subject, payload, &result)
 		if err != nil {
-			return
+			return communication.ErrorResult(guid, err)
 		}
-
-		responses <- result
+		result.Rep = guid
+		return result
 	})
+}
 
-	if err != nil {
-		return types.ScoreResults{}
-	}
+// gather sends each rep its payload with a reply subject of its own and waits
+// for the replies, or the timeout.  It always returns one result per rep, in
+// the order the reps were given: reps that don't reply in time get a
+// TimeoutError result and duplicate or late replies are ignored.
+func (rep *RepNatsClient) gather(subject string, guids []string, payloads [][]byte, parse func(guid string, payload []byte) types.ScoreResult) types.ScoreResults {
+	lock := &sync.Mutex{}
+	results := make(types.ScoreResults, len(guids))
+	received := make([]bool, len(guids))
+	pending := len(guids)
+	finished := false
+	done := make(chan struct{})
 
-	defer rep.client.Unsubscribe(subscriptionID)
+	record := func(i int, result types.ScoreResult) {
+		lock.Lock()
+		defer lock.Unlock()
 
-	payload, _ := json.Marshal(instance)
+		if finished || received[i] {
+			return
+		}
 
-	for _, guid := range guids {
-		rep.client.PublishWithReplyTo(guid+"."+subject, replyTo, payload)
+		received[i] = true
+		results[i] = result
+		pending--
+		if pending == 0 {
+			close(done)
+		}
 	}
 
-	done := make(chan struct{})
-	go func() {
-		allReceived.Wait()
-		close(done)
-	}()
+	inboxErr := rep.subscribeToInbox()
 
-	select {
-	case <-done:
-	case <-time.After(rep.timeout):
-		println("TIMING OUT!!")
-	}
+	for i, guid := range guids {
+		i, guid := i, guid
+		results[i] = communication.ErrorResult(guid, TimeoutError)
 
-	results := types.ScoreResults{}
+		if inboxErr != nil {
+			record(i, communication.ErrorResult(guid, communication.TransportError{Err: inboxErr}))
+			continue
+		}
+
+		replyTo, forget := rep.await(func(payload []byte) {
+			record(i, parse(guid, payload))
+		})
+		defer forget()
+
+		err := rep.publish(guid+"."+subject, replyTo, rep.seal(guid, subject, replyTo, payloads[i]))
+		if err != nil {
+			record(i, communication.ErrorResult(guid, communication.TransportError{Err: err}))
+		}
+	}
 
-	for {
+	if len(guids) > 0 {
 		select {
-		case res := <-responses:
-			results = append(results, res)
-		default:
-			return results
+		case <-done:
+		case <-time.After(rep.timeout):
 		}
 	}
 
-	return results
+	lock.Lock()
+	defer lock.Unlock()
+	finished = true
+
+	return append(types.ScoreResults{}, results...)
 }
 
 func (rep *RepNatsClient) Score(guids []string, instance types.Instance) types.ScoreResults {
-	return rep.batch("score", guids, instance)
+	payload := rep.encode(instance)
+	return rep.batch(communication.ScoreSubject, guids, repeatPayload(payload, len(guids)))
 }
 
-func (rep *RepNatsClient) ScoreThenTentativelyReserve(guids []string, instance types.Instance) types.ScoreResults {
-	return rep.batch("score_then_tentatively_reserve", guids, instance)
-}
+// BroadcastScore publishes one score request to every rep listening on the
+// broadcast subject and collects their replies on a single reply subject.
+func (rep *RepNatsClient) BroadcastScore(k int, instance types.Instance) types.ScoreResults {
+	err := rep.subscribeToInbox()
+	if err != nil {
+		log.Println("failed to broadcast score:", err)
+		return types.ScoreResults{}
+	}
 
-func (rep *RepNatsClient) ReleaseReservation(guids []string, instance types.Instance) {
-	replyTo := util.RandomGuid()
+	lock := &sync.Mutex{}
+	results := types.ScoreResults{}
+	replied := map[string]bool{}
+	scored := 0
+	finished := false
+	done := make(chan struct{})
+
+	replyTo, forget := rep.await(func(payload []byte) {
+		var result types.ScoreResult
+		if rep.decode("", communication.BroadcastScoreSubject, payload, &result) != nil {
+			return
+		}
 
-	allReceived := new(sync.WaitGroup)
-	allReceived.Add(len(guids))
+		lock.Lock()
+		defer lock.Unlock()
 
-	subscriptionID, err := rep.client.Subscribe(replyTo, func(msg *yagnats.Message) {
-		allReceived.Done()
+		if finished || replied[result.Rep] {
+			return
+		}
+
+		replied[result.Rep] = true
+		results = append(results, result)
+		if result.Error == "" {
+			scored++
+			if scored == k {
+				finished = true
+				close(done)
+			}
+		}
 	})
+	defer forget()
 
+	err = rep.publish(communication.BroadcastScoreSubject, replyTo, rep.seal("", communication.BroadcastScoreSubject, replyTo, rep.encode(instance)))
 	if err != nil {
-		return
+		log.Println("failed to broadcast score:", err)
+		return types.ScoreResults{}
 	}
 
-	defer rep.client.Unsubscribe(subscriptionID)
+	if k > 0 {
+		select {
+		case <-done:
+		case <-time.After(rep.timeout):
+		}
+	}
 
-	payload, _ := json.Marshal(instance)
+	lock.Lock()
+	defer lock.Unlock()
+	finished = true
 
-	for _, guid := range guids {
-		rep.client.PublishWithReplyTo(guid+".release-reservation", replyTo, payload)
-	}
+	return append(types.ScoreResults{}, results...)
+}
 
-	done := make(chan struct{})
-	go func() {
-		allReceived.Wait()
-		close(done)
-	}()
+func (rep *RepNatsClient) ScoreThenTentativelyReserve(guids []string, instance types.Instance) types.ScoreResults {
+	payload := rep.encode(instance)
+	return rep.batch(communication.ScoreThenTentativelyReserveSubject, guids, repeatPayload(payload, len(guids)))
+}
 
-	select {
-	case <-done:
-	case <-time.After(rep.timeout):
-		println("TIMING OUT!!")
+func (rep *RepNatsClient) TentativelyReserveIfUnchanged(scores types.ScoreResults, instance types.Instance) types.ScoreResults {
+	payloads := [][]byte{}
+	for _, score := range scores {
+		payloads = append(payloads, rep.encode(types.ReserveIfUnchangedRequest{
+			Instance:   instance,
+			Generation: score.Generation,
+		}))
 	}
+
+	return rep.batch(communication.TentativelyReserveIfUnchangedSubject, scores.Reps(), payloads)
+}
+
+func (rep *RepNatsClient) ReleaseReservation(guids []string, instance types.Instance) {
+	payload := rep.encode(instance)
+	rep.gather(communication.ReleaseReservationSubject, guids, repeatPayload(payload, len(guids)), func(guid string, payload []byte) types.ScoreResult {
+		return types.ScoreResult{Rep: guid}
+	})
 }
 
 func (rep *RepNatsClient) Claim(guid string, instance types.Instance) {
-	err := rep.publishWithTimeout(guid, "claim", instance, nil)
+	err := rep.publishWithTimeout(guid, communication.ClaimSubject, instance, nil)
 	if err != nil {
 		log.Println("failed to claim:", err)
 	}
 }
+
+func repeatPayload(payload []byte, n int) [][]byte {
+	payloads := make([][]byte, n)
+	for i := range payloads {
+		payloads[i] = payload
+	}
+	return payloads
+}
@@ -0,0 +1,115 @@
+package repnatsclient_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry/yagnats"
+	"github.com/onsi/auction/auctionrep"
+	"github.com/onsi/auction/communication"
+	"github.com/onsi/auction/communication/nats/fakenats"
+	. "github.com/onsi/auction/communication/nats/repnatsclient"
+	"github.com/onsi/auction/communication/nats/repnatsserver"
+	"github.com/onsi/auction/simulation/simulationrepdelegate"
+	"github.com/onsi/auction/types"
+	"github.com/onsi/auction/util"
+)
+
+// Run with
+//
+//	go test -run NONE -bench . ./communication/nats/repnatsclient/
+//
+// to compare the shared inbox with the subscription-per-request scheme it
+// replaced.  ops/auction counts the subscribes, unsubscribes and publishes
+// each Score asks of NATS.
+
+const benchmarkReps = 20
+
+func benchmarkPool(b *testing.B) (*fakenats.Bus, []string) {
+	bus := fakenats.NewBus()
+	guids := []string{}
+	for i := 0; i < benchmarkReps; i++ {
+		guid := fmt.Sprintf("rep-%d", i)
+		_, err := repnatsserver.Serve(bus.NewClient(), auctionrep.New(guid, simulationrepdelegate.New(types.Resources{
+			MemoryMB:   100,
+			DiskMB:     100,
+			Containers: 100,
+		})), nil, nil, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		guids = append(guids, guid)
+	}
+	bus.ResetOperations()
+	return bus, guids
+}
+
+func benchmarkInstance() types.Instance {
+	return types.Instance{
+		AppGuid:      "app-guid",
+		InstanceGuid: "instance-guid",
+		Resources:    types.Resources{MemoryMB: 1, DiskMB: 1},
+	}
+}
+
+func BenchmarkScoreWithSharedInbox(b *testing.B) {
+	bus, guids := benchmarkPool(b)
+	client := New(bus.NewClient(), time.Second, communication.JSON, nil)
+	instance := benchmarkInstance()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		client.Score(guids, instance)
+	}
+	b.ReportMetric(float64(bus.Operations())/float64(b.N), "ops/auction")
+}
+
+func BenchmarkScoreWithSubscriptionPerRequest(b *testing.B) {
+	bus, guids := benchmarkPool(b)
+	natsClient := bus.NewClient()
+	instance := benchmarkInstance()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		scoreWithSubscriptionPerRequest(natsClient, guids, instance, time.Second)
+	}
+	b.ReportMetric(float64(bus.Operations())/float64(b.N), "ops/auction")
+}
+
+// scoreWithSubscriptionPerRequest is how RepNatsClient used to gather
+// replies: a reply subject, and a subscription, for every rep it asks.
+func scoreWithSubscriptionPerRequest(natsClient yagnats.NATSClient, guids []string, instance types.Instance, timeout time.Duration) types.ScoreResults {
+	payload, _ := json.Marshal(instance)
+	framed := communication.Frame(communication.JSON.ContentType(), payload)
+	replyTo := util.RandomGuid()
+
+	replies := make(chan types.ScoreResult, len(guids))
+	for i, guid := range guids {
+		subject := replyTo + "." + strconv.Itoa(i)
+		subscriptionID, _ := natsClient.Subscribe(subject, func(msg *yagnats.Message) {
+			var result types.ScoreResult
+			contentType, body, _ := communication.Unframe(msg.Payload)
+			communication.DecodeResponse(contentType, body, &result)
+			replies <- result
+		})
+		defer natsClient.Unsubscribe(subscriptionID)
+
+		natsClient.PublishWithReplyTo(guid+"."+communication.ScoreSubject, subject, framed)
+	}
+
+	results := types.ScoreResults{}
+	timer := time.After(timeout)
+	for range guids {
+		select {
+		case result := <-replies:
+			results = append(results, result)
+		case <-timer:
+			return results
+		}
+	}
+
+	return results
+}
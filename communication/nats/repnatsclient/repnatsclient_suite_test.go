@@ -0,0 +1,13 @@
+package repnatsclient_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestRepNatsClient(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "RepNatsClient Suite")
+}
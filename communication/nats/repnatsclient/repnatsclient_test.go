@@ -0,0 +1,222 @@
+package repnatsclient_test
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/cloudfoundry/yagnats"
+	"github.com/onsi/auction/auctionrep"
+	"github.com/onsi/auction/communication"
+	"github.com/onsi/auction/communication/conformance"
+	"github.com/onsi/auction/communication/nats/fakenats"
+	. "github.com/onsi/auction/communication/nats/repnatsclient"
+	"github.com/onsi/auction/communication/nats/repnatsserver"
+	"github.com/onsi/auction/simulation/simulationrepdelegate"
+	"github.com/onsi/auction/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RepNatsClient", func() {
+	var bus *fakenats.Bus
+	var natsClient, stubRepClient *fakenats.Client
+	var client *RepNatsClient
+	var instance types.Instance
+
+	BeforeEach(func() {
+		bus = fakenats.NewBus()
+		natsClient = bus.NewClient()
+		stubRepClient = bus.NewClient()
+		for _, guid := range []string{"rep-a", "rep-b"} {
+			_, err := repnatsserver.Serve(bus.NewClient(), auctionrep.New(guid, simulationrepdelegate.New(types.Resources{
+				MemoryMB:   100,
+				DiskMB:     100,
+				Containers: 10,
+			})), nil, nil, nil)
+			Ω(err).ShouldNot(HaveOccurred())
+		}
+
+		client = New(natsClient, 100*time.Millisecond, communication.JSON, nil)
+
+		instance = types.Instance{
+			AppGuid:      "app-guid",
+			InstanceGuid: "instance-guid",
+			Resources:    types.Resources{MemoryMB: 1, DiskMB: 1},
+		}
+	})
+
+	conformance.ItBehavesLikeATestRepPoolClient(func() conformance.Fixture {
+		return conformance.Fixture{
+			Client:       client,
+			Timeout:      100 * time.Millisecond,
+			RepResources: types.Resources{MemoryMB: 100, DiskMB: 100, Containers: 10},
+			Responsive:   []string{"rep-a", "rep-b"},
+			Unresponsive: []string{"rep-gone"},
+		}
+	})
+
+	Describe("when the bus duplicates replies", func() {
+		BeforeEach(func() {
+			bus.DuplicateReplies(1)
+		})
+
+		conformance.ItReturnsOneResultPerRep(func() conformance.Fixture {
+			return conformance.Fixture{
+				Client:       client,
+				Timeout:      100 * time.Millisecond,
+				RepResources: types.Resources{MemoryMB: 100, DiskMB: 100, Containers: 10},
+				Responsive:   []string{"rep-a", "rep-b"},
+				Unresponsive: []string{"rep-gone"},
+			}
+		})
+	})
+
+	It("should ignore duplicate replies", func() {
+		repClient := stubRepClient
+		repClient.Subscribe("rep-dup."+communication.ScoreSubject, func(msg *yagnats.Message) {
+			first, _ := json.Marshal(types.ScoreResult{Rep: "rep-dup", Score: 0.5})
+			second, _ := json.Marshal(types.ScoreResult{Rep: "rep-dup", Score: 0.9})
+			repClient.Publish(msg.ReplyTo, communication.Frame(communication.JSON.ContentType(), first))
+			time.Sleep(10 * time.Millisecond)
+			repClient.Publish(msg.ReplyTo, communication.Frame(communication.JSON.ContentType(), second))
+		})
+
+		results := client.Score([]string{"rep-dup", "rep-a"}, instance)
+		Ω(results).Should(HaveLen(2))
+		Ω(results[0]).Should(Equal(types.ScoreResult{Rep: "rep-dup", Score: 0.5}))
+		Ω(results[1].Rep).Should(Equal("rep-a"))
+	})
+
+	It("should receive every reply on one long-lived inbox", func() {
+		bus.ResetOperations()
+
+		client.Score([]string{"rep-a", "rep-b"}, instance)
+		client.Score([]string{"rep-a", "rep-b"}, instance)
+		client.TotalResources("rep-a")
+
+		//1 inbox subscription, then a request and a reply per rep
+		Ω(bus.Operations()).Should(Equal(1 + 2*5))
+	})
+
+	It("should route late replies nowhere", func() {
+		late := make(chan *yagnats.Message, 1)
+		stubRepClient.Subscribe("rep-late."+communication.ScoreSubject, func(msg *yagnats.Message) {
+			late <- msg
+		})
+
+		results := client.Score([]string{"rep-late"}, instance)
+		Ω(results[0].Error).Should(Equal(types.Timeout))
+
+		payload, _ := json.Marshal(types.ScoreResult{Rep: "rep-late", Score: 0.5})
+		stubRepClient.Publish((<-late).ReplyTo, communication.Frame(communication.JSON.ContentType(), payload))
+
+		results = client.Score([]string{"rep-a"}, instance)
+		Ω(results[0].Error).Should(BeEmpty())
+		Ω(results[0].Rep).Should(Equal("rep-a"))
+	})
+
+	Describe("broadcasting score requests", func() {
+		It("should publish once and collect a score from every rep", func() {
+			bus.ResetOperations()
+
+			results := client.BroadcastScore(2, instance)
+			Ω(results.Reps()).Should(HaveLen(2))
+			Ω(results.Reps()).Should(ContainElement("rep-a"))
+			Ω(results.Reps()).Should(ContainElement("rep-b"))
+			Ω(results.FilterErrors()).Should(HaveLen(2))
+
+			//the inbox subscription, one request and two replies
+			Ω(bus.Operations()).Should(Equal(4))
+		})
+
+		It("should return as soon as it has k scores", func() {
+			results := client.BroadcastScore(1, instance)
+			Ω(results).Should(HaveLen(1))
+		})
+
+		It("should return what it has when the timeout elapses", func() {
+			t := time.Now()
+			results := client.BroadcastScore(3, instance)
+			Ω(time.Since(t)).Should(BeNumerically(">=", 100*time.Millisecond))
+			Ω(results).Should(HaveLen(2))
+		})
+
+		It("should return errors without counting them towards k", func() {
+			client.ScoreThenTentativelyReserve([]string{"rep-a"}, types.Instance{
+				AppGuid:      "app-guid",
+				InstanceGuid: "filling",
+				Resources:    types.Resources{MemoryMB: 100, DiskMB: 1},
+			})
+
+			results := client.BroadcastScore(1, instance)
+			Ω(results.FilterErrors()).Should(HaveLen(1))
+			Ω(results.FilterErrors()[0].Rep).Should(Equal("rep-b"))
+		})
+	})
+
+	It("should reserve, claim and release in either codec", func() {
+		for _, codec := range communication.Codecs {
+			client = New(natsClient, 100*time.Millisecond, codec, nil)
+
+			results := client.ScoreThenTentativelyReserve([]string{"rep-a", "rep-b"}, instance)
+			Ω(results.FilterErrors()).Should(HaveLen(2))
+
+			client.Claim("rep-a", instance)
+			client.ReleaseReservation([]string{"rep-b"}, instance)
+
+			Ω(client.Instances("rep-a")).Should(Equal([]types.Instance{instance}))
+			Ω(client.Instances("rep-b")).Should(BeEmpty())
+			Ω(client.Reset("rep-a")).ShouldNot(HaveOccurred())
+		}
+	})
+
+	Describe("signing", func() {
+		var signer *communication.Signer
+
+		BeforeEach(func() {
+			signer = communication.NewSigner([]byte("shared-key"))
+			_, err := repnatsserver.Serve(bus.NewClient(), auctionrep.New("rep-signed", simulationrepdelegate.New(types.Resources{
+				MemoryMB:   100,
+				DiskMB:     100,
+				Containers: 10,
+			})), signer, nil, nil)
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		It("should talk to reps that share its key", func() {
+			client = New(natsClient, 100*time.Millisecond, communication.JSON, signer)
+			Ω(client.Reset("rep-signed")).ShouldNot(HaveOccurred())
+
+			results := client.Score([]string{"rep-signed"}, instance)
+			Ω(results).Should(HaveLen(1))
+			Ω(results[0].Error).Should(BeEmpty())
+		})
+
+		It("should broadcast score requests to reps that share its key", func() {
+			client = New(natsClient, 100*time.Millisecond, communication.JSON, signer)
+
+			results := client.BroadcastScore(1, instance)
+			Ω(results).Should(HaveLen(1))
+			Ω(results[0].Rep).Should(Equal("rep-signed"))
+			Ω(results[0].Error).Should(BeEmpty())
+		})
+
+		It("should be refused by reps when it doesn't sign its requests", func() {
+			Ω(client.Reset("rep-signed")).Should(Equal(communication.UnauthorizedError))
+
+			results := client.Score([]string{"rep-signed"}, instance)
+			Ω(results[0].Error).Should(Equal(types.RequestFailed))
+			Ω(results[0].Message).Should(Equal(communication.UnauthorizedError.Error()))
+		})
+
+		It("should reject replies signed with another key", func() {
+			client = New(natsClient, 100*time.Millisecond, communication.JSON, communication.NewSigner([]byte("another-key")))
+			Ω(client.Reset("rep-signed")).Should(Equal(communication.BadSignatureError))
+		})
+	})
+
+	It("should return errors from the test methods when a rep doesn't respond", func() {
+		_, err := client.Instances("rep-gone")
+		Ω(err).Should(Equal(TimeoutError))
+	})
+})
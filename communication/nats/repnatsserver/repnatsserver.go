@@ -1,154 +1,178 @@
 package repnatsserver
 
 import (
-	"encoding/json"
+	"crypto/tls"
 	"fmt"
-	"log"
+	"sync"
 
 	"github.com/cloudfoundry/yagnats"
 	"github.com/onsi/auction/auctionrep"
-	"github.com/onsi/auction/types"
+	"github.com/onsi/auction/communication"
+	"github.com/onsi/auction/communication/nats/natsnamespace"
 )
 
-var errorResponse = []byte("error")
-var successResponse = []byte("ok")
+type Server struct {
+	client          yagnats.NATSClient
+	ownsClient      bool
+	rep             *auctionrep.AuctionRep
+	signer          *communication.Signer
+	dispatcher      *communication.RepDispatcher
+	subscriptionIDs []int64
+	inFlight        *communication.InFlight
+	onStateChange   communication.StateCallback
+	stopOnce        *sync.Once
+	stopped         chan struct{}
+}
 
-func Start(natsAddrs []string, rep *auctionrep.AuctionRep) {
+// Start connects to NATS, over TLS if tlsConfig isn't nil, and serves rep
+// until Stop is called.  yagnats
+// reconnects and resubscribes on its own when the connection drops;
+// onStateChange (which may be nil) hears about it.  With a signer (which may
+// also be nil) the server only answers requests signed with its key, and with
+// an admission (ditto) it turns away bids beyond its limits.  The rep is served
+// in namespace (see natsnamespace).
+func Start(natsAddrs []string, tlsConfig *tls.Config, namespace communication.Namespace, rep *auctionrep.AuctionRep, signer *communication.Signer, admission *communication.Admission, onStateChange communication.StateCallback) (*Server, error) {
 	client := yagnats.NewClient()
 
 	clusterInfo := &yagnats.ConnectionCluster{}
 
 	for _, addr := range natsAddrs {
 		clusterInfo.Members = append(clusterInfo.Members, &yagnats.ConnectionInfo{
-			Addr: addr,
+			Addr:      addr,
+			TLSConfig: tlsConfig,
 		})
 	}
 
+	if onStateChange != nil {
+		client.SetLogger(&stateLogger{Logger: client.Logger(), onStateChange: onStateChange})
+		client.ConnectedCallback = func() {
+			onStateChange(communication.Connected)
+		}
+	}
+
 	err := client.Connect(clusterInfo)
 	if err != nil {
-		log.Fatalln("no nats:", err)
+		return nil, err
 	}
 
-	guid := rep.Guid()
+	server, err := Serve(natsnamespace.New(client, namespace), rep, signer, admission, onStateChange)
+	if err != nil {
+		client.Disconnect()
+		return nil, err
+	}
+	server.ownsClient = true
 
-	client.Subscribe(guid+".total_resources", func(msg *yagnats.Message) {
-		jresources, _ := json.Marshal(rep.TotalResources())
-		client.Publish(msg.ReplyTo, jresources)
-	})
+	fmt.Printf("[%s] listening for nats\n", rep.Guid())
 
-	client.Subscribe(guid+".reset", func(msg *yagnats.Message) {
-		rep.Reset()
-		client.Publish(msg.ReplyTo, successResponse)
-	})
+	return server, nil
+}
 
-	client.Subscribe(guid+".set_instances", func(msg *yagnats.Message) {
-		var instances []types.Instance
+// Serve subscribes rep to its subjects, and to the pool-wide broadcast score
+// subject, on an already connected client.
+func Serve(client yagnats.NATSClient, rep *auctionrep.AuctionRep, signer *communication.Signer, admission *communication.Admission, onStateChange communication.StateCallback) (*Server, error) {
+	server := &Server{
+		client:        client,
+		rep:           rep,
+		signer:        signer,
+		dispatcher:    communication.NewRepDispatcher(rep, signer, admission),
+		inFlight:      communication.NewInFlight(),
+		onStateChange: onStateChange,
+		stopOnce:      &sync.Once{},
+		stopped:       make(chan struct{}),
+	}
 
-		err := json.Unmarshal(msg.Payload, &instances)
+	for _, subject := range server.dispatcher.Subjects() {
+		subject := subject
+		subscriptionID, err := client.Subscribe(rep.Guid()+"."+subject, func(msg *yagnats.Message) {
+			server.handle(subject, msg)
+		})
 		if err != nil {
-			client.Publish(msg.ReplyTo, errorResponse)
+			server.unsubscribe()
+			return nil, err
 		}
+		server.subscriptionIDs = append(server.subscriptionIDs, subscriptionID)
+	}
 
-		rep.SetInstances(instances)
-		client.Publish(msg.ReplyTo, successResponse)
-	})
-
-	client.Subscribe(guid+".instances", func(msg *yagnats.Message) {
-		jinstances, _ := json.Marshal(rep.Instances())
-		client.Publish(msg.ReplyTo, jinstances)
+	subscriptionID, err := client.Subscribe(communication.BroadcastScoreSubject, func(msg *yagnats.Message) {
+		server.handle(communication.BroadcastScoreSubject, msg)
 	})
+	if err != nil {
+		server.unsubscribe()
+		return nil, err
+	}
+	server.subscriptionIDs = append(server.subscriptionIDs, subscriptionID)
 
-	client.Subscribe(guid+".score", func(msg *yagnats.Message) {
-		var inst types.Instance
-
-		err := json.Unmarshal(msg.Payload, &inst)
-		if err != nil {
-			panic(err)
-		}
-
-		response := types.ScoreResult{
-			Rep: guid,
-		}
-
-		defer func() {
-			payload, _ := json.Marshal(response)
-			client.Publish(msg.ReplyTo, payload)
-		}()
-
-		score, err := rep.Score(inst)
-		if err != nil {
-			response.Error = err.Error()
-			return
-		}
-
-		response.Score = score
-	})
+	return server, nil
+}
 
-	client.Subscribe(guid+".score_then_tentatively_reserve", func(msg *yagnats.Message) {
-		var inst types.Instance
+func (server *Server) handle(subject string, msg *yagnats.Message) {
+	request, err := communication.Open(msg.Payload)
+	if err != nil {
+		server.client.Publish(msg.ReplyTo, communication.Seal(server.dispatcher.Fail(subject, request, communication.BadRequest)))
+		return
+	}
 
-		err := json.Unmarshal(msg.Payload, &inst)
-		if err != nil {
-			panic(err)
-		}
+	if !server.inFlight.Begin() {
+		server.client.Publish(msg.ReplyTo, communication.Seal(server.dispatcher.Refuse(subject, request)))
+		return
+	}
+	defer server.inFlight.End()
 
-		response := types.ScoreResult{
-			Rep: guid,
-		}
+	reply, ok := server.dispatcher.DispatchEnvelope(subject, request)
+	if !ok {
+		return
+	}
+	server.client.Publish(msg.ReplyTo, communication.Seal(reply))
+}
 
-		defer func() {
-			payload, _ := json.Marshal(response)
-			client.Publish(msg.ReplyTo, payload)
-		}()
+func (server *Server) unsubscribe() {
+	for _, subscriptionID := range server.subscriptionIDs {
+		server.client.Unsubscribe(subscriptionID)
+	}
+	server.subscriptionIDs = nil
+}
 
-		score, err := rep.ScoreThenTentativelyReserve(inst)
-		if err != nil {
-			response.Error = err.Error()
-			return
+// Stop lets requests that are already being handled reply (bids that arrive
+// meanwhile are told the rep is draining), unsubscribes, and disconnects if
+// the server made its own connection.  Stop waits for a dropped
+// connection to come back before it can unsubscribe.
+func (server *Server) Stop() {
+	server.stopOnce.Do(func() {
+		server.inFlight.Drain()
+		server.unsubscribe()
+
+		if server.ownsClient {
+			server.client.Disconnect()
 		}
 
-		response.Score = score
-	})
-
-	client.Subscribe(guid+".release-reservation", func(msg *yagnats.Message) {
-		var inst types.Instance
-
-		responsePayload := errorResponse
-		defer func() {
-			client.Publish(msg.ReplyTo, responsePayload)
-		}()
-
-		err := json.Unmarshal(msg.Payload, &inst)
-		if err != nil {
-			log.Println(guid, "invalid score_then_tentatively_reserve request:", err)
-			return
+		if server.onStateChange != nil {
+			server.onStateChange(communication.Stopped)
 		}
 
-		rep.ReleaseReservation(inst) //need to handle error
-
-		responsePayload = successResponse
+		close(server.stopped)
 	})
+}
 
-	client.Subscribe(guid+".claim", func(msg *yagnats.Message) {
-		var inst types.Instance
-
-		responsePayload := errorResponse
-		defer func() {
-			client.Publish(msg.ReplyTo, responsePayload)
-		}()
-
-		err := json.Unmarshal(msg.Payload, &inst)
-		if err != nil {
-			log.Println(guid, "invalid score_then_tentatively_reserve request:", err)
-			return
-		}
-
-		rep.Claim(inst) //need to handle error
+// Expired is the number of requests dropped because their deadline had passed.
+func (server *Server) Expired() uint64 {
+	return server.dispatcher.Expired()
+}
 
-		responsePayload = successResponse
-	})
+// Wait blocks until the server has stopped.
+func (server *Server) Wait() {
+	<-server.stopped
+}
 
-	fmt.Printf("[%s] listening for nats\n", guid)
+// stateLogger is how we learn that yagnats has lost its connection: it only
+// tells its logger.
+type stateLogger struct {
+	yagnats.Logger
+	onStateChange communication.StateCallback
+}
 
-	select {}
+func (l *stateLogger) Warn(message string) {
+	if message == "client.connection.disconnected" {
+		l.onStateChange(communication.Disconnected)
+	}
+	l.Logger.Warn(message)
 }
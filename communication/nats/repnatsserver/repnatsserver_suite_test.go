@@ -0,0 +1,13 @@
+package repnatsserver_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestRepNatsServer(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "RepNatsServer Suite")
+}
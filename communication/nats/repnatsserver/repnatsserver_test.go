@@ -0,0 +1,298 @@
+package repnatsserver_test
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudfoundry/yagnats"
+	"github.com/onsi/auction/auctionrep"
+	"github.com/onsi/auction/auctionrep/fakedelegate"
+	"github.com/onsi/auction/communication"
+	"github.com/onsi/auction/communication/nats/fakenats"
+	. "github.com/onsi/auction/communication/nats/repnatsserver"
+	"github.com/onsi/auction/communication/testcerts"
+	"github.com/onsi/auction/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// serveNATS answers a yagnats client on conn just enough for it to connect and
+// subscribe, and reports the subjects it subscribes to
+func serveNATS(conn net.Conn, subscribed chan<- string) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "PING":
+			conn.Write([]byte("PONG\r\n"))
+		case "PUB":
+			reader.ReadString('\n')
+			conn.Write([]byte("+OK\r\n"))
+		case "SUB":
+			subscribed <- fields[1]
+			conn.Write([]byte("+OK\r\n"))
+		default:
+			conn.Write([]byte("+OK\r\n"))
+		}
+	}
+}
+
+var _ = Describe("RepNatsServer", func() {
+	var bus *fakenats.Bus
+	var natsClient *fakenats.Client
+	var release chan struct{}
+	var arrived int32
+	var server *Server
+	var states []communication.ConnectionState
+	var statesLock *sync.Mutex
+	var replies chan []byte
+
+	BeforeEach(func() {
+		bus = fakenats.NewBus()
+		natsClient = bus.NewClient()
+		release = make(chan struct{})
+		arrived = 0
+		states = []communication.ConnectionState{}
+		statesLock = &sync.Mutex{}
+
+		var err error
+		server, err = Serve(bus.NewClient(), auctionrep.New("rep", fakedelegate.BlockingDelegate{Arrived: &arrived, Release: release}), nil, nil, func(state communication.ConnectionState) {
+			statesLock.Lock()
+			states = append(states, state)
+			statesLock.Unlock()
+		})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		replies = make(chan []byte, 10)
+		received := replies
+		natsClient.Subscribe("reply", func(msg *yagnats.Message) {
+			received <- msg.Payload
+		})
+	})
+
+	score := func() {
+		payload, _ := json.Marshal(types.Instance{InstanceGuid: "instance", Resources: types.Resources{MemoryMB: 1, DiskMB: 1}})
+		natsClient.PublishWithReplyTo("rep."+communication.ScoreSubject, "reply", communication.Frame(communication.JSON.ContentType(), payload))
+	}
+
+	It("should subscribe to every subject", func() {
+		Ω(bus.Subscribers("rep." + communication.ScoreSubject)).Should(Equal(1))
+		Ω(bus.Subscribers("rep." + communication.ClaimSubject)).Should(Equal(1))
+		Ω(bus.Subscribers(communication.BroadcastScoreSubject)).Should(Equal(1))
+	})
+
+	It("should answer requests", func() {
+		close(release)
+		score()
+		Eventually(replies).Should(Receive())
+	})
+
+	It("should drop expired requests and count them", func() {
+		close(release)
+
+		payload, _ := json.Marshal(types.Instance{InstanceGuid: "instance", Resources: types.Resources{MemoryMB: 1, DiskMB: 1}})
+		natsClient.PublishWithReplyTo("rep."+communication.ScoreSubject, "reply", communication.FrameWithDeadline(communication.JSON.ContentType(), time.Now().Add(-time.Second), payload))
+		natsClient.PublishWithReplyTo("rep."+communication.ScoreSubject, "reply", communication.FrameWithDeadline(communication.JSON.ContentType(), time.Now().Add(time.Second), payload))
+
+		Eventually(replies).Should(Receive())
+		Consistently(replies).ShouldNot(Receive())
+		Ω(server.Expired()).Should(Equal(uint64(1)))
+	})
+
+	It("should answer broadcast score requests", func() {
+		close(release)
+
+		payload, _ := json.Marshal(types.Instance{InstanceGuid: "instance", Resources: types.Resources{MemoryMB: 1, DiskMB: 1}})
+		natsClient.PublishWithReplyTo(communication.BroadcastScoreSubject, "reply", communication.Frame(communication.JSON.ContentType(), payload))
+
+		var reply []byte
+		Eventually(replies).Should(Receive(&reply))
+
+		var result types.ScoreResult
+		_, body, _ := communication.Unframe(reply)
+		Ω(json.Unmarshal(body, &result)).ShouldNot(HaveOccurred())
+		Ω(result.Rep).Should(Equal("rep"))
+		Ω(result.Error).Should(BeEmpty())
+	})
+
+	It("should answer bids beyond its admission that it is busy", func() {
+		admission, err := communication.NewAdmission(1, 0)
+		Ω(err).ShouldNot(HaveOccurred())
+		busyServer, err := Serve(bus.NewClient(), auctionrep.New("busy-rep", fakedelegate.BlockingDelegate{Arrived: &arrived, Release: release}), nil, admission, nil)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		payload, _ := json.Marshal(types.Instance{InstanceGuid: "instance", Resources: types.Resources{MemoryMB: 1, DiskMB: 1}})
+		natsClient.PublishWithReplyTo("busy-rep."+communication.ScoreSubject, "reply", communication.Frame(communication.JSON.ContentType(), payload))
+		Eventually(func() int32 { return atomic.LoadInt32(&arrived) }).Should(Equal(int32(1)))
+
+		natsClient.PublishWithReplyTo("busy-rep."+communication.ScoreSubject, "reply", communication.Frame(communication.JSON.ContentType(), payload))
+		var reply []byte
+		Eventually(replies).Should(Receive(&reply))
+
+		var result types.ScoreResult
+		_, body, _ := communication.Unframe(reply)
+		Ω(json.Unmarshal(body, &result)).ShouldNot(HaveOccurred())
+		Ω(result.Rep).Should(Equal("busy-rep"))
+		Ω(result.Error).Should(Equal(types.RepBusy))
+		Ω(admission.Busy()).Should(Equal(uint64(1)))
+
+		close(release)
+		Eventually(replies).Should(Receive())
+		busyServer.Stop()
+	})
+
+	Describe("connecting over TLS", func() {
+		var certs *testcerts.Certs
+		var listener net.Listener
+		var subscribed chan string
+
+		BeforeEach(func() {
+			var err error
+			certs, err = testcerts.Generate("127.0.0.1")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			listener, err = tls.Listen("tcp", "127.0.0.1:0", certs.ServerConfig())
+			Ω(err).ShouldNot(HaveOccurred())
+
+			subscribed = make(chan string, 100)
+			go func() {
+				for {
+					conn, err := listener.Accept()
+					if err != nil {
+						return
+					}
+					go serveNATS(conn, subscribed)
+				}
+			}()
+		})
+
+		AfterEach(func() {
+			listener.Close()
+		})
+
+		It("should serve the rep over nats servers whose certificates chain to its certificate authority", func() {
+			close(release)
+
+			server, err := Start([]string{listener.Addr().String()}, certs.ClientConfig(), "", auctionrep.New("tls-rep", fakedelegate.BlockingDelegate{Arrived: &arrived, Release: release}), nil, nil, nil)
+			Ω(err).ShouldNot(HaveOccurred())
+			defer server.Stop()
+
+			//the broadcast subject is subscribed to last
+			subjects := []string{}
+			for len(subjects) == 0 || subjects[len(subjects)-1] != communication.BroadcastScoreSubject {
+				var subject string
+				Eventually(subscribed).Should(Receive(&subject))
+				subjects = append(subjects, subject)
+			}
+			Ω(subjects).Should(ContainElement("tls-rep." + communication.ScoreSubject))
+		})
+
+		It("should refuse nats servers whose certificates don't", func() {
+			otherCerts, err := testcerts.Generate("127.0.0.1")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			_, err = Start([]string{listener.Addr().String()}, otherCerts.ClientConfig(), "", auctionrep.New("tls-rep", nil), nil, nil, nil)
+			Ω(err).Should(HaveOccurred())
+			Ω(err.Error()).Should(ContainSubstring("certificate"))
+		})
+	})
+
+	Describe("stopping", func() {
+		It("should let in-flight requests reply before it returns", func() {
+			scored := make(chan struct{})
+			go func() {
+				score()
+				close(scored)
+			}()
+			Eventually(func() int32 { return atomic.LoadInt32(&arrived) }).Should(Equal(int32(1)))
+
+			stopped := make(chan struct{})
+			go func() {
+				server.Stop()
+				close(stopped)
+			}()
+
+			Consistently(stopped).ShouldNot(BeClosed())
+			Ω(replies).ShouldNot(Receive())
+
+			close(release)
+			Eventually(replies).Should(Receive())
+			Eventually(stopped).Should(BeClosed())
+			Eventually(scored).Should(BeClosed())
+		})
+
+		It("should tell bids that arrive while it drains that it is draining", func() {
+			score()
+			Eventually(func() int32 { return atomic.LoadInt32(&arrived) }).Should(Equal(int32(1)))
+
+			stopped := make(chan struct{})
+			go func() {
+				server.Stop()
+				close(stopped)
+			}()
+			Consistently(stopped).ShouldNot(BeClosed())
+
+			score()
+			var reply []byte
+			Eventually(replies).Should(Receive(&reply))
+
+			var result types.ScoreResult
+			_, body, _ := communication.Unframe(reply)
+			Ω(json.Unmarshal(body, &result)).ShouldNot(HaveOccurred())
+			Ω(result.Error).Should(Equal(types.Draining))
+
+			close(release)
+			Eventually(replies).Should(Receive())
+			Eventually(stopped).Should(BeClosed())
+		})
+
+		It("should ignore requests that arrive once it has stopped", func() {
+			close(release)
+			server.Stop()
+
+			score()
+			Consistently(replies).ShouldNot(Receive())
+		})
+
+		It("should unsubscribe, report that it stopped, and let Wait return", func() {
+			close(release)
+
+			waited := make(chan struct{})
+			go func() {
+				server.Wait()
+				close(waited)
+			}()
+			Consistently(waited).ShouldNot(BeClosed())
+
+			server.Stop()
+			server.Stop()
+
+			Eventually(waited).Should(BeClosed())
+			for _, subject := range communication.NewRepDispatcher(auctionrep.New("rep", nil), nil, nil).Subjects() {
+				Ω(bus.Subscribers("rep."+subject)).Should(BeZero(), subject)
+			}
+			Ω(bus.Subscribers(communication.BroadcastScoreSubject)).Should(BeZero())
+
+			statesLock.Lock()
+			defer statesLock.Unlock()
+			Ω(states).Should(Equal([]communication.ConnectionState{communication.Stopped}))
+		})
+	})
+})
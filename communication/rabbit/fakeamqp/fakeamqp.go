@@ -0,0 +1,417 @@
+// Package fakeamqp is an in-process AMQP 0-9-1 broker that real
+// rabbitclient connections (and so the streadway/amqp client underneath them)
+// can dial.  It speaks just enough of the protocol for rabbitclient: it
+// declares queues, delivers what is published to the default exchange to the
+// queue named by the routing key, and ignores acks, expirations and
+// heartbeats beyond echoing them.  Use fakerabbit to fake rabbitclient itself;
+// use fakeamqp to exercise rabbitclient.
+package fakeamqp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+const (
+	frameMethod    = 1
+	frameHeader    = 2
+	frameBody      = 3
+	frameHeartbeat = 8
+	frameEnd       = 0xCE
+)
+
+type method struct {
+	class uint16
+	id    uint16
+}
+
+var (
+	connectionStart   = method{10, 10}
+	connectionStartOk = method{10, 11}
+	connectionTune    = method{10, 30}
+	connectionOpen    = method{10, 40}
+	connectionOpenOk  = method{10, 41}
+	connectionClose   = method{10, 50}
+	connectionCloseOk = method{10, 51}
+	channelOpen       = method{20, 10}
+	channelOpenOk     = method{20, 11}
+	channelClose      = method{20, 40}
+	channelCloseOk    = method{20, 41}
+	queueDeclare      = method{50, 10}
+	queueDeclareOk    = method{50, 11}
+	basicConsume      = method{60, 20}
+	basicConsumeOk    = method{60, 21}
+	basicPublish      = method{60, 40}
+	basicDeliver      = method{60, 60}
+)
+
+var protocolHeader = []byte{'A', 'M', 'Q', 'P', 0, 0, 9, 1}
+
+var UnexpectedEOFError = errors.New("connection closed mid-frame")
+
+type frame struct {
+	kind    byte
+	channel uint16
+	payload []byte
+}
+
+// consumer is the channel of a connection consuming from a queue.
+type consumer struct {
+	conn    *conn
+	channel uint16
+	tag     string
+}
+
+type queue struct {
+	owner    *conn
+	consumer *consumer
+	backlog  []message
+}
+
+// message is a publish's header frame and body, forwarded to the consumer
+// as they were published.
+type message struct {
+	routingKey string
+	header     []byte
+	body       []byte
+}
+
+type Broker struct {
+	listener  net.Listener
+	queues    map[string]*queue
+	conns     map[*conn]bool
+	published uint64
+	lock      *sync.Mutex
+}
+
+// Start listens on a free port on localhost.
+func Start() (*Broker, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	broker := &Broker{
+		listener: listener,
+		queues:   map[string]*queue{},
+		conns:    map[*conn]bool{},
+		lock:     &sync.Mutex{},
+	}
+
+	go broker.accept()
+
+	return broker, nil
+}
+
+// URL is the amqp:// url to dial the broker at.
+func (b *Broker) URL() string {
+	return "amqp://guest:guest@" + b.listener.Addr().String() + "/"
+}
+
+// Published is the number of messages published to the broker.
+func (b *Broker) Published() uint64 {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.published
+}
+
+// Close stops listening and drops every connection.
+func (b *Broker) Close() {
+	b.listener.Close()
+
+	b.lock.Lock()
+	conns := b.conns
+	b.conns = map[*conn]bool{}
+	b.lock.Unlock()
+
+	for c := range conns {
+		c.Close()
+	}
+}
+
+func (b *Broker) accept() {
+	for {
+		netConn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		c := &conn{Conn: netConn, writeLock: &sync.Mutex{}}
+		b.lock.Lock()
+		b.conns[c] = true
+		b.lock.Unlock()
+
+		go b.serve(c)
+	}
+}
+
+type conn struct {
+	net.Conn
+	writeLock *sync.Mutex
+}
+
+func (c *conn) write(frames ...frame) error {
+	buffer := &bytes.Buffer{}
+	for _, f := range frames {
+		buffer.WriteByte(f.kind)
+		binary.Write(buffer, binary.BigEndian, f.channel)
+		binary.Write(buffer, binary.BigEndian, uint32(len(f.payload)))
+		buffer.Write(f.payload)
+		buffer.WriteByte(frameEnd)
+	}
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	_, err := c.Write(buffer.Bytes())
+	return err
+}
+
+func (c *conn) send(channel uint16, m method, args []byte) error {
+	return c.write(methodFrame(channel, m, args))
+}
+
+func methodFrame(channel uint16, m method, args []byte) frame {
+	payload := &bytes.Buffer{}
+	binary.Write(payload, binary.BigEndian, m.class)
+	binary.Write(payload, binary.BigEndian, m.id)
+	payload.Write(args)
+	return frame{kind: frameMethod, channel: channel, payload: payload.Bytes()}
+}
+
+func readFrame(r *bufio.Reader) (frame, error) {
+	var f frame
+	var size uint32
+
+	kind, err := r.ReadByte()
+	if err != nil {
+		return f, err
+	}
+	f.kind = kind
+
+	err = binary.Read(r, binary.BigEndian, &f.channel)
+	if err == nil {
+		err = binary.Read(r, binary.BigEndian, &size)
+	}
+	if err == nil {
+		f.payload = make([]byte, size+1)
+		_, err = io.ReadFull(r, f.payload)
+	}
+	if err != nil {
+		return f, UnexpectedEOFError
+	}
+
+	f.payload = f.payload[:size]
+	return f, nil
+}
+
+func (b *Broker) serve(c *conn) {
+	defer b.drop(c)
+
+	r := bufio.NewReader(c)
+	header := make([]byte, len(protocolHeader))
+	_, err := io.ReadFull(r, header)
+	if err != nil || !bytes.Equal(header, protocolHeader) {
+		return
+	}
+
+	start := &bytes.Buffer{}
+	start.Write([]byte{0, 9})
+	writeLongstr(start, "")
+	writeLongstr(start, "PLAIN")
+	writeLongstr(start, "en_US")
+	if c.send(0, connectionStart, start.Bytes()) != nil {
+		return
+	}
+
+	var publishing *message
+	for {
+		f, err := readFrame(r)
+		if err != nil {
+			return
+		}
+
+		switch f.kind {
+		case frameHeartbeat:
+			c.write(frame{kind: frameHeartbeat})
+
+		case frameHeader:
+			if publishing == nil {
+				return
+			}
+			publishing.header = f.payload
+			if bodySize(f.payload) == 0 {
+				b.route(*publishing)
+				publishing = nil
+			}
+
+		case frameBody:
+			if publishing == nil {
+				return
+			}
+			publishing.body = append(publishing.body, f.payload...)
+			if uint64(len(publishing.body)) >= bodySize(publishing.header) {
+				b.route(*publishing)
+				publishing = nil
+			}
+
+		case frameMethod:
+			args := bytes.NewReader(f.payload[4:])
+			m := method{binary.BigEndian.Uint16(f.payload), binary.BigEndian.Uint16(f.payload[2:])}
+
+			switch m {
+			case connectionStartOk:
+				tune := &bytes.Buffer{}
+				binary.Write(tune, binary.BigEndian, uint16(64))
+				binary.Write(tune, binary.BigEndian, uint32(131072))
+				binary.Write(tune, binary.BigEndian, uint16(0))
+				err = c.send(0, connectionTune, tune.Bytes())
+			case connectionOpen:
+				err = c.send(0, connectionOpenOk, []byte{0})
+			case connectionClose:
+				c.send(0, connectionCloseOk, nil)
+				return
+			case channelOpen:
+				err = c.send(f.channel, channelOpenOk, []byte{0, 0, 0, 0})
+			case channelClose:
+				err = c.send(f.channel, channelCloseOk, nil)
+			case queueDeclare:
+				args.Seek(2, io.SeekCurrent)
+				name := readShortstr(args)
+				b.declare(name, c)
+				declared := &bytes.Buffer{}
+				writeShortstr(declared, name)
+				declared.Write(make([]byte, 8))
+				err = c.send(f.channel, queueDeclareOk, declared.Bytes())
+			case basicConsume:
+				args.Seek(2, io.SeekCurrent)
+				name := readShortstr(args)
+				tag := readShortstr(args)
+				consumed := &bytes.Buffer{}
+				writeShortstr(consumed, tag)
+				err = c.send(f.channel, basicConsumeOk, consumed.Bytes())
+				if err == nil {
+					b.consume(name, &consumer{conn: c, channel: f.channel, tag: tag})
+				}
+			case basicPublish:
+				args.Seek(2, io.SeekCurrent)
+				readShortstr(args)
+				publishing = &message{routingKey: readShortstr(args)}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (b *Broker) declare(name string, owner *conn) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if _, ok := b.queues[name]; !ok {
+		b.queues[name] = &queue{owner: owner}
+	}
+}
+
+func (b *Broker) consume(name string, c *consumer) {
+	b.lock.Lock()
+	q, ok := b.queues[name]
+	if !ok {
+		b.lock.Unlock()
+		return
+	}
+	q.consumer = c
+	backlog := q.backlog
+	q.backlog = nil
+	b.lock.Unlock()
+
+	for _, msg := range backlog {
+		deliver(c, msg)
+	}
+}
+
+func (b *Broker) route(msg message) {
+	b.lock.Lock()
+	b.published++
+	q, ok := b.queues[msg.routingKey]
+	if !ok {
+		b.lock.Unlock()
+		return
+	}
+	c := q.consumer
+	if c == nil {
+		q.backlog = append(q.backlog, msg)
+	}
+	b.lock.Unlock()
+
+	if c != nil {
+		deliver(c, msg)
+	}
+}
+
+func deliver(c *consumer, msg message) {
+	args := &bytes.Buffer{}
+	writeShortstr(args, c.tag)
+	binary.Write(args, binary.BigEndian, uint64(1))
+	args.WriteByte(0)
+	writeShortstr(args, "")
+	writeShortstr(args, msg.routingKey)
+
+	frames := []frame{
+		methodFrame(c.channel, basicDeliver, args.Bytes()),
+		{kind: frameHeader, channel: c.channel, payload: msg.header},
+	}
+	if len(msg.body) > 0 {
+		frames = append(frames, frame{kind: frameBody, channel: c.channel, payload: msg.body})
+	}
+	c.conn.write(frames...)
+}
+
+// drop closes c and deletes the queues it declared, which rabbitclient
+// declares auto-deleting.
+func (b *Broker) drop(c *conn) {
+	c.Close()
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	delete(b.conns, c)
+	for name, q := range b.queues {
+		if q.owner == c {
+			delete(b.queues, name)
+		}
+	}
+}
+
+// bodySize reads the body size out of a content header frame's payload.
+func bodySize(header []byte) uint64 {
+	if len(header) < 12 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(header[4:12])
+}
+
+func readShortstr(r *bytes.Reader) string {
+	length, err := r.ReadByte()
+	if err != nil {
+		return ""
+	}
+	s := make([]byte, length)
+	io.ReadFull(r, s)
+	return string(s)
+}
+
+func writeShortstr(w *bytes.Buffer, s string) {
+	w.WriteByte(byte(len(s)))
+	w.WriteString(s)
+}
+
+func writeLongstr(w *bytes.Buffer, s string) {
+	binary.Write(w, binary.BigEndian, uint32(len(s)))
+	w.WriteString(s)
+}
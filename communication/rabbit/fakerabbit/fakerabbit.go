@@ -0,0 +1,356 @@
+// Package fakerabbit is an in-process stand-in for a RabbitMQ broker.  Clients
+// and servers made from the same Broker talk to each other through in-memory
+// queues with the same semantics the rabbitclient package relies on: messages
+// are routed by queue name, unroutable messages are dropped, messages that
+// expire while queued are dropped, and replies find their request by
+// correlation id.
+package fakerabbit
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/onsi/auction/communication"
+	"github.com/onsi/auction/communication/rabbit/rabbitclient"
+	"github.com/onsi/auction/util"
+)
+
+var NotConnectedError = rabbitclient.DisconnectedError
+
+// messages published to a full queue are dropped, as a real broker would
+// eventually refuse them
+const queueDepth = 4096
+
+type message struct {
+	subject       string
+	replyTo       string
+	correlationID string
+	envelope      communication.Envelope
+}
+
+// onTheWire is what the far end makes of e once it has been carried, like
+// rabbitclient carries it, in headers and a body.
+func onTheWire(e communication.Envelope) communication.Envelope {
+	headers := e.Headers()
+	body := e.Body
+	if e.Version == 0 {
+		body = e.LegacyBody()
+	}
+
+	return communication.EnvelopeFromHeaders(e.ContentType, func(key string) string {
+		return headers[key]
+	}, body)
+}
+
+type Broker struct {
+	queues        map[string]chan message
+	duplicateRate float64
+	lock          *sync.RWMutex
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		queues: map[string]chan message{},
+		lock:   &sync.RWMutex{},
+	}
+}
+
+// DuplicateReplies makes the broker deliver rate (from 0 to 1) of the replies
+// it carries twice, as a broker that redelivers might.
+func (b *Broker) DuplicateReplies(rate float64) {
+	b.lock.Lock()
+	b.duplicateRate = rate
+	b.lock.Unlock()
+}
+
+func (b *Broker) declare(name string) chan message {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	queue := make(chan message, queueDepth)
+	b.queues[name] = queue
+	return queue
+}
+
+func (b *Broker) delete(name string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	queue, ok := b.queues[name]
+	if ok {
+		close(queue)
+		delete(b.queues, name)
+	}
+}
+
+// DropConnections closes every queue, as if the broker had restarted.  Clients
+// and servers reestablish their queues after rabbitclient.ReconnectInterval.
+func (b *Broker) DropConnections() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for name, queue := range b.queues {
+		close(queue)
+		delete(b.queues, name)
+	}
+}
+
+// consume delivers everything sent to the named queue to handle, each delivery
+// in its own goroutine, redeclaring the queue if the broker drops it, until
+// stopped returns true.
+func (b *Broker) consume(name string, handle func(message), stopped func() bool, notify func(communication.ConnectionState)) {
+	deliveries := b.declare(name)
+	notify(communication.Connected)
+
+	go func() {
+		for {
+			for delivery := range deliveries {
+				deadline := delivery.envelope.Deadline
+				if !deadline.IsZero() && time.Now().After(deadline) {
+					continue
+				}
+				go handle(delivery)
+			}
+
+			if stopped() {
+				return
+			}
+
+			notify(communication.Disconnected)
+			time.Sleep(rabbitclient.ReconnectInterval)
+			if stopped() {
+				return
+			}
+
+			deliveries = b.declare(name)
+			notify(communication.Connected)
+		}
+	}()
+}
+
+func (b *Broker) publish(name string, msg message) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	queue, ok := b.queues[name]
+	if !ok {
+		return
+	}
+
+	copies := 1
+	if msg.replyTo == "" && rand.Float64() < b.duplicateRate {
+		copies = 2
+	}
+
+	for i := 0; i < copies; i++ {
+		select {
+		case queue <- msg:
+		default:
+		}
+	}
+}
+
+func (b *Broker) NewClient(id string) rabbitclient.RabbitClientInterface {
+	return &client{
+		id:       id,
+		broker:   b,
+		requests: map[string]chan message{},
+		lock:     &sync.Mutex{},
+	}
+}
+
+func (b *Broker) NewServer(id string) rabbitclient.RabbitServerInterface {
+	return &server{
+		id:       id,
+		broker:   b,
+		handlers: map[string]rabbitclient.Callback{},
+		lock:     &sync.Mutex{},
+	}
+}
+
+// client fails requests that are waiting on a reply as soon as the broker
+// drops its connections, as rabbitclient.RabbitClient does.
+type client struct {
+	id            string
+	broker        *Broker
+	established   bool
+	disconnecting bool
+	lost          chan struct{}
+	requests      map[string]chan message
+	lock          *sync.Mutex
+}
+
+func (c *client) queueName() string {
+	return c.id + "-response"
+}
+
+func (c *client) ConnectAndEstablish() error {
+	c.lock.Lock()
+	c.disconnecting = false
+	c.lock.Unlock()
+
+	c.broker.consume(c.queueName(), c.dispatch, c.isDisconnecting, c.notify)
+
+	return nil
+}
+
+func (c *client) notify(state communication.ConnectionState) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	switch state {
+	case communication.Connected:
+		c.established = true
+		c.lost = make(chan struct{})
+	case communication.Disconnected:
+		c.lose()
+	}
+}
+
+func (c *client) lose() {
+	if c.established {
+		c.established = false
+		close(c.lost)
+	}
+}
+
+func (c *client) dispatch(delivery message) {
+	c.lock.Lock()
+	request, ok := c.requests[delivery.correlationID]
+	c.lock.Unlock()
+	if ok {
+		select {
+		case request <- delivery:
+		default:
+		}
+	}
+}
+
+func (c *client) isDisconnecting() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.disconnecting
+}
+
+func (c *client) Disconnect() error {
+	c.lock.Lock()
+	c.disconnecting = true
+	c.lose()
+	c.lock.Unlock()
+
+	c.broker.delete(c.queueName())
+	return nil
+}
+
+func (c *client) Request(recipientID string, subject string, request communication.Envelope, timeout time.Duration) (communication.Envelope, error) {
+	if request.RequestID == "" {
+		request.RequestID = util.RandomGuid()
+	}
+	if request.Deadline.IsZero() {
+		request.Deadline = time.Now().Add(timeout)
+	}
+	guid := request.RequestID
+	response := make(chan message, 1)
+
+	c.lock.Lock()
+	if !c.established {
+		c.lock.Unlock()
+		return communication.Envelope{}, NotConnectedError
+	}
+	lost := c.lost
+	c.requests[guid] = response
+	c.lock.Unlock()
+
+	defer func() {
+		c.lock.Lock()
+		delete(c.requests, guid)
+		c.lock.Unlock()
+	}()
+
+	c.broker.publish(recipientID, message{
+		subject:       subject,
+		replyTo:       c.queueName(),
+		correlationID: guid,
+		envelope:      onTheWire(request),
+	})
+
+	select {
+	case delivery := <-response:
+		return delivery.envelope, nil
+	case <-lost:
+		return communication.Envelope{}, NotConnectedError
+	case <-time.After(timeout):
+		return communication.Envelope{}, rabbitclient.TimeoutError
+	}
+}
+
+type server struct {
+	id            string
+	broker        *Broker
+	handlers      map[string]rabbitclient.Callback
+	onStateChange communication.StateCallback
+	disconnecting bool
+	lock          *sync.Mutex
+}
+
+func (s *server) ConnectAndEstablish() error {
+	s.broker.consume(s.id, s.dispatch, s.isDisconnecting, s.notify)
+	return nil
+}
+
+func (s *server) dispatch(delivery message) {
+	s.lock.Lock()
+	callback, ok := s.handlers[delivery.subject]
+	s.lock.Unlock()
+	if !ok {
+		return
+	}
+
+	reply, ok := callback(delivery.envelope)
+	if !ok {
+		return
+	}
+
+	s.broker.publish(delivery.replyTo, message{
+		correlationID: delivery.correlationID,
+		envelope:      onTheWire(reply),
+	})
+}
+
+func (s *server) isDisconnecting() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.disconnecting
+}
+
+func (s *server) notify(state communication.ConnectionState) {
+	s.lock.Lock()
+	onStateChange := s.onStateChange
+	s.lock.Unlock()
+
+	if onStateChange != nil {
+		onStateChange(state)
+	}
+}
+
+func (s *server) Disconnect() error {
+	s.lock.Lock()
+	s.disconnecting = true
+	s.lock.Unlock()
+
+	s.broker.delete(s.id)
+	return nil
+}
+
+func (s *server) Handle(subject string, callback rabbitclient.Callback) {
+	s.lock.Lock()
+	s.handlers[subject] = callback
+	s.lock.Unlock()
+}
+
+func (s *server) OnStateChange(callback communication.StateCallback) {
+	s.lock.Lock()
+	s.onStateChange = callback
+	s.lock.Unlock()
+}
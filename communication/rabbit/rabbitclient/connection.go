@@ -0,0 +1,101 @@
+package rabbitclient
+
+import (
+	"crypto/tls"
+	"errors"
+	"time"
+
+	"github.com/onsi/auction/communication"
+	"github.com/streadway/amqp"
+)
+
+// When the connection to the broker drops, clients and servers try to
+// reestablish it after ReconnectInterval, backing off to MaxReconnectInterval.
+var ReconnectInterval = 500 * time.Millisecond
+var MaxReconnectInterval = 10 * time.Second
+
+// DisconnectedError is returned by requests made while the client has no
+// connection to the broker, and by requests that were waiting on a reply
+// when the connection dropped.
+var DisconnectedError = errors.New("disconnected from rabbit")
+
+// dial connects to the broker, over TLS if there is a tlsConfig, and consumes
+// from a freshly declared queue.
+func dial(url string, tlsConfig *tls.Config, queueName string) (*amqp.Connection, *amqp.Channel, <-chan amqp.Delivery, error) {
+	var connection *amqp.Connection
+	var err error
+	if tlsConfig == nil {
+		connection, err = amqp.Dial(url)
+	} else {
+		connection, err = amqp.DialTLS(url, tlsConfig)
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	channel, err := connection.Channel()
+	if err != nil {
+		connection.Close()
+		return nil, nil, nil, err
+	}
+
+	_, err = channel.QueueDeclare(queueName, false, true, false, false, nil)
+	if err != nil {
+		connection.Close()
+		return nil, nil, nil, err
+	}
+
+	deliveries, err := channel.Consume(queueName, "", false, true, false, false, nil)
+	if err != nil {
+		connection.Close()
+		return nil, nil, nil, err
+	}
+
+	return connection, channel, deliveries, nil
+}
+
+// redial calls establish until it succeeds, backing off between attempts.  It
+// gives up, returning false, once stopped returns true.
+func redial(establish func() error, stopped func() bool) bool {
+	interval := ReconnectInterval
+	for {
+		time.Sleep(interval)
+
+		if stopped() {
+			return false
+		}
+
+		if establish() == nil {
+			return true
+		}
+
+		interval *= 2
+		if interval > MaxReconnectInterval {
+			interval = MaxReconnectInterval
+		}
+	}
+}
+
+// publishing carries e's fields in headers, and its body as is (or, for
+// version 0 peers, as they expect it).
+func publishing(e communication.Envelope, publishing amqp.Publishing) amqp.Publishing {
+	publishing.ContentType = e.ContentType
+	publishing.Headers = amqp.Table{}
+	for key, value := range e.Headers() {
+		publishing.Headers[key] = value
+	}
+
+	publishing.Body = e.Body
+	if e.Version == 0 {
+		publishing.Body = e.LegacyBody()
+	}
+
+	return publishing
+}
+
+func envelope(delivery amqp.Delivery) communication.Envelope {
+	return communication.EnvelopeFromHeaders(delivery.ContentType, func(key string) string {
+		value, _ := delivery.Headers[key].(string)
+		return value
+	}, delivery.Body)
+}
@@ -0,0 +1,32 @@
+package rabbitclient
+
+import (
+	"time"
+
+	"github.com/onsi/auction/communication"
+)
+
+// A rabbit server's queue is named for its id, so servers are put in a
+// namespace by qualifying their ids, and clients by qualifying the ids of the
+// servers they send requests to.
+type namespacedClient struct {
+	RabbitClientInterface
+	namespace communication.Namespace
+}
+
+// Namespaced sends client's requests to the servers in namespace.  It returns
+// client itself for the empty namespace.
+func Namespaced(client RabbitClientInterface, namespace communication.Namespace) RabbitClientInterface {
+	if namespace == "" {
+		return client
+	}
+
+	return &namespacedClient{
+		RabbitClientInterface: client,
+		namespace:             namespace,
+	}
+}
+
+func (c *namespacedClient) Request(recipientID string, subject string, request communication.Envelope, timeout time.Duration) (communication.Envelope, error) {
+	return c.RabbitClientInterface.Request(c.namespace.Qualify(recipientID), subject, request, timeout)
+}
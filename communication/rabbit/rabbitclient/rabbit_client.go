@@ -1,38 +1,56 @@
 package rabbitclient
 
 import (
-	"errors"
+	"crypto/tls"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/onsi/auction/communication"
 	"github.com/onsi/auction/util"
 	"github.com/streadway/amqp"
 )
 
-var TimeoutError = errors.New("timeout")
+var TimeoutError = communication.TimeoutError
 
 type RabbitClientInterface interface {
 	ConnectAndEstablish() error
 	Disconnect() error
 
-	Request(recipientID string, subject string, payload []byte, timeout time.Duration) ([]byte, error)
+	// Request fills in the request's id and deadline, unless they are already
+	// set (as they must be before a request is signed).
+	Request(recipientID string, subject string, request communication.Envelope, timeout time.Duration) (communication.Envelope, error)
 }
 
+// RabbitClient may be used from many goroutines: publishes are serialized
+// onto its one amqp.Channel, which streadway/amqp does not allow to be shared.
+// When the connection drops, requests awaiting a reply fail at once with
+// DisconnectedError and the client reconnects in the background, backing off
+// from ReconnectInterval.
 type RabbitClient struct {
-	id         string
-	url        string
-	connection *amqp.Connection
-	channel    *amqp.Channel
-	requests   map[string]chan amqp.Delivery
-	lock       *sync.Mutex
+	id            string
+	url           string
+	tlsConfig     *tls.Config
+	connection    *amqp.Connection
+	channel       *amqp.Channel
+	established   bool
+	disconnecting bool
+	lost          chan struct{}
+	requests      map[string]chan amqp.Delivery
+	lock          *sync.Mutex
+	publishLock   *sync.Mutex
 }
 
-func NewClient(id string, url string) RabbitClientInterface {
+// NewClient makes a client that connects to url, over TLS if tlsConfig isn't
+// nil.
+func NewClient(id string, url string, tlsConfig *tls.Config) RabbitClientInterface {
 	return &RabbitClient{
-		id:       id,
-		url:      url,
-		requests: map[string]chan amqp.Delivery{},
-		lock:     &sync.Mutex{},
+		id:          id,
+		url:         url,
+		tlsConfig:   tlsConfig,
+		requests:    map[string]chan amqp.Delivery{},
+		lock:        &sync.Mutex{},
+		publishLock: &sync.Mutex{},
 	}
 }
 
@@ -41,29 +59,23 @@ func (r *RabbitClient) queueName() string {
 }
 
 func (r *RabbitClient) ConnectAndEstablish() error {
-	var err error
-	r.connection, err = amqp.Dial(r.url)
-	if err != nil {
-		return err
-	}
+	return r.establish()
+}
 
-	r.channel, err = r.connection.Channel()
+func (r *RabbitClient) establish() error {
+	connection, channel, deliveries, err := dial(r.url, r.tlsConfig, r.queueName())
 	if err != nil {
-		r.connection.Close()
 		return err
 	}
 
-	_, err = r.channel.QueueDeclare(r.queueName(), false, true, false, false, nil)
-	if err != nil {
-		r.Disconnect()
-		return err
-	}
+	r.lock.Lock()
+	r.connection = connection
+	r.channel = channel
+	r.established = true
+	r.lost = make(chan struct{})
+	r.lock.Unlock()
 
-	deliveries, err := r.channel.Consume(r.queueName(), "", false, true, false, false, nil)
-	if err != nil {
-		r.Disconnect()
-		return err
-	}
+	closed := connection.NotifyClose(make(chan *amqp.Error, 1))
 
 	go func() {
 		for delivery := range deliveries {
@@ -72,13 +84,49 @@ func (r *RabbitClient) ConnectAndEstablish() error {
 		}
 	}()
 
+	go r.reconnectWhenClosed(closed)
+
 	return nil
 }
 
-func (r *RabbitClient) Request(recipientID string, subject string, payload []byte, timeout time.Duration) ([]byte, error) {
+func (r *RabbitClient) reconnectWhenClosed(closed chan *amqp.Error) {
+	<-closed
+
+	r.lock.Lock()
+	r.established = false
+	close(r.lost)
+	disconnecting := r.disconnecting
+	r.lock.Unlock()
+
+	if disconnecting {
+		return
+	}
+
+	redial(r.establish, r.isDisconnecting)
+}
+
+func (r *RabbitClient) isDisconnecting() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.disconnecting
+}
+
+func (r *RabbitClient) Request(recipientID string, subject string, request communication.Envelope, timeout time.Duration) (communication.Envelope, error) {
 	c := make(chan amqp.Delivery, 1)
-	guid := util.RandomGuid()
+	if request.RequestID == "" {
+		request.RequestID = util.RandomGuid()
+	}
+	if request.Deadline.IsZero() {
+		request.Deadline = time.Now().Add(timeout)
+	}
+	guid := request.RequestID
+
 	r.lock.Lock()
+	if !r.established {
+		r.lock.Unlock()
+		return communication.Envelope{}, DisconnectedError
+	}
+	channel, lost := r.channel, r.lost
 	r.requests[guid] = c
 	r.lock.Unlock()
 
@@ -88,24 +136,38 @@ func (r *RabbitClient) Request(recipientID string, subject string, payload []byt
 		r.lock.Unlock()
 	}()
 
-	err := r.channel.Publish("", recipientID, false, false, amqp.Publishing{
-		ContentType:   "application/json",
+	//the broker drops the request if it is still queued when we give up on
+	//it; the deadline header lets the rep drop it if it is stuck in the rep
+	r.publishLock.Lock()
+	err := channel.Publish("", recipientID, false, false, publishing(request, amqp.Publishing{
 		Type:          subject,
 		ReplyTo:       r.queueName(),
 		CorrelationId: guid,
-		Body:          payload,
-	})
+		Expiration:    expiration(timeout),
+	}))
+	r.publishLock.Unlock()
 
 	if err != nil {
-		return []byte{}, err
+		return communication.Envelope{}, err
 	}
 
 	select {
 	case delivery := <-c:
-		return delivery.Body, nil
+		return envelope(delivery), nil
+	case <-lost:
+		return communication.Envelope{}, DisconnectedError
 	case <-time.After(timeout):
-		return []byte{}, TimeoutError
+		return communication.Envelope{}, TimeoutError
+	}
+}
+
+// expiration is a per-message TTL, in whole milliseconds
+func expiration(timeout time.Duration) string {
+	ms := int64(timeout / time.Millisecond)
+	if ms < 1 {
+		ms = 1
 	}
+	return strconv.FormatInt(ms, 10)
 }
 
 func (r *RabbitClient) dispatch(delivery amqp.Delivery) {
@@ -117,20 +179,28 @@ func (r *RabbitClient) dispatch(delivery amqp.Delivery) {
 		return
 	}
 
-	c <- delivery
+	select {
+	case c <- delivery:
+	default:
+	}
 }
 
 func (r *RabbitClient) Disconnect() error {
-	chanErr := r.channel.Close()
-	connErr := r.connection.Close()
+	r.lock.Lock()
+	r.disconnecting = true
+	channel, connection := r.channel, r.connection
+	r.lock.Unlock()
 
-	if chanErr != nil {
-		return connErr
+	if connection == nil {
+		return nil
 	}
 
-	if connErr != nil {
-		return connErr
+	chanErr := channel.Close()
+	connErr := connection.Close()
+
+	if chanErr != nil {
+		return chanErr
 	}
 
-	return nil
+	return connErr
 }
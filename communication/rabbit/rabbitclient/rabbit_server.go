@@ -1,35 +1,47 @@
 package rabbitclient
 
 import (
+	"crypto/tls"
 	"sync"
 
+	"github.com/onsi/auction/communication"
 	"github.com/streadway/amqp"
 )
 
-type Callback func([]byte) []byte
+// Callback answers a request; no reply is sent if it returns false.
+type Callback func(request communication.Envelope) (communication.Envelope, bool)
 
 type RabbitServerInterface interface {
 	ConnectAndEstablish() error
 	Disconnect() error
 
 	Handle(subject string, callback Callback)
+	OnStateChange(callback communication.StateCallback)
 }
 
 type RabbitServer struct {
-	id         string
-	url        string
-	connection *amqp.Connection
-	channel    *amqp.Channel
-	handlers   map[string]Callback
-	lock       *sync.Mutex
+	id            string
+	url           string
+	tlsConfig     *tls.Config
+	connection    *amqp.Connection
+	channel       *amqp.Channel
+	handlers      map[string]Callback
+	onStateChange communication.StateCallback
+	disconnecting bool
+	lock          *sync.Mutex
+	publishLock   *sync.Mutex
 }
 
-func NewServer(id string, url string) RabbitServerInterface {
+// NewServer makes a server that connects to url, over TLS if tlsConfig isn't
+// nil.
+func NewServer(id string, url string, tlsConfig *tls.Config) RabbitServerInterface {
 	return &RabbitServer{
-		id:       id,
-		url:      url,
-		handlers: map[string]Callback{},
-		lock:     &sync.Mutex{},
+		id:          id,
+		url:         url,
+		tlsConfig:   tlsConfig,
+		handlers:    map[string]Callback{},
+		lock:        &sync.Mutex{},
+		publishLock: &sync.Mutex{},
 	}
 }
 
@@ -38,47 +50,85 @@ func (r *RabbitServer) queueName() string {
 }
 
 func (r *RabbitServer) ConnectAndEstablish() error {
-	var err error
-	r.connection, err = amqp.Dial(r.url)
+	err := r.establish()
 	if err != nil {
 		return err
 	}
 
-	r.channel, err = r.connection.Channel()
-	if err != nil {
-		r.connection.Close()
-		return err
-	}
+	r.notify(communication.Connected)
+	return nil
+}
 
-	_, err = r.channel.QueueDeclare(r.queueName(), false, true, false, false, nil)
+func (r *RabbitServer) establish() error {
+	connection, channel, deliveries, err := dial(r.url, r.tlsConfig, r.queueName())
 	if err != nil {
-		r.Disconnect()
 		return err
 	}
 
-	deliveries, err := r.channel.Consume(r.queueName(), "", false, true, false, false, nil)
-	if err != nil {
-		r.Disconnect()
-		return err
-	}
+	r.lock.Lock()
+	r.connection = connection
+	r.channel = channel
+	r.lock.Unlock()
+
+	closed := connection.NotifyClose(make(chan *amqp.Error, 1))
 
 	go func() {
 		for delivery := range deliveries {
 			delivery.Ack(false)
-			r.dispatch(delivery)
+			go r.dispatch(channel, delivery)
 		}
 	}()
 
+	go r.reconnectWhenClosed(closed)
+
 	return nil
 }
 
+func (r *RabbitServer) reconnectWhenClosed(closed chan *amqp.Error) {
+	<-closed
+
+	if r.isDisconnecting() {
+		return
+	}
+
+	r.notify(communication.Disconnected)
+
+	if redial(r.establish, r.isDisconnecting) {
+		r.notify(communication.Connected)
+	}
+}
+
+func (r *RabbitServer) isDisconnecting() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.disconnecting
+}
+
+func (r *RabbitServer) notify(state communication.ConnectionState) {
+	r.lock.Lock()
+	onStateChange := r.onStateChange
+	r.lock.Unlock()
+
+	if onStateChange != nil {
+		onStateChange(state)
+	}
+}
+
 func (r *RabbitServer) Handle(subject string, callback Callback) {
 	r.lock.Lock()
 	r.handlers[subject] = callback
 	r.lock.Unlock()
 }
 
-func (r *RabbitServer) dispatch(delivery amqp.Delivery) {
+func (r *RabbitServer) OnStateChange(callback communication.StateCallback) {
+	r.lock.Lock()
+	r.onStateChange = callback
+	r.lock.Unlock()
+}
+
+// dispatch answers a delivery.  Each delivery is dispatched in its own
+// goroutine, so one slow request doesn't hold up the ones queued behind it.
+func (r *RabbitServer) dispatch(channel *amqp.Channel, delivery amqp.Delivery) {
 	r.lock.Lock()
 	callback, ok := r.handlers[delivery.Type]
 	r.lock.Unlock()
@@ -86,26 +136,36 @@ func (r *RabbitServer) dispatch(delivery amqp.Delivery) {
 		return
 	}
 
-	response := callback(delivery.Body)
+	reply, ok := callback(envelope(delivery))
+	if !ok {
+		return
+	}
 
-	r.channel.Publish("", delivery.ReplyTo, false, false, amqp.Publishing{
-		ContentType:   "application/json",
+	//deliveries are dispatched concurrently, but the channel can't publish
+	//concurrently
+	r.publishLock.Lock()
+	channel.Publish("", delivery.ReplyTo, false, false, publishing(reply, amqp.Publishing{
 		CorrelationId: delivery.CorrelationId,
-		Body:          response,
-	})
+	}))
+	r.publishLock.Unlock()
 }
 
 func (r *RabbitServer) Disconnect() error {
-	chanErr := r.channel.Close()
-	connErr := r.connection.Close()
+	r.lock.Lock()
+	r.disconnecting = true
+	channel, connection := r.channel, r.connection
+	r.lock.Unlock()
 
-	if chanErr != nil {
-		return connErr
+	if connection == nil {
+		return nil
 	}
 
-	if connErr != nil {
-		return connErr
+	chanErr := channel.Close()
+	connErr := connection.Close()
+
+	if chanErr != nil {
+		return chanErr
 	}
 
-	return nil
+	return connErr
 }
@@ -0,0 +1,71 @@
+package rabbitclient_test
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/onsi/auction/communication"
+	"github.com/onsi/auction/communication/rabbit/fakeamqp"
+	. "github.com/onsi/auction/communication/rabbit/rabbitclient"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RabbitServer", func() {
+	var broker *fakeamqp.Broker
+	var server RabbitServerInterface
+	var client RabbitClientInterface
+
+	BeforeEach(func() {
+		var err error
+		broker, err = fakeamqp.Start()
+		Ω(err).ShouldNot(HaveOccurred())
+
+		server = NewServer("rep", broker.URL(), nil)
+		client = NewClient("auctioneer", broker.URL(), nil)
+	})
+
+	AfterEach(func() {
+		client.Disconnect()
+		server.Disconnect()
+		broker.Close()
+	})
+
+	It("should handle deliveries concurrently and publish every reply intact", func() {
+		const requests = 20
+
+		arrived := make(chan struct{}, requests)
+		release := make(chan struct{})
+		server.Handle("echo", func(request communication.Envelope) (communication.Envelope, bool) {
+			arrived <- struct{}{}
+			<-release
+			return request.Reply(request.Body, communication.NoError), true
+		})
+		Ω(server.ConnectAndEstablish()).ShouldNot(HaveOccurred())
+		Ω(client.ConnectAndEstablish()).ShouldNot(HaveOccurred())
+
+		replies := make(chan bool, requests)
+		for i := 0; i < requests; i++ {
+			go func(body []byte) {
+				reply, err := client.Request("rep", "echo", communication.Envelope{
+					Version:     communication.ProtocolVersion,
+					ContentType: communication.JSON.ContentType(),
+					Body:        body,
+				}, 5*time.Second)
+				replies <- err == nil && string(reply.Body) == string(body)
+			}([]byte(strconv.Itoa(i)))
+		}
+
+		//a server that handled deliveries one at a time would never get
+		//past the first
+		for i := 0; i < requests; i++ {
+			Eventually(arrived).Should(Receive())
+		}
+		close(release)
+
+		for i := 0; i < requests; i++ {
+			Eventually(replies).Should(Receive(BeTrue()))
+		}
+		Ω(broker.Published()).Should(Equal(uint64(2 * requests)))
+	})
+})
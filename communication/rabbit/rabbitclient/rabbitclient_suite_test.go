@@ -0,0 +1,13 @@
+package rabbitclient_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestRabbitClient(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "RabbitClient Suite")
+}
@@ -1,145 +1,89 @@
 package reprabbitclient
 
 import (
-	"encoding/json"
-	"errors"
-	"log"
-	"sync"
+	"crypto/tls"
 	"time"
 
+	"github.com/onsi/auction/communication"
 	"github.com/onsi/auction/communication/rabbit/rabbitclient"
-	"github.com/onsi/auction/types"
 	"github.com/onsi/auction/util"
 )
 
-var TimeoutError = errors.New("timeout")
-var RequestFailedError = errors.New("request failed")
+var TimeoutError = communication.TimeoutError
+var RequestFailedError = communication.RequestFailedError
 
 type RepRabbitClient struct {
+	communication.RepClient
+
 	client  rabbitclient.RabbitClientInterface
 	timeout time.Duration
+	codec   communication.Codec
+	signer  *communication.Signer
+	wire    *communication.WireCounter
 }
 
-func New(rabbitUrl string, timeout time.Duration) *RepRabbitClient {
+// New connects to rabbitUrl, over TLS if tlsConfig isn't nil, and talks to
+// the reps in namespace.  With a signer (which may also be nil) the client
+// signs its requests and only accepts replies signed with the same key.
+func New(rabbitUrl string, tlsConfig *tls.Config, namespace communication.Namespace, timeout time.Duration, codec communication.Codec, signer *communication.Signer) (*RepRabbitClient, error) {
 	guid := util.RandomGuid()
-	client := rabbitclient.NewClient(guid, rabbitUrl)
+	client := rabbitclient.NewClient(guid, rabbitUrl, tlsConfig)
 	err := client.ConnectAndEstablish()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	return &RepRabbitClient{
+	return NewWithRabbitClient(rabbitclient.Namespaced(client, namespace), timeout, codec, signer), nil
+}
+
+// NewWithRabbitClient wraps a RabbitClientInterface that is already connected.
+func NewWithRabbitClient(client rabbitclient.RabbitClientInterface, timeout time.Duration, codec communication.Codec, signer *communication.Signer) *RepRabbitClient {
+	rep := &RepRabbitClient{
 		client:  client,
 		timeout: timeout,
+		codec:   codec,
+		signer:  signer,
+		wire:    communication.NewWireCounter(),
 	}
+	rep.RepClient = communication.NewRepClient(rep.request)
+
+	return rep
 }
 
 func (rep *RepRabbitClient) request(guid string, subject string, req interface{}, resp interface{}) (err error) {
 	payload := []byte{}
 	if req != nil {
-		payload, err = json.Marshal(req)
+		payload, err = rep.codec.Marshal(req)
 		if err != nil {
 			return err
 		}
 	}
 
-	response, err := rep.client.Request(guid, subject, payload, rep.timeout)
-
-	if err != nil {
-		return err
-	}
-
-	if string(response) == "error" {
-		return RequestFailedError
-	}
-
-	if resp != nil {
-		return json.Unmarshal(response, resp)
-	}
-
-	return nil
-}
-
-func (rep *RepRabbitClient) TotalResources(guid string) types.Resources {
-	var totalResources types.Resources
-	err := rep.request(guid, "total_resources", []byte{}, &totalResources)
-	if err != nil {
-		panic(err)
-	}
-	return totalResources
-}
-
-func (rep *RepRabbitClient) Instances(guid string) []types.Instance {
-	var instances []types.Instance
-	err := rep.request(guid, "instances", nil, &instances)
-	if err != nil {
-		panic(err)
-	}
-
-	return instances
-}
+	request := rep.signer.Sign(guid, subject, communication.Envelope{
+		Version:     communication.ProtocolVersion,
+		RequestID:   util.RandomGuid(),
+		Deadline:    time.Now().Add(rep.timeout),
+		ContentType: rep.codec.ContentType(),
+		Body:        payload,
+	})
+	rep.wire.CountSent(request.Size(), request)
 
-func (rep *RepRabbitClient) Reset(guid string) {
-	err := rep.request(guid, "reset", nil, nil)
+	reply, err := rep.client.Request(guid, subject, request, rep.timeout)
 	if err != nil {
-		panic(err)
+		return communication.TransportErrorFor(err)
 	}
-}
+	rep.wire.CountReceived(reply.Size(), reply)
 
-func (rep *RepRabbitClient) SetInstances(guid string, instances []types.Instance) {
-	err := rep.request(guid, "set_instances", instances, nil)
+	err = rep.signer.Verify(guid, subject, reply)
 	if err != nil {
-		panic(err)
-	}
-}
-
-func (rep *RepRabbitClient) batch(subject string, guids []string, instance types.Instance) types.ScoreResults {
-	c := make(chan types.ScoreResult)
-	for _, guid := range guids {
-		go func(guid string) {
-			var response types.ScoreResult
-			err := rep.request(guid, subject, instance, &response)
-			if err != nil {
-				c <- types.ScoreResult{
-					Error: err.Error(),
-				}
-			}
-			c <- response
-		}(guid)
-	}
-
-	scores := types.ScoreResults{}
-	for _ = range guids {
-		scores = append(scores, <-c)
-	}
-
-	return scores
-}
-
-func (rep *RepRabbitClient) Score(guids []string, instance types.Instance) types.ScoreResults {
-	return rep.batch("score", guids, instance)
-}
-
-func (rep *RepRabbitClient) ScoreThenTentativelyReserve(guids []string, instance types.Instance) types.ScoreResults {
-	return rep.batch("score_then_tentatively_reserve", guids, instance)
-}
-
-func (rep *RepRabbitClient) ReleaseReservation(guids []string, instance types.Instance) {
-	allReceived := new(sync.WaitGroup)
-	allReceived.Add(len(guids))
-	for _, guid := range guids {
-		go func(guid string) {
-			rep.request(guid, "release-reservation", instance, nil)
-			allReceived.Done()
-		}(guid)
+		return err
 	}
 
-	allReceived.Wait()
+	return reply.Decode(resp)
 }
 
-func (rep *RepRabbitClient) Claim(guid string, instance types.Instance) {
-	err := rep.request(guid, "claim", instance, nil)
-	if err != nil {
-		log.Println("failed to claim:", err)
-	}
+// WireBytes counts the requests sent and the replies received, each its body
+// and headers.
+func (rep *RepRabbitClient) WireBytes() communication.WireBytes {
+	return rep.wire.Bytes()
 }
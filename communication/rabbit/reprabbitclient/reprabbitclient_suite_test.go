@@ -0,0 +1,20 @@
+package reprabbitclient_test
+
+import (
+	"time"
+
+	"github.com/onsi/auction/communication/rabbit/rabbitclient"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func init() {
+	rabbitclient.ReconnectInterval = 10 * time.Millisecond
+}
+
+func TestRepRabbitClient(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "RepRabbitClient Suite")
+}
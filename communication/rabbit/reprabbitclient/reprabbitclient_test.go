@@ -0,0 +1,236 @@
+package reprabbitclient_test
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/onsi/auction/auctionrep"
+	"github.com/onsi/auction/communication"
+	"github.com/onsi/auction/communication/conformance"
+	"github.com/onsi/auction/communication/rabbit/fakerabbit"
+	"github.com/onsi/auction/communication/rabbit/rabbitclient"
+	. "github.com/onsi/auction/communication/rabbit/reprabbitclient"
+	"github.com/onsi/auction/communication/rabbit/reprabbitserver"
+	"github.com/onsi/auction/communication/testcerts"
+	"github.com/onsi/auction/simulation/simulationrepdelegate"
+	"github.com/onsi/auction/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RepRabbitClient", func() {
+	var broker *fakerabbit.Broker
+	var servers []rabbitclient.RabbitServerInterface
+	var rabbitClient rabbitclient.RabbitClientInterface
+	var client *RepRabbitClient
+	var instance types.Instance
+
+	BeforeEach(func() {
+		broker = fakerabbit.NewBroker()
+		servers = []rabbitclient.RabbitServerInterface{}
+		for _, guid := range []string{"rep-a", "rep-b"} {
+			server := broker.NewServer(guid)
+			Ω(server.ConnectAndEstablish()).ShouldNot(HaveOccurred())
+			reprabbitserver.Serve(server, auctionrep.New(guid, simulationrepdelegate.New(types.Resources{
+				MemoryMB:   100,
+				DiskMB:     100,
+				Containers: 10,
+			})), nil, nil, nil)
+			servers = append(servers, server)
+		}
+
+		rabbitClient = broker.NewClient("auctioneer")
+		Ω(rabbitClient.ConnectAndEstablish()).ShouldNot(HaveOccurred())
+
+		client = NewWithRabbitClient(rabbitClient, 100*time.Millisecond, communication.JSON, nil)
+
+		instance = types.Instance{
+			AppGuid:      "app-guid",
+			InstanceGuid: "instance-guid",
+			Resources:    types.Resources{MemoryMB: 1, DiskMB: 1},
+		}
+	})
+
+	AfterEach(func() {
+		rabbitClient.Disconnect()
+		for _, server := range servers {
+			server.Disconnect()
+		}
+	})
+
+	conformance.ItBehavesLikeATestRepPoolClient(func() conformance.Fixture {
+		return conformance.Fixture{
+			Client:       client,
+			Timeout:      100 * time.Millisecond,
+			RepResources: types.Resources{MemoryMB: 100, DiskMB: 100, Containers: 10},
+			Responsive:   []string{"rep-a", "rep-b"},
+			Unresponsive: []string{"rep-gone"},
+		}
+	})
+
+	Describe("when the broker duplicates replies", func() {
+		BeforeEach(func() {
+			broker.DuplicateReplies(1)
+		})
+
+		conformance.ItReturnsOneResultPerRep(func() conformance.Fixture {
+			return conformance.Fixture{
+				Client:       client,
+				Timeout:      100 * time.Millisecond,
+				RepResources: types.Resources{MemoryMB: 100, DiskMB: 100, Containers: 10},
+				Responsive:   []string{"rep-a", "rep-b"},
+				Unresponsive: []string{"rep-gone"},
+			}
+		})
+	})
+
+	It("should speak msgpack when asked to", func() {
+		client = NewWithRabbitClient(rabbitClient, 100*time.Millisecond, communication.MsgPack, nil)
+
+		results := client.ScoreThenTentativelyReserve([]string{"rep-a"}, instance)
+		Ω(results.FilterErrors()).Should(HaveLen(1))
+
+		client.Claim("rep-a", instance)
+		Ω(client.Instances("rep-a")).Should(Equal([]types.Instance{instance}))
+	})
+
+	Describe("when the broker drops its connections", func() {
+		var arrived, release chan struct{}
+
+		BeforeEach(func() {
+			arrived, release = make(chan struct{}, 1), make(chan struct{})
+			arrivals, releases := arrived, release
+
+			server := broker.NewServer("rep-stuck")
+			server.Handle(communication.ScoreSubject, func(request communication.Envelope) (communication.Envelope, bool) {
+				arrivals <- struct{}{}
+				<-releases
+				return request.Reply(nil, communication.RequestFailed), true
+			})
+			Ω(server.ConnectAndEstablish()).ShouldNot(HaveOccurred())
+			servers = append(servers, server)
+		})
+
+		AfterEach(func() {
+			close(release)
+		})
+
+		It("should fail requests that are waiting on a reply straight away", func() {
+			client = NewWithRabbitClient(rabbitClient, 10*time.Second, communication.JSON, nil)
+
+			results := make(chan types.ScoreResults, 1)
+			go func() {
+				results <- client.Score([]string{"rep-stuck"}, instance)
+			}()
+			Eventually(arrived).Should(Receive())
+
+			broker.DropConnections()
+
+			Eventually(results).Should(Receive(Equal(types.ScoreResults{
+				communication.ErrorResult("rep-stuck", communication.TransportError{Err: rabbitclient.DisconnectedError}),
+			})))
+		})
+
+		It("should reconnect", func() {
+			broker.DropConnections()
+
+			Eventually(func() types.ScoreError {
+				return client.Score([]string{"rep-a"}, instance)[0].Error
+			}).Should(BeEmpty())
+		})
+	})
+
+	It("should only talk to reps that share its key when it has one", func() {
+		signer := communication.NewSigner([]byte("shared-key"))
+		server := broker.NewServer("rep-signed")
+		Ω(server.ConnectAndEstablish()).ShouldNot(HaveOccurred())
+		reprabbitserver.Serve(server, auctionrep.New("rep-signed", simulationrepdelegate.New(types.Resources{
+			MemoryMB:   100,
+			DiskMB:     100,
+			Containers: 10,
+		})), signer, nil, nil)
+
+		Ω(client.Reset("rep-signed")).Should(Equal(communication.UnauthorizedError))
+
+		client = NewWithRabbitClient(rabbitClient, 100*time.Millisecond, communication.JSON, signer)
+		Ω(client.Reset("rep-signed")).ShouldNot(HaveOccurred())
+		Ω(client.Reset("rep-a")).Should(Equal(communication.UnsignedError))
+	})
+
+	It("should only reach reps in its namespace", func() {
+		namespace := communication.Namespace("cell-a")
+		server := broker.NewServer(namespace.Qualify("rep-a"))
+		Ω(server.ConnectAndEstablish()).ShouldNot(HaveOccurred())
+		reprabbitserver.Serve(server, auctionrep.New("rep-a", simulationrepdelegate.New(types.Resources{
+			MemoryMB:   100,
+			DiskMB:     100,
+			Containers: 20,
+		})), nil, nil, nil)
+
+		namespacedClient := NewWithRabbitClient(rabbitclient.Namespaced(rabbitClient, namespace), 100*time.Millisecond, communication.JSON, nil)
+		resources, err := namespacedClient.TotalResources("rep-a")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(resources.Containers).Should(Equal(20))
+
+		_, err = namespacedClient.TotalResources("rep-b")
+		Ω(err).Should(HaveOccurred())
+
+		resources, err = client.TotalResources("rep-a")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(resources.Containers).Should(Equal(10))
+	})
+
+	Describe("connecting over TLS", func() {
+		var certs *testcerts.Certs
+		var listener net.Listener
+		var handshakes chan error
+
+		BeforeEach(func() {
+			var err error
+			certs, err = testcerts.Generate("127.0.0.1")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			listener, err = tls.Listen("tcp", "127.0.0.1:0", certs.ServerConfig())
+			Ω(err).ShouldNot(HaveOccurred())
+
+			handshakes = make(chan error, 1)
+			go func() {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				handshakes <- conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}()
+		})
+
+		AfterEach(func() {
+			listener.Close()
+		})
+
+		It("should trust brokers whose certificates chain to its certificate authority", func() {
+			_, err := New("amqps://"+listener.Addr().String(), certs.ClientConfig(), "", 100*time.Millisecond, communication.JSON, nil)
+			Ω(err).Should(HaveOccurred())
+			Ω(<-handshakes).ShouldNot(HaveOccurred())
+		})
+
+		It("should refuse brokers whose certificates don't", func() {
+			otherCerts, err := testcerts.Generate("127.0.0.1")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			_, err = New("amqps://"+listener.Addr().String(), otherCerts.ClientConfig(), "", 100*time.Millisecond, communication.JSON, nil)
+			Ω(err).Should(HaveOccurred())
+			Ω(err.Error()).Should(ContainSubstring("certificate"))
+		})
+	})
+
+	It("should report transport errors when it can't reach the broker", func() {
+		client = NewWithRabbitClient(broker.NewClient("disconnected"), 100*time.Millisecond, communication.JSON, nil)
+
+		results := client.Score([]string{"rep-a"}, instance)
+		Ω(results).Should(Equal(types.ScoreResults{
+			communication.ErrorResult("rep-a", communication.TransportError{Err: fakerabbit.NotConnectedError}),
+		}))
+	})
+})
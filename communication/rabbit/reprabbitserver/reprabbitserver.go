@@ -1,125 +1,97 @@
 package reprabbitserver
 
 import (
-	"encoding/json"
+	"crypto/tls"
 	"fmt"
+	"sync"
 
 	"github.com/onsi/auction/auctionrep"
+	"github.com/onsi/auction/communication"
 	"github.com/onsi/auction/communication/rabbit/rabbitclient"
-	"github.com/onsi/auction/types"
 )
 
-var errorResponse = []byte("error")
-var successResponse = []byte("ok")
+type Server struct {
+	server        rabbitclient.RabbitServerInterface
+	dispatcher    *communication.RepDispatcher
+	inFlight      *communication.InFlight
+	onStateChange communication.StateCallback
+	stopOnce      *sync.Once
+	stopped       chan struct{}
+}
 
-func Start(rabbitUrl string, rep *auctionrep.AuctionRep) {
-	println("RABBIT", rabbitUrl)
-	server := rabbitclient.NewServer(rep.Guid(), rabbitUrl)
-	err := server.ConnectAndEstablish()
-	if err != nil {
-		panic(err)
+// Start connects to rabbit and serves rep until Stop is called, reconnecting
+// when the connection drops.  onStateChange (which may be nil) hears about
+// every change in the connection.  tlsConfig is used for amqps urls; with a
+// signer, the server only answers requests signed with its key, and with an
+// admission it turns away bids beyond its limits.  Any of them may be nil.
+// The rep's queue is in namespace, where only clients in the same
+// namespace will find it.
+func Start(rabbitUrl string, tlsConfig *tls.Config, namespace communication.Namespace, rep *auctionrep.AuctionRep, signer *communication.Signer, admission *communication.Admission, onStateChange communication.StateCallback) (*Server, error) {
+	server := rabbitclient.NewServer(namespace.Qualify(rep.Guid()), rabbitUrl, tlsConfig)
+	if onStateChange != nil {
+		server.OnStateChange(onStateChange)
 	}
 
-	server.Handle("total_resources", func(_ []byte) []byte {
-		out, _ := json.Marshal(rep.TotalResources())
-		return out
-	})
-
-	server.Handle("reset", func(_ []byte) []byte {
-		rep.Reset()
-		return successResponse
-	})
-
-	server.Handle("set_instances", func(req []byte) []byte {
-		var instances []types.Instance
-
-		err := json.Unmarshal(req, &instances)
-		if err != nil {
-			return errorResponse
-		}
-
-		rep.SetInstances(instances)
-		return successResponse
-	})
-
-	server.Handle("instances", func(_ []byte) []byte {
-		out, _ := json.Marshal(rep.Instances())
-		return out
-	})
-
-	server.Handle("score", func(req []byte) []byte {
-		var inst types.Instance
-
-		err := json.Unmarshal(req, &inst)
-		if err != nil {
-			return errorResponse
-		}
-
-		response := types.ScoreResult{
-			Rep: rep.Guid(),
-		}
-
-		score, err := rep.Score(inst)
-		if err != nil {
-			response.Error = err.Error()
-		} else {
-			response.Score = score
-		}
-
-		out, _ := json.Marshal(response)
-		return out
-	})
-
-	server.Handle("score_then_tentatively_reserve", func(req []byte) []byte {
-		var inst types.Instance
-
-		err := json.Unmarshal(req, &inst)
-		if err != nil {
-			return errorResponse
-		}
+	handle := Serve(server, rep, signer, admission, onStateChange)
 
-		response := types.ScoreResult{
-			Rep: rep.Guid(),
-		}
+	err := server.ConnectAndEstablish()
+	if err != nil {
+		return nil, err
+	}
 
-		score, err := rep.ScoreThenTentativelyReserve(inst)
-		if err != nil {
-			response.Error = err.Error()
-		} else {
-			response.Score = score
-		}
+	fmt.Printf("[%s] listening for rabbit\n", rep.Guid())
 
-		out, _ := json.Marshal(response)
-		return out
-	})
+	return handle, nil
+}
 
-	server.Handle("release-reservation", func(req []byte) []byte {
-		var instance types.Instance
+// Serve registers rep's handlers on server; they take effect once the server
+// is established.
+func Serve(server rabbitclient.RabbitServerInterface, rep *auctionrep.AuctionRep, signer *communication.Signer, admission *communication.Admission, onStateChange communication.StateCallback) *Server {
+	handle := &Server{
+		server:        server,
+		dispatcher:    communication.NewRepDispatcher(rep, signer, admission),
+		inFlight:      communication.NewInFlight(),
+		onStateChange: onStateChange,
+		stopOnce:      &sync.Once{},
+		stopped:       make(chan struct{}),
+	}
 
-		err := json.Unmarshal(req, &instance)
-		if err != nil {
-			return errorResponse
-		}
+	for _, subject := range handle.dispatcher.Subjects() {
+		subject := subject
+		server.Handle(subject, func(request communication.Envelope) (communication.Envelope, bool) {
+			if !handle.inFlight.Begin() {
+				return handle.dispatcher.Refuse(subject, request), true
+			}
+			defer handle.inFlight.End()
 
-		rep.ReleaseReservation(instance)
+			return handle.dispatcher.DispatchEnvelope(subject, request)
+		})
+	}
 
-		return successResponse
-	})
+	return handle
+}
 
-	server.Handle("claim", func(req []byte) []byte {
-		var instance types.Instance
+// Stop lets requests that are already being handled reply and then
+// disconnects; anything that arrives in the meantime is refused.
+func (handle *Server) Stop() {
+	handle.stopOnce.Do(func() {
+		handle.inFlight.Drain()
+		handle.server.Disconnect()
 
-		err := json.Unmarshal(req, &instance)
-		if err != nil {
-			return errorResponse
+		if handle.onStateChange != nil {
+			handle.onStateChange(communication.Stopped)
 		}
 
-		rep.Claim(instance)
-
-		return successResponse
+		close(handle.stopped)
 	})
+}
 
-	fmt.Printf("[%s] listening for rabbit\n", rep.Guid())
+// Expired is the number of requests dropped because their deadline had passed.
+func (handle *Server) Expired() uint64 {
+	return handle.dispatcher.Expired()
+}
 
-	select {}
+// Wait blocks until the server has stopped.
+func (handle *Server) Wait() {
+	<-handle.stopped
 }
@@ -0,0 +1,20 @@
+package reprabbitserver_test
+
+import (
+	"time"
+
+	"github.com/onsi/auction/communication/rabbit/rabbitclient"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func init() {
+	rabbitclient.ReconnectInterval = 10 * time.Millisecond
+}
+
+func TestRepRabbitServer(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "RepRabbitServer Suite")
+}
@@ -0,0 +1,222 @@
+package reprabbitserver_test
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/onsi/auction/auctionrep"
+	"github.com/onsi/auction/auctionrep/fakedelegate"
+	"github.com/onsi/auction/communication"
+	"github.com/onsi/auction/communication/rabbit/fakerabbit"
+	"github.com/onsi/auction/communication/rabbit/rabbitclient"
+	"github.com/onsi/auction/communication/rabbit/reprabbitclient"
+	. "github.com/onsi/auction/communication/rabbit/reprabbitserver"
+	"github.com/onsi/auction/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// handlerServer just remembers the handlers it is given
+type handlerServer struct {
+	rabbitclient.RabbitServerInterface
+	handlers map[string]rabbitclient.Callback
+}
+
+func (s *handlerServer) Handle(subject string, callback rabbitclient.Callback) {
+	s.handlers[subject] = callback
+}
+
+func (s *handlerServer) Disconnect() error { return nil }
+
+var _ = Describe("RepRabbitServer", func() {
+	var broker *fakerabbit.Broker
+	var rabbitClient rabbitclient.RabbitClientInterface
+	var client *reprabbitclient.RepRabbitClient
+	var release chan struct{}
+	var arrived int32
+	var server *Server
+	var states []communication.ConnectionState
+	var statesLock *sync.Mutex
+	var instance types.Instance
+
+	BeforeEach(func() {
+		broker = fakerabbit.NewBroker()
+		release = make(chan struct{})
+		arrived = 0
+		states = []communication.ConnectionState{}
+		statesLock = &sync.Mutex{}
+
+		rabbitServer := broker.NewServer("rep")
+		onStateChange := func(state communication.ConnectionState) {
+			statesLock.Lock()
+			states = append(states, state)
+			statesLock.Unlock()
+		}
+		rabbitServer.OnStateChange(onStateChange)
+		server = Serve(rabbitServer, auctionrep.New("rep", fakedelegate.BlockingDelegate{Arrived: &arrived, Release: release}), nil, nil, onStateChange)
+		Ω(rabbitServer.ConnectAndEstablish()).ShouldNot(HaveOccurred())
+
+		rabbitClient = broker.NewClient("auctioneer")
+		Ω(rabbitClient.ConnectAndEstablish()).ShouldNot(HaveOccurred())
+		client = reprabbitclient.NewWithRabbitClient(rabbitClient, 100*time.Millisecond, communication.JSON, nil)
+
+		instance = types.Instance{
+			AppGuid:      "app-guid",
+			InstanceGuid: "instance-guid",
+			Resources:    types.Resources{MemoryMB: 1, DiskMB: 1},
+		}
+	})
+
+	AfterEach(func() {
+		server.Stop()
+		rabbitClient.Disconnect()
+	})
+
+	reportedStates := func() []communication.ConnectionState {
+		statesLock.Lock()
+		defer statesLock.Unlock()
+		return append([]communication.ConnectionState{}, states...)
+	}
+
+	score := func() types.ScoreResult {
+		results := client.Score([]string{"rep"}, instance)
+		Ω(results).Should(HaveLen(1))
+		return results[0]
+	}
+
+	It("should report that it connected", func() {
+		Ω(reportedStates()).Should(Equal([]communication.ConnectionState{communication.Connected}))
+	})
+
+	It("should reconnect and keep serving when the broker drops its connections", func() {
+		close(release)
+		Ω(score().Error).Should(BeEmpty())
+
+		broker.DropConnections()
+
+		Eventually(reportedStates).Should(Equal([]communication.ConnectionState{
+			communication.Connected,
+			communication.Disconnected,
+			communication.Connected,
+		}))
+		Eventually(func() types.ScoreError { return score().Error }).Should(BeEmpty())
+	})
+
+	It("should drop expired requests and count them", func() {
+		close(release)
+
+		stub := &handlerServer{handlers: map[string]rabbitclient.Callback{}}
+		server := Serve(stub, auctionrep.New("rep", fakedelegate.BlockingDelegate{Arrived: &arrived, Release: release}), nil, nil, nil)
+
+		payload, _ := json.Marshal(instance)
+		score := stub.handlers[communication.ScoreSubject]
+
+		request := func(deadline time.Time) communication.Envelope {
+			return communication.Envelope{Deadline: deadline, ContentType: communication.JSON.ContentType(), Body: payload}
+		}
+
+		_, ok := score(request(time.Now().Add(-time.Second)))
+		Ω(ok).Should(BeFalse())
+		_, ok = score(request(time.Now().Add(time.Second)))
+		Ω(ok).Should(BeTrue())
+		Ω(server.Expired()).Should(Equal(uint64(1)))
+	})
+
+	It("should answer bids beyond its admission that it is busy", func() {
+		admission, err := communication.NewAdmission(1, 0)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		rabbitServer := broker.NewServer("busy-rep")
+		busyServer := Serve(rabbitServer, auctionrep.New("busy-rep", fakedelegate.BlockingDelegate{Arrived: &arrived, Release: release}), nil, admission, nil)
+		Ω(rabbitServer.ConnectAndEstablish()).ShouldNot(HaveOccurred())
+
+		go client.Score([]string{"busy-rep"}, instance)
+		Eventually(func() int32 { return atomic.LoadInt32(&arrived) }).Should(Equal(int32(1)))
+
+		results := client.Score([]string{"busy-rep"}, instance)
+		Ω(results).Should(HaveLen(1))
+		Ω(results[0].Rep).Should(Equal("busy-rep"))
+		Ω(results[0].Error).Should(Equal(types.RepBusy))
+		Ω(admission.Busy()).Should(Equal(uint64(1)))
+
+		close(release)
+		busyServer.Stop()
+	})
+
+	Describe("stopping", func() {
+		It("should let in-flight requests reply before it returns", func() {
+			client = reprabbitclient.NewWithRabbitClient(rabbitClient, time.Second, communication.JSON, nil)
+
+			scored := make(chan types.ScoreResult, 1)
+			go func() {
+				scored <- score()
+			}()
+			Eventually(func() int32 { return atomic.LoadInt32(&arrived) }).Should(Equal(int32(1)))
+
+			stopped := make(chan struct{})
+			go func() {
+				server.Stop()
+				close(stopped)
+			}()
+
+			Consistently(stopped).ShouldNot(BeClosed())
+
+			close(release)
+			Eventually(stopped).Should(BeClosed())
+
+			var result types.ScoreResult
+			Eventually(scored).Should(Receive(&result))
+			Ω(result.Error).Should(BeEmpty())
+		})
+
+		It("should tell bids that arrive while it drains that the rep is draining", func() {
+			close(release)
+
+			stub := &handlerServer{handlers: map[string]rabbitclient.Callback{}}
+			server := Serve(stub, auctionrep.New("rep", fakedelegate.BlockingDelegate{Arrived: &arrived, Release: release}), nil, nil, nil)
+			server.Stop()
+
+			payload, _ := json.Marshal(instance)
+			reply, ok := stub.handlers[communication.ScoreSubject](communication.Envelope{
+				Version:     communication.ProtocolVersion,
+				ContentType: communication.JSON.ContentType(),
+				Body:        payload,
+			})
+			Ω(ok).Should(BeTrue())
+
+			var result types.ScoreResult
+			Ω(reply.Decode(&result)).ShouldNot(HaveOccurred())
+			Ω(result.Rep).Should(Equal("rep"))
+			Ω(result.Error).Should(Equal(types.Draining))
+
+			reply, _ = stub.handlers[communication.ClaimSubject](communication.Envelope{Version: communication.ProtocolVersion, Body: payload})
+			Ω(reply.Error).Should(Equal(communication.RequestFailed))
+		})
+
+		It("should stop answering once it has stopped", func() {
+			close(release)
+			server.Stop()
+
+			Ω(score().Error).Should(Equal(types.Timeout))
+		})
+
+		It("should report that it stopped and let Wait return", func() {
+			close(release)
+
+			waited := make(chan struct{})
+			go func() {
+				server.Wait()
+				close(waited)
+			}()
+			Consistently(waited).ShouldNot(BeClosed())
+
+			server.Stop()
+			server.Stop()
+
+			Eventually(waited).Should(BeClosed())
+			Ω(reportedStates()).Should(Equal([]communication.ConnectionState{communication.Connected, communication.Stopped}))
+		})
+	})
+})
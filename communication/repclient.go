@@ -0,0 +1,120 @@
+package communication
+
+import (
+	"log"
+	"sync"
+
+	"github.com/onsi/auction/types"
+)
+
+// Requester sends req (which may be nil) to the rep guid on subject and
+// decodes the rep's reply into resp (ditto).  It is all a transport has to
+// supply for a RepClient to make a types.TestRepPoolClient of it.
+type Requester func(guid string, subject string, req interface{}, resp interface{}) error
+
+// RepClient implements types.TestRepPoolClient with a transport's Requester.
+// Batched requests go out to every rep at once and return exactly one result
+// per rep, in the order the reps were given.
+type RepClient struct {
+	request Requester
+}
+
+func NewRepClient(request Requester) RepClient {
+	return RepClient{request: request}
+}
+
+func (c RepClient) TotalResources(guid string) (types.Resources, error) {
+	var totalResources types.Resources
+	err := c.request(guid, TotalResourcesSubject, nil, &totalResources)
+	return totalResources, err
+}
+
+func (c RepClient) Instances(guid string) ([]types.Instance, error) {
+	var instances []types.Instance
+	err := c.request(guid, InstancesSubject, nil, &instances)
+	return instances, err
+}
+
+func (c RepClient) Reset(guid string) error {
+	return c.request(guid, ResetSubject, nil, nil)
+}
+
+func (c RepClient) SetInstances(guid string, instances []types.Instance) error {
+	return c.request(guid, SetInstancesSubject, instances, nil)
+}
+
+func (c RepClient) batch(subject string, guids []string, reqs []interface{}) types.ScoreResults {
+	results := make(chan indexedResult, len(guids))
+	for i, guid := range guids {
+		go func(i int, guid string, req interface{}) {
+			var response types.ScoreResult
+			err := c.request(guid, subject, req, &response)
+			if err != nil {
+				response = ErrorResult(guid, err)
+			}
+			response.Rep = guid
+			results <- indexedResult{i, response}
+		}(i, guid, reqs[i])
+	}
+
+	scores := make(types.ScoreResults, len(guids))
+	for range guids {
+		result := <-results
+		scores[result.index] = result.result
+	}
+
+	return scores
+}
+
+func (c RepClient) Score(guids []string, instance types.Instance) types.ScoreResults {
+	return c.batch(ScoreSubject, guids, repeatRequest(instance, len(guids)))
+}
+
+func (c RepClient) ScoreThenTentativelyReserve(guids []string, instance types.Instance) types.ScoreResults {
+	return c.batch(ScoreThenTentativelyReserveSubject, guids, repeatRequest(instance, len(guids)))
+}
+
+func (c RepClient) TentativelyReserveIfUnchanged(scores types.ScoreResults, instance types.Instance) types.ScoreResults {
+	reqs := []interface{}{}
+	for _, score := range scores {
+		reqs = append(reqs, types.ReserveIfUnchangedRequest{
+			Instance:   instance,
+			Generation: score.Generation,
+		})
+	}
+
+	return c.batch(TentativelyReserveIfUnchangedSubject, scores.Reps(), reqs)
+}
+
+func (c RepClient) ReleaseReservation(guids []string, instance types.Instance) {
+	allReceived := new(sync.WaitGroup)
+	allReceived.Add(len(guids))
+	for _, guid := range guids {
+		go func(guid string) {
+			c.request(guid, ReleaseReservationSubject, instance, nil)
+			allReceived.Done()
+		}(guid)
+	}
+
+	allReceived.Wait()
+}
+
+func (c RepClient) Claim(guid string, instance types.Instance) {
+	err := c.request(guid, ClaimSubject, instance, nil)
+	if err != nil {
+		log.Println("failed to claim:", err)
+	}
+}
+
+type indexedResult struct {
+	index  int
+	result types.ScoreResult
+}
+
+func repeatRequest(req interface{}, n int) []interface{} {
+	reqs := make([]interface{}, n)
+	for i := range reqs {
+		reqs[i] = req
+	}
+	return reqs
+}
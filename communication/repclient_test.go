@@ -0,0 +1,92 @@
+package communication_test
+
+import (
+	"errors"
+	"sync"
+
+	. "github.com/onsi/auction/communication"
+	"github.com/onsi/auction/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RepClient", func() {
+	var client RepClient
+	var failures map[string]error
+	var requests []string
+	var lock *sync.Mutex
+	var instance types.Instance
+
+	BeforeEach(func() {
+		failures = map[string]error{}
+		requests = []string{}
+		lock = &sync.Mutex{}
+		instance = types.Instance{InstanceGuid: "instance-guid"}
+
+		client = NewRepClient(func(guid string, subject string, req interface{}, resp interface{}) error {
+			lock.Lock()
+			requests = append(requests, guid+"."+subject)
+			lock.Unlock()
+
+			if err, failed := failures[guid]; failed {
+				return err
+			}
+
+			if result, ok := resp.(*types.ScoreResult); ok {
+				result.Score = 0.5
+				if reserve, ok := req.(types.ReserveIfUnchangedRequest); ok {
+					result.Generation = reserve.Generation
+				}
+			}
+			return nil
+		})
+	})
+
+	It("should return one result per rep, in order, whether or not it answered", func() {
+		failures["rep-b"] = TransportError{Err: errors.New("boom")}
+		failures["rep-c"] = TimeoutError
+
+		results := client.Score([]string{"rep-a", "rep-b", "rep-c"}, instance)
+		Ω(results.Reps()).Should(Equal(types.RepGuids{"rep-a", "rep-b", "rep-c"}))
+		Ω(results[0].Error).Should(BeEmpty())
+		Ω(results[0].Score).Should(Equal(0.5))
+		Ω(results[1].Error).Should(Equal(types.TransportFailure))
+		Ω(results[1].Message).Should(Equal("boom"))
+		Ω(results[2].Error).Should(Equal(types.Timeout))
+	})
+
+	It("should reserve with each rep's generation", func() {
+		results := client.TentativelyReserveIfUnchanged(types.ScoreResults{
+			{Rep: "rep-a", Generation: 1},
+			{Rep: "rep-b", Generation: 2},
+		}, instance)
+
+		Ω(results[0].Generation).Should(Equal(uint64(1)))
+		Ω(results[1].Generation).Should(Equal(uint64(2)))
+		Ω(requests).Should(HaveLen(2))
+		Ω(requests).Should(ContainElement("rep-a." + TentativelyReserveIfUnchangedSubject))
+		Ω(requests).Should(ContainElement("rep-b." + TentativelyReserveIfUnchangedSubject))
+	})
+
+	It("should release every reservation before returning", func() {
+		client.ReleaseReservation([]string{"rep-a", "rep-b"}, instance)
+		Ω(requests).Should(HaveLen(2))
+	})
+})
+
+var _ = Describe("TransportErrorFor", func() {
+	It("should report timeouts as timeouts", func() {
+		Ω(TransportErrorFor(TimeoutError)).Should(Equal(TimeoutError))
+		Ω(TransportErrorFor(timeout{})).Should(Equal(TimeoutError))
+	})
+
+	It("should report anything else as a transport error", func() {
+		err := errors.New("connection refused")
+		Ω(TransportErrorFor(err)).Should(Equal(TransportError{Err: err}))
+	})
+})
+
+type timeout struct{}
+
+func (timeout) Error() string { return "i/o timeout" }
+func (timeout) Timeout() bool { return true }
@@ -0,0 +1,233 @@
+// Package routing lets a single auction span reps on different transports: a
+// Client looks up the transport client for each rep and splits every batched
+// request between them.  It is meant for migrating reps from one transport to
+// another, a rep at a time.
+package routing
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/onsi/auction/communication"
+	"github.com/onsi/auction/types"
+)
+
+var UnroutableError = errors.New("no route to rep")
+
+// Client sends each rep's requests to the client its route names, or to the
+// fallback if it has no route.  Batched requests are sent to each transport
+// concurrently, and the results come back in the order the reps were given.
+//
+// Client does not broadcast, since no single transport reaches every rep.  It
+// is a FilteringRepPoolClient, and defers to any transport client that is one
+// to decide which of its reps are Available.
+type Client struct {
+	routes   map[string]types.TestRepPoolClient
+	fallback types.TestRepPoolClient
+	lock     *sync.RWMutex
+}
+
+// New routes the reps in routes to their clients and every other rep to
+// fallback.  Without a fallback (nil), requests to other reps fail with
+// UnroutableError.
+func New(routes map[string]types.TestRepPoolClient, fallback types.TestRepPoolClient) *Client {
+	c := &Client{
+		routes:   map[string]types.TestRepPoolClient{},
+		fallback: fallback,
+		lock:     &sync.RWMutex{},
+	}
+	for guid, client := range routes {
+		c.routes[guid] = client
+	}
+	return c
+}
+
+// Route sends the rep's requests to client from now on; requests already
+// under way finish on the old route.
+func (c *Client) Route(guid string, client types.TestRepPoolClient) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.routes[guid] = client
+}
+
+// Unroute sends the rep's requests to the fallback from now on.
+func (c *Client) Unroute(guid string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.routes, guid)
+}
+
+func (c *Client) lookup(guid string) types.TestRepPoolClient {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	client, ok := c.routes[guid]
+	if ok {
+		return client
+	}
+	return c.fallback
+}
+
+// A group is the reps, of those in a request, that one client reaches.
+type group struct {
+	client  types.TestRepPoolClient
+	indices []int
+}
+
+// split groups the reps by client, in the order each client first appears.
+// Unroutable reps are grouped under a nil client.
+func (c *Client) split(guids []string) []*group {
+	groups := []*group{}
+	byClient := map[types.TestRepPoolClient]*group{}
+	for i, guid := range guids {
+		client := c.lookup(guid)
+		g, ok := byClient[client]
+		if !ok {
+			g = &group{client: client}
+			byClient[client] = g
+			groups = append(groups, g)
+		}
+		g.indices = append(g.indices, i)
+	}
+	return groups
+}
+
+// scatter sends each group its share of the request concurrently and merges
+// the results back into the order of guids.
+func (c *Client) scatter(guids []string, request func(client types.TestRepPoolClient, indices []int) types.ScoreResults) types.ScoreResults {
+	groups := c.split(guids)
+	replies := make([]types.ScoreResults, len(groups))
+
+	wg := &sync.WaitGroup{}
+	for i, g := range groups {
+		if g.client == nil {
+			for _, index := range g.indices {
+				replies[i] = append(replies[i], communication.ErrorResult(guids[index], UnroutableError))
+			}
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, g *group) {
+			defer wg.Done()
+			replies[i] = request(g.client, g.indices)
+		}(i, g)
+	}
+	wg.Wait()
+
+	byRep := map[string]types.ScoreResults{}
+	for _, reply := range replies {
+		for _, result := range reply {
+			byRep[result.Rep] = append(byRep[result.Rep], result)
+		}
+	}
+
+	results := types.ScoreResults{}
+	for _, guid := range guids {
+		results = append(results, byRep[guid]...)
+		delete(byRep, guid)
+	}
+	return results
+}
+
+func pick(guids []string, indices []int) []string {
+	picked := make([]string, len(indices))
+	for i, index := range indices {
+		picked[i] = guids[index]
+	}
+	return picked
+}
+
+func (c *Client) Score(guids []string, instance types.Instance) types.ScoreResults {
+	return c.scatter(guids, func(client types.TestRepPoolClient, indices []int) types.ScoreResults {
+		return client.Score(pick(guids, indices), instance)
+	})
+}
+
+func (c *Client) ScoreThenTentativelyReserve(guids []string, instance types.Instance) types.ScoreResults {
+	return c.scatter(guids, func(client types.TestRepPoolClient, indices []int) types.ScoreResults {
+		return client.ScoreThenTentativelyReserve(pick(guids, indices), instance)
+	})
+}
+
+func (c *Client) TentativelyReserveIfUnchanged(scores types.ScoreResults, instance types.Instance) types.ScoreResults {
+	return c.scatter(scores.Reps(), func(client types.TestRepPoolClient, indices []int) types.ScoreResults {
+		picked := make(types.ScoreResults, len(indices))
+		for i, index := range indices {
+			picked[i] = scores[index]
+		}
+		return client.TentativelyReserveIfUnchanged(picked, instance)
+	})
+}
+
+func (c *Client) ReleaseReservation(guids []string, instance types.Instance) {
+	c.scatter(guids, func(client types.TestRepPoolClient, indices []int) types.ScoreResults {
+		client.ReleaseReservation(pick(guids, indices), instance)
+		return nil
+	})
+}
+
+func (c *Client) Claim(guid string, instance types.Instance) {
+	client := c.lookup(guid)
+	if client != nil {
+		client.Claim(guid, instance)
+	}
+}
+
+// Available keeps the reps that have a route, less those their transport
+// client filters out.
+func (c *Client) Available(guids types.RepGuids) types.RepGuids {
+	available := map[string]bool{}
+	for _, g := range c.split(guids) {
+		reps := types.RepGuids(pick(guids, g.indices))
+		if g.client == nil {
+			continue
+		}
+		if filtering, ok := g.client.(types.FilteringRepPoolClient); ok {
+			reps = filtering.Available(reps)
+		}
+		for _, guid := range reps {
+			available[guid] = true
+		}
+	}
+
+	filtered := types.RepGuids{}
+	for _, guid := range guids {
+		if available[guid] {
+			filtered = append(filtered, guid)
+		}
+	}
+	return filtered
+}
+
+func (c *Client) TotalResources(guid string) (types.Resources, error) {
+	client := c.lookup(guid)
+	if client == nil {
+		return types.Resources{}, UnroutableError
+	}
+	return client.TotalResources(guid)
+}
+
+func (c *Client) Instances(guid string) ([]types.Instance, error) {
+	client := c.lookup(guid)
+	if client == nil {
+		return nil, UnroutableError
+	}
+	return client.Instances(guid)
+}
+
+func (c *Client) SetInstances(guid string, instances []types.Instance) error {
+	client := c.lookup(guid)
+	if client == nil {
+		return UnroutableError
+	}
+	return client.SetInstances(guid, instances)
+}
+
+func (c *Client) Reset(guid string) error {
+	client := c.lookup(guid)
+	if client == nil {
+		return UnroutableError
+	}
+	return client.Reset(guid)
+}
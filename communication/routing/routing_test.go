@@ -0,0 +1,178 @@
+package routing_test
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/onsi/auction/auctioneer"
+	"github.com/onsi/auction/auctionrep"
+	"github.com/onsi/auction/communication"
+	"github.com/onsi/auction/communication/conformance"
+	"github.com/onsi/auction/communication/nats/fakenats"
+	"github.com/onsi/auction/communication/nats/repnatsclient"
+	"github.com/onsi/auction/communication/nats/repnatsserver"
+	"github.com/onsi/auction/communication/rabbit/fakerabbit"
+	"github.com/onsi/auction/communication/rabbit/rabbitclient"
+	"github.com/onsi/auction/communication/rabbit/reprabbitclient"
+	"github.com/onsi/auction/communication/rabbit/reprabbitserver"
+	. "github.com/onsi/auction/communication/routing"
+	"github.com/onsi/auction/simulation/simulationrepdelegate"
+	"github.com/onsi/auction/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// filteringClient makes every rep but the unavailable ones Available
+type filteringClient struct {
+	types.TestRepPoolClient
+	unavailable string
+}
+
+func (c filteringClient) Available(guids types.RepGuids) types.RepGuids {
+	available := types.RepGuids{}
+	for _, guid := range guids {
+		if guid != c.unavailable {
+			available = append(available, guid)
+		}
+	}
+	return available
+}
+
+var _ = Describe("Routing", func() {
+	var natsClient *repnatsclient.RepNatsClient
+	var rabbitClient *reprabbitclient.RepRabbitClient
+	var rabbitConnections []rabbitclient.RabbitServerInterface
+	var auctioneerConnection rabbitclient.RabbitClientInterface
+	var client *Client
+	var instance types.Instance
+	timeout := 100 * time.Millisecond
+
+	newRep := func(guid string) *auctionrep.AuctionRep {
+		return auctionrep.New(guid, simulationrepdelegate.New(types.Resources{MemoryMB: 100, DiskMB: 100, Containers: 10}))
+	}
+
+	BeforeEach(func() {
+		bus := fakenats.NewBus()
+		for _, guid := range []string{"nats-a", "nats-b"} {
+			_, err := repnatsserver.Serve(bus.NewClient(), newRep(guid), nil, nil, nil)
+			Ω(err).ShouldNot(HaveOccurred())
+		}
+		natsClient = repnatsclient.New(bus.NewClient(), timeout, communication.JSON, nil)
+
+		broker := fakerabbit.NewBroker()
+		rabbitConnections = []rabbitclient.RabbitServerInterface{}
+		for _, guid := range []string{"rabbit-a", "rabbit-b"} {
+			server := broker.NewServer(guid)
+			Ω(server.ConnectAndEstablish()).ShouldNot(HaveOccurred())
+			reprabbitserver.Serve(server, newRep(guid), nil, nil, nil)
+			rabbitConnections = append(rabbitConnections, server)
+		}
+		auctioneerConnection = broker.NewClient("auctioneer")
+		Ω(auctioneerConnection.ConnectAndEstablish()).ShouldNot(HaveOccurred())
+		rabbitClient = reprabbitclient.NewWithRabbitClient(auctioneerConnection, timeout, communication.JSON, nil)
+
+		client = New(map[string]types.TestRepPoolClient{
+			"rabbit-a": rabbitClient,
+			"rabbit-b": rabbitClient,
+		}, natsClient)
+
+		instance = types.Instance{
+			AppGuid:      "app-guid",
+			InstanceGuid: "instance-guid",
+			Resources:    types.Resources{MemoryMB: 1, DiskMB: 1},
+		}
+	})
+
+	AfterEach(func() {
+		auctioneerConnection.Disconnect()
+		for _, server := range rabbitConnections {
+			server.Disconnect()
+		}
+	})
+
+	conformance.ItBehavesLikeATestRepPoolClient(func() conformance.Fixture {
+		return conformance.Fixture{
+			Client:       client,
+			Timeout:      timeout,
+			RepResources: types.Resources{MemoryMB: 100, DiskMB: 100, Containers: 10},
+			Responsive:   []string{"nats-a", "rabbit-a", "nats-b", "rabbit-b"},
+			Unresponsive: []string{"rep-gone"},
+		}
+	})
+
+	It("should merge the results from each transport in the order the reps were given", func() {
+		guids := []string{"rabbit-b", "nats-a", "rep-gone", "rabbit-a", "nats-b"}
+
+		results := client.Score(guids, instance)
+		Ω(results.Reps()).Should(Equal(types.RepGuids(guids)))
+		Ω(results.FilterErrors()).Should(HaveLen(4))
+
+		results = client.TentativelyReserveIfUnchanged(results, instance)
+		Ω(results.Reps()).Should(Equal(types.RepGuids(guids)))
+		Ω(results.FilterErrors()).Should(HaveLen(4))
+	})
+
+	It("should reach each rep over its own transport", func() {
+		Ω(natsClient.Score([]string{"rabbit-a"}, instance)[0].Error).Should(Equal(types.Timeout))
+		Ω(rabbitClient.Score([]string{"nats-a"}, instance)[0].Error).ShouldNot(BeEmpty())
+
+		Ω(client.Score([]string{"rabbit-a", "nats-a"}, instance).FilterErrors()).Should(HaveLen(2))
+	})
+
+	It("should hold an auction among reps on both transports", func() {
+		request := types.AuctionRequest{
+			Instance: instance,
+			RepGuids: types.RepGuids{"nats-a", "rabbit-a"},
+			Rules:    types.AuctionRules{Algorithm: "reserve_n_best", MaxRounds: 1, MaxBiddingPool: 1},
+		}
+
+		for i := 0; i < 4; i++ {
+			request.Instance.InstanceGuid = fmt.Sprintf("instance-%d", i)
+			Ω(auctioneer.Auction(client, request).Winner).ShouldNot(BeEmpty())
+		}
+
+		natsInstances, err := client.Instances("nats-a")
+		Ω(err).ShouldNot(HaveOccurred())
+		rabbitInstances, err := client.Instances("rabbit-a")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(natsInstances).Should(HaveLen(2))
+		Ω(rabbitInstances).Should(HaveLen(2))
+	})
+
+	It("should move a rep when it is routed elsewhere", func() {
+		client.Unroute("rabbit-a")
+		Ω(client.Score([]string{"rabbit-a"}, instance)[0].Error).Should(Equal(types.Timeout))
+
+		client.Route("rabbit-a", rabbitClient)
+		Ω(client.Score([]string{"rabbit-a"}, instance)[0].Error).Should(BeEmpty())
+	})
+
+	Context("without a fallback", func() {
+		BeforeEach(func() {
+			client = New(map[string]types.TestRepPoolClient{"nats-a": natsClient}, nil)
+		})
+
+		It("should fail requests to reps without a route straight away", func() {
+			t := time.Now()
+			results := client.Score([]string{"nats-a", "rep-gone"}, instance)
+			Ω(time.Since(t)).Should(BeNumerically("<", timeout))
+			Ω(results[0].Error).Should(BeEmpty())
+			Ω(results[1].Error).Should(Equal(types.RequestFailed))
+			Ω(results[1].Message).Should(Equal(UnroutableError.Error()))
+
+			_, err := client.Instances("rep-gone")
+			Ω(err).Should(Equal(UnroutableError))
+		})
+
+		It("should leave reps without a route out of the available reps", func() {
+			Ω(client.Available(types.RepGuids{"rep-gone", "nats-a"})).Should(Equal(types.RepGuids{"nats-a"}))
+		})
+	})
+
+	It("should let filtering transports decide which of their reps are available", func() {
+		client.Route("rabbit-a", filteringClient{TestRepPoolClient: rabbitClient, unavailable: "rabbit-a"})
+		client.Route("rabbit-b", filteringClient{TestRepPoolClient: rabbitClient, unavailable: "rabbit-a"})
+
+		Ω(client.Available(types.RepGuids{"rabbit-a", "nats-a", "rabbit-b"})).Should(Equal(types.RepGuids{"nats-a", "rabbit-b"}))
+	})
+})
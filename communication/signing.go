@@ -0,0 +1,93 @@
+package communication
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"strconv"
+)
+
+var UnsignedError = errors.New("unsigned")
+var BadSignatureError = errors.New("bad signature")
+var NoDeadlineError = errors.New("signed request has no deadline")
+
+// A Signer signs envelopes with an HMAC-SHA256 under a key that every rep and
+// auctioneer shares, so that processes without the key can't pass themselves
+// off as either.  A nil Signer signs nothing and accepts everything, which is
+// how transports run without a key.
+//
+// The signature covers every field of the envelope, including its request id
+// and deadline, and the rep and subject it is addressed to: a reply can't be
+// passed off as the reply to another request, a request can't be replayed to
+// another rep or on another subject, and (since the dispatcher and the
+// auctioneers refuse signed requests without a deadline, and drop them once it
+// has passed) it can only be replayed until its deadline.  A reply is signed
+// with its request's rep and subject.  Requests to no rep in particular, such
+// as broadcasts and auctions, are addressed to the empty rep.  Version 0
+// envelopes have nowhere to put a signature, so a Signer rejects them.
+type Signer struct {
+	key []byte
+}
+
+func NewSigner(key []byte) *Signer {
+	return &Signer{key: append([]byte{}, key...)}
+}
+
+// Sign returns e, addressed to rep on subject, with its signature set.
+func (s *Signer) Sign(rep string, subject string, e Envelope) Envelope {
+	if s == nil || e.Version == 0 {
+		return e
+	}
+
+	e.Signature = s.mac(rep, subject, e)
+	return e
+}
+
+// Verify returns UnsignedError or BadSignatureError unless e was signed with
+// the Signer's key, addressed to rep on subject.
+func (s *Signer) Verify(rep string, subject string, e Envelope) error {
+	if s == nil {
+		return nil
+	}
+
+	if len(e.Signature) == 0 {
+		return UnsignedError
+	}
+
+	if !hmac.Equal(e.Signature, s.mac(rep, subject, e)) {
+		return BadSignatureError
+	}
+
+	return nil
+}
+
+func (s *Signer) mac(rep string, subject string, e Envelope) []byte {
+	mac := hmac.New(sha256.New, s.key)
+
+	var deadline int64
+	if !e.Deadline.IsZero() {
+		deadline = e.Deadline.UnixNano()
+	}
+
+	writeField(mac, []byte(strconv.Itoa(e.Version)))
+	writeField(mac, []byte(rep))
+	writeField(mac, []byte(subject))
+	writeField(mac, []byte(e.RequestID))
+	writeField(mac, []byte(strconv.FormatInt(deadline, 10)))
+	writeField(mac, []byte(e.ContentType))
+	writeField(mac, []byte(e.Error))
+	writeField(mac, e.Body)
+
+	return mac.Sum(nil)
+}
+
+// writeField length-prefixes each field, so that no two envelopes hash the
+// same bytes.
+func writeField(h hash.Hash, value []byte) {
+	length := make([]byte, 8)
+	binary.BigEndian.PutUint64(length, uint64(len(value)))
+	h.Write(length)
+	h.Write(value)
+}
@@ -0,0 +1,87 @@
+package communication_test
+
+import (
+	"time"
+
+	. "github.com/onsi/auction/communication"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Signing", func() {
+	var signer *Signer
+	var envelope Envelope
+
+	BeforeEach(func() {
+		signer = NewSigner([]byte("shared-key"))
+		envelope = Envelope{
+			Version:     ProtocolVersion,
+			RequestID:   "request-id",
+			Deadline:    time.Unix(0, time.Now().UnixNano()),
+			ContentType: JSON.ContentType(),
+			Body:        []byte(`{"a":"app-guid"}`),
+		}
+	})
+
+	It("should verify envelopes it signed", func() {
+		Ω(signer.Verify("rep-guid", ScoreSubject, signer.Sign("rep-guid", ScoreSubject, envelope))).ShouldNot(HaveOccurred())
+	})
+
+	It("should verify signatures that have been sealed and opened, or sent as headers", func() {
+		opened, err := Open(Seal(signer.Sign("rep-guid", ScoreSubject, envelope)))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(signer.Verify("rep-guid", ScoreSubject, opened)).ShouldNot(HaveOccurred())
+
+		headers := signer.Sign("rep-guid", ScoreSubject, envelope).Headers()
+		fromHeaders := EnvelopeFromHeaders(envelope.ContentType, func(key string) string { return headers[key] }, envelope.Body)
+		Ω(signer.Verify("rep-guid", ScoreSubject, fromHeaders)).ShouldNot(HaveOccurred())
+	})
+
+	It("should reject unsigned envelopes", func() {
+		Ω(signer.Verify("rep-guid", ScoreSubject, envelope)).Should(Equal(UnsignedError))
+	})
+
+	It("should reject envelopes signed with another key", func() {
+		signed := NewSigner([]byte("another-key")).Sign("rep-guid", ScoreSubject, envelope)
+		Ω(signer.Verify("rep-guid", ScoreSubject, signed)).Should(Equal(BadSignatureError))
+	})
+
+	It("should reject envelopes that were changed after signing", func() {
+		tamper := []func(*Envelope){
+			func(e *Envelope) { e.RequestID = "another-request-id" },
+			func(e *Envelope) { e.Deadline = e.Deadline.Add(time.Hour) },
+			func(e *Envelope) { e.ContentType = MsgPack.ContentType() },
+			func(e *Envelope) { e.Error = RequestFailed },
+			func(e *Envelope) { e.Body = []byte(`{"a":"another-app-guid"}`) },
+		}
+
+		for _, change := range tamper {
+			signed := signer.Sign("rep-guid", ScoreSubject, envelope)
+			change(&signed)
+			Ω(signer.Verify("rep-guid", ScoreSubject, signed)).Should(Equal(BadSignatureError))
+		}
+	})
+
+	It("should reject envelopes replayed on another subject", func() {
+		signed := signer.Sign("rep-guid", ScoreThenTentativelyReserveSubject, envelope)
+		Ω(signer.Verify("rep-guid", ClaimSubject, signed)).Should(Equal(BadSignatureError))
+	})
+
+	It("should reject envelopes replayed to another rep", func() {
+		signed := signer.Sign("rep-guid", ClaimSubject, envelope)
+		Ω(signer.Verify("another-rep-guid", ClaimSubject, signed)).Should(Equal(BadSignatureError))
+		Ω(signer.Verify("", ClaimSubject, signed)).Should(Equal(BadSignatureError))
+	})
+
+	It("should not sign version 0 envelopes, and so reject them", func() {
+		legacy := Envelope{ContentType: JSON.ContentType(), Body: envelope.Body}
+		Ω(signer.Sign("rep-guid", ScoreSubject, legacy).Signature).Should(BeEmpty())
+		Ω(signer.Verify("rep-guid", ScoreSubject, signer.Sign("rep-guid", ScoreSubject, legacy))).Should(Equal(UnsignedError))
+	})
+
+	It("should sign nothing and accept everything when nil", func() {
+		var nilSigner *Signer
+		Ω(nilSigner.Sign("rep-guid", ScoreSubject, envelope).Signature).Should(BeEmpty())
+		Ω(nilSigner.Verify("rep-guid", ScoreSubject, envelope)).ShouldNot(HaveOccurred())
+	})
+})
@@ -0,0 +1,120 @@
+// Package testcerts generates throwaway certificate authorities and
+// certificates, so that TLS can be tested without any certificates on disk.
+package testcerts
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"time"
+)
+
+// Certs is a certificate authority and a certificate it has signed, all PEM
+// encoded.
+type Certs struct {
+	CACert []byte
+	Cert   []byte
+	Key    []byte
+}
+
+// Generate makes a fresh certificate authority and a certificate, valid for an
+// hour, for the given hosts (names or IP addresses).
+func Generate(hosts ...string) (*Certs, error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	caTemplate := template("auction test ca")
+	caTemplate.IsCA = true
+	caTemplate.KeyUsage = x509.KeyUsageCertSign
+	caTemplate.BasicConstraintsValid = true
+
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	certTemplate := template("auction test")
+	certTemplate.KeyUsage = x509.KeyUsageDigitalSignature
+	certTemplate.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			certTemplate.IPAddresses = append(certTemplate.IPAddresses, ip)
+		} else {
+			certTemplate.DNSNames = append(certTemplate.DNSNames, host)
+		}
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, certTemplate, caTemplate, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Certs{
+		CACert: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+		Cert:   pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}),
+		Key:    pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}, nil
+}
+
+func template(commonName string) *x509.Certificate {
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+}
+
+// Write saves the certificates to ca.pem, cert.pem and key.pem in dir and
+// returns their paths.
+func (c *Certs) Write(dir string) (caFile string, certFile string, keyFile string, err error) {
+	caFile = filepath.Join(dir, "ca.pem")
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+
+	for file, contents := range map[string][]byte{caFile: c.CACert, certFile: c.Cert, keyFile: c.Key} {
+		err = ioutil.WriteFile(file, contents, 0600)
+		if err != nil {
+			return "", "", "", err
+		}
+	}
+
+	return caFile, certFile, keyFile, nil
+}
+
+// ServerConfig presents the certificate.
+func (c *Certs) ServerConfig() *tls.Config {
+	certificate, err := tls.X509KeyPair(c.Cert, c.Key)
+	if err != nil {
+		panic(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{certificate}}
+}
+
+// ClientConfig trusts the certificate authority.
+func (c *Certs) ClientConfig() *tls.Config {
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(c.CACert)
+	return &tls.Config{RootCAs: pool}
+}
@@ -0,0 +1,42 @@
+package communication
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+)
+
+// TLSConfig loads PEM files into a tls.Config.  caFile holds the certificates
+// that peers' certificates must chain to; certFile and keyFile hold this
+// process's own certificate, for servers and for clients the peer asks for one.
+// Any of them may be empty, and if they all are TLSConfig returns nil: no TLS.
+func TLSConfig(caFile string, certFile string, keyFile string) (*tls.Config, error) {
+	if caFile == "" && certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+
+	config := &tls.Config{}
+
+	if caFile != "" {
+		caPEM, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, errors.New("no certificates in " + caFile)
+		}
+	}
+
+	if certFile != "" || keyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	return config, nil
+}
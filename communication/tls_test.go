@@ -0,0 +1,63 @@
+package communication_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/auction/communication"
+	"github.com/onsi/auction/communication/testcerts"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TLSConfig", func() {
+	var dir string
+	var caFile, certFile, keyFile string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "tls")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		certs, err := testcerts.Generate("127.0.0.1")
+		Ω(err).ShouldNot(HaveOccurred())
+		caFile, certFile, keyFile, err = certs.Write(dir)
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("should return nil without any files", func() {
+		config, err := TLSConfig("", "", "")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(config).Should(BeNil())
+	})
+
+	It("should trust the certificate authority", func() {
+		config, err := TLSConfig(caFile, "", "")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(config.RootCAs.Subjects()).Should(HaveLen(1))
+		Ω(config.Certificates).Should(BeEmpty())
+	})
+
+	It("should present the certificate", func() {
+		config, err := TLSConfig("", certFile, keyFile)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(config.RootCAs).Should(BeNil())
+		Ω(config.Certificates).Should(HaveLen(1))
+	})
+
+	It("should fail on missing files, or files without certificates", func() {
+		_, err := TLSConfig(filepath.Join(dir, "missing.pem"), "", "")
+		Ω(err).Should(HaveOccurred())
+
+		_, err = TLSConfig(keyFile, "", "")
+		Ω(err).Should(HaveOccurred())
+
+		_, err = TLSConfig("", certFile, "")
+		Ω(err).Should(HaveOccurred())
+	})
+})
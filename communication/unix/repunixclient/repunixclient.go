@@ -0,0 +1,203 @@
+package repunixclient
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/onsi/auction/communication"
+	"github.com/onsi/auction/communication/unix/unixframe"
+	"github.com/onsi/auction/util"
+)
+
+var UnknownRepError = errors.New("unknown rep")
+var DisconnectedError = errors.New("disconnected from rep")
+var RequestFailedError = communication.RequestFailedError
+var TimeoutError = communication.TimeoutError
+
+type RepUnixClient struct {
+	communication.RepClient
+
+	repSockets  map[string]string
+	timeout     time.Duration
+	codec       communication.Codec
+	signer      *communication.Signer
+	wire        *communication.WireCounter
+	connections map[string]*connection
+	lock        *sync.Mutex
+}
+
+// New takes the path of the unix socket each rep guid is listening on (see
+// repunixserver).  Each rep gets one connection, which every request to it
+// shares and which is redialed when it drops; every request is bounded by
+// timeout and encoded with codec.  With a signer (which may be nil) requests
+// are signed, and only replies signed with the same key are accepted.
+func New(repSockets map[string]string, timeout time.Duration, codec communication.Codec, signer *communication.Signer) *RepUnixClient {
+	rep := &RepUnixClient{
+		repSockets:  repSockets,
+		timeout:     timeout,
+		codec:       codec,
+		signer:      signer,
+		wire:        communication.NewWireCounter(),
+		connections: map[string]*connection{},
+		lock:        &sync.Mutex{},
+	}
+	rep.RepClient = communication.NewRepClient(rep.request)
+
+	return rep
+}
+
+// connection matches the replies read off conn to the requests awaiting them,
+// by request id.  When conn fails, every request awaiting a reply fails at once
+// and the connection is dropped, to be redialed by the next request.
+type connection struct {
+	conn      net.Conn
+	requests  map[string]chan communication.Envelope
+	lost      chan struct{}
+	lock      *sync.Mutex
+	writeLock *sync.Mutex
+}
+
+func (rep *RepUnixClient) connectionTo(guid string) (*connection, error) {
+	socketPath, ok := rep.repSockets[guid]
+	if !ok {
+		return nil, UnknownRepError
+	}
+
+	rep.lock.Lock()
+	c, ok := rep.connections[guid]
+	rep.lock.Unlock()
+	if ok {
+		return c, nil
+	}
+
+	//dial without the lock, so that a slow rep doesn't hold up requests to
+	//the others; whichever request dials second closes its connection
+	conn, err := net.DialTimeout("unix", socketPath, rep.timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	rep.lock.Lock()
+	c, ok = rep.connections[guid]
+	if !ok {
+		c = &connection{
+			conn:      conn,
+			requests:  map[string]chan communication.Envelope{},
+			lost:      make(chan struct{}),
+			lock:      &sync.Mutex{},
+			writeLock: &sync.Mutex{},
+		}
+		rep.connections[guid] = c
+	}
+	rep.lock.Unlock()
+
+	if ok {
+		conn.Close()
+		return c, nil
+	}
+
+	go rep.read(guid, c)
+
+	return c, nil
+}
+
+func (rep *RepUnixClient) read(guid string, c *connection) {
+	for {
+		subject, reply, err := unixframe.Read(c.conn)
+		if err != nil {
+			break
+		}
+		rep.wire.CountReceived(unixframe.Size(subject, reply), reply)
+
+		c.lock.Lock()
+		replies, ok := c.requests[reply.RequestID]
+		c.lock.Unlock()
+		if !ok {
+			continue
+		}
+
+		select {
+		case replies <- reply:
+		default:
+		}
+	}
+
+	rep.lock.Lock()
+	if rep.connections[guid] == c {
+		delete(rep.connections, guid)
+	}
+	rep.lock.Unlock()
+
+	c.conn.Close()
+	close(c.lost)
+}
+
+func (rep *RepUnixClient) request(guid string, subject string, req interface{}, resp interface{}) (err error) {
+	payload := []byte{}
+	if req != nil {
+		payload, err = rep.codec.Marshal(req)
+		if err != nil {
+			return err
+		}
+	}
+
+	c, err := rep.connectionTo(guid)
+	if err == UnknownRepError {
+		return err
+	}
+	if err != nil {
+		return communication.TransportErrorFor(err)
+	}
+
+	request := rep.signer.Sign(guid, subject, communication.Envelope{
+		Version:     communication.ProtocolVersion,
+		RequestID:   util.RandomGuid(),
+		Deadline:    time.Now().Add(rep.timeout),
+		ContentType: rep.codec.ContentType(),
+		Body:        payload,
+	})
+
+	replies := make(chan communication.Envelope, 1)
+	c.lock.Lock()
+	c.requests[request.RequestID] = replies
+	c.lock.Unlock()
+
+	defer func() {
+		c.lock.Lock()
+		delete(c.requests, request.RequestID)
+		c.lock.Unlock()
+	}()
+
+	c.writeLock.Lock()
+	c.conn.SetWriteDeadline(request.Deadline)
+	err = unixframe.Write(c.conn, subject, request)
+	c.writeLock.Unlock()
+	if err != nil {
+		c.conn.Close()
+		return communication.TransportErrorFor(err)
+	}
+	rep.wire.CountSent(unixframe.Size(subject, request), request)
+
+	var reply communication.Envelope
+	select {
+	case reply = <-replies:
+	case <-c.lost:
+		return communication.TransportError{Err: DisconnectedError}
+	case <-time.After(rep.timeout):
+		return TimeoutError
+	}
+
+	err = rep.signer.Verify(guid, subject, reply)
+	if err != nil {
+		return err
+	}
+
+	return reply.Decode(resp)
+}
+
+// WireBytes counts the frames sent and received.
+func (rep *RepUnixClient) WireBytes() communication.WireBytes {
+	return rep.wire.Bytes()
+}
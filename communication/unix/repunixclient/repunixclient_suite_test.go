@@ -0,0 +1,13 @@
+package repunixclient_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestRepUnixClient(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "RepUnixClient Suite")
+}
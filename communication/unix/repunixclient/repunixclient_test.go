@@ -0,0 +1,248 @@
+package repunixclient_test
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/onsi/auction/auctionrep"
+	"github.com/onsi/auction/communication"
+	"github.com/onsi/auction/communication/conformance"
+	. "github.com/onsi/auction/communication/unix/repunixclient"
+	"github.com/onsi/auction/communication/unix/repunixserver"
+	"github.com/onsi/auction/communication/unix/unixframe"
+	"github.com/onsi/auction/simulation/simulationrepdelegate"
+	"github.com/onsi/auction/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// countingListener counts the connections it has accepted that are still open
+type countingListener struct {
+	net.Listener
+	open *int32
+}
+
+func (l countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddInt32(l.open, 1)
+	return &countingConn{Conn: conn, open: l.open, once: &sync.Once{}}, nil
+}
+
+type countingConn struct {
+	net.Conn
+	open *int32
+	once *sync.Once
+}
+
+func (c *countingConn) Close() error {
+	c.once.Do(func() { atomic.AddInt32(c.open, -1) })
+	return c.Conn.Close()
+}
+
+var _ = Describe("RepUnixClient", func() {
+	var tmpDir string
+	var servers []*repunixserver.Server
+	var slowListener net.Listener
+	var repSockets map[string]string
+	var client *RepUnixClient
+	var instance types.Instance
+
+	startRep := func(guid string, signer *communication.Signer) string {
+		socketPath := filepath.Join(tmpDir, guid+".sock")
+		rep := auctionrep.New(guid, simulationrepdelegate.New(types.Resources{
+			MemoryMB:   100,
+			DiskMB:     100,
+			Containers: 10,
+		}))
+		server, err := repunixserver.Start(socketPath, rep, signer, nil)
+		Ω(err).ShouldNot(HaveOccurred())
+		servers = append(servers, server)
+		return socketPath
+	}
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "repunixclient")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		servers = []*repunixserver.Server{}
+		repSockets = map[string]string{}
+		for _, guid := range []string{"rep-a", "rep-b"} {
+			repSockets[guid] = startRep(guid, nil)
+		}
+
+		//reads requests and never answers them
+		repSockets["rep-slow"] = filepath.Join(tmpDir, "rep-slow.sock")
+		listener, err := net.Listen("unix", repSockets["rep-slow"])
+		Ω(err).ShouldNot(HaveOccurred())
+		slowListener = listener
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				go ioutil.ReadAll(conn)
+			}
+		}()
+
+		client = New(repSockets, 100*time.Millisecond, communication.JSON, nil)
+
+		instance = types.Instance{
+			AppGuid:      "app-guid",
+			InstanceGuid: "instance-guid",
+			Resources:    types.Resources{MemoryMB: 1, DiskMB: 1},
+		}
+	})
+
+	AfterEach(func() {
+		for _, server := range servers {
+			server.Stop()
+		}
+		slowListener.Close()
+		os.RemoveAll(tmpDir)
+	})
+
+	conformance.ItBehavesLikeATestRepPoolClient(func() conformance.Fixture {
+		return conformance.Fixture{
+			Client:       client,
+			Timeout:      100 * time.Millisecond,
+			RepResources: types.Resources{MemoryMB: 100, DiskMB: 100, Containers: 10},
+			Responsive:   []string{"rep-a", "rep-b"},
+			Unresponsive: []string{"rep-slow"},
+		}
+	})
+
+	It("should reserve, claim and release", func() {
+		results := client.ScoreThenTentativelyReserve([]string{"rep-a", "rep-b"}, instance)
+		Ω(results.FilterErrors()).Should(HaveLen(2))
+
+		client.Claim("rep-a", instance)
+		client.ReleaseReservation([]string{"rep-b"}, instance)
+
+		Ω(client.Instances("rep-a")).Should(Equal([]types.Instance{instance}))
+		Ω(client.Instances("rep-b")).Should(BeEmpty())
+	})
+
+	It("should speak msgpack when asked to", func() {
+		client = New(map[string]string{"rep-a": repSockets["rep-a"]}, 100*time.Millisecond, communication.MsgPack, nil)
+
+		results := client.ScoreThenTentativelyReserve([]string{"rep-a"}, instance)
+		Ω(results.FilterErrors()).Should(HaveLen(1))
+
+		client.Claim("rep-a", instance)
+		Ω(client.Instances("rep-a")).Should(Equal([]types.Instance{instance}))
+	})
+
+	It("should return an errored result for reps it doesn't know about", func() {
+		results := client.Score([]string{"rep-a", "rep-nope"}, instance)
+		Ω(results).Should(HaveLen(2))
+		Ω(results.FilterErrors().Reps()).Should(Equal(types.RepGuids{"rep-a"}))
+		Ω(client.Reset("rep-nope")).Should(Equal(UnknownRepError))
+	})
+
+	It("should time out on reps that don't respond in time", func() {
+		t := time.Now()
+		results := client.Score([]string{"rep-a", "rep-slow"}, instance)
+		Ω(time.Since(t)).Should(BeNumerically("<", 500*time.Millisecond))
+		Ω(results).Should(HaveLen(2))
+		Ω(results[1].Error).Should(Equal(types.Timeout))
+	})
+
+	It("should stamp every request with a deadline", func() {
+		requests := make(chan communication.Envelope, 1)
+		socketPath := filepath.Join(tmpDir, "rep-c.sock")
+		listener, err := net.Listen("unix", socketPath)
+		Ω(err).ShouldNot(HaveOccurred())
+		defer listener.Close()
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			_, request, _ := unixframe.Read(conn)
+			requests <- request
+		}()
+
+		client = New(map[string]string{"rep-c": socketPath}, 100*time.Millisecond, communication.JSON, nil)
+		client.Score([]string{"rep-c"}, instance)
+
+		var request communication.Envelope
+		Eventually(requests).Should(Receive(&request))
+		Ω(request.Deadline.Sub(time.Now())).Should(BeNumerically("~", 0, 150*time.Millisecond))
+	})
+
+	Describe("connections", func() {
+		It("should report reps that can't be reached as transport failures", func() {
+			client = New(map[string]string{"rep-gone": filepath.Join(tmpDir, "rep-gone.sock")}, 100*time.Millisecond, communication.JSON, nil)
+
+			results := client.Score([]string{"rep-gone"}, instance)
+			Ω(results[0].Error).Should(Equal(types.TransportFailure))
+		})
+
+		It("should keep one connection to a rep that many requests dial at once", func() {
+			socketPath := filepath.Join(tmpDir, "rep-c.sock")
+			listener, err := net.Listen("unix", socketPath)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			var open int32
+			rep := auctionrep.New("rep-c", simulationrepdelegate.New(types.Resources{MemoryMB: 100, DiskMB: 100, Containers: 10}))
+			servers = append(servers, repunixserver.Serve(countingListener{listener, &open}, rep, nil, nil))
+
+			client = New(map[string]string{"rep-c": socketPath}, time.Second, communication.JSON, nil)
+			start := make(chan struct{})
+			scored := make(chan types.ScoreResults, 50)
+			for i := 0; i < 50; i++ {
+				go func() {
+					<-start
+					scored <- client.Score([]string{"rep-c"}, instance)
+				}()
+			}
+			close(start)
+			for i := 0; i < 50; i++ {
+				var results types.ScoreResults
+				Eventually(scored).Should(Receive(&results))
+				Ω(results.FilterErrors()).Should(HaveLen(1))
+			}
+
+			Eventually(func() int32 { return atomic.LoadInt32(&open) }).Should(Equal(int32(1)))
+			Consistently(func() int32 { return atomic.LoadInt32(&open) }).Should(Equal(int32(1)))
+		})
+
+		It("should redial reps that have come back", func() {
+			Ω(client.Score([]string{"rep-a"}, instance).FilterErrors()).Should(HaveLen(1))
+
+			servers[0].Stop()
+			Eventually(func() types.ScoreError {
+				return client.Score([]string{"rep-a"}, instance)[0].Error
+			}).Should(Equal(types.TransportFailure))
+
+			startRep("rep-a", nil)
+			Eventually(func() types.ScoreResults {
+				return client.Score([]string{"rep-a"}, instance).FilterErrors()
+			}).Should(HaveLen(1))
+		})
+	})
+
+	Describe("signing", func() {
+		It("should only accept replies signed with its key", func() {
+			signer := communication.NewSigner([]byte("shared-key"))
+			signedSockets := map[string]string{"rep-signed": startRep("rep-signed", signer)}
+
+			client = New(signedSockets, 100*time.Millisecond, communication.JSON, signer)
+			Ω(client.Score([]string{"rep-signed"}, instance).FilterErrors()).Should(HaveLen(1))
+
+			client = New(signedSockets, 100*time.Millisecond, communication.JSON, communication.NewSigner([]byte("another-key")))
+			Ω(client.Score([]string{"rep-signed"}, instance).FilterErrors()).Should(BeEmpty())
+		})
+	})
+})
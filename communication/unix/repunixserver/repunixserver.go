@@ -0,0 +1,159 @@
+package repunixserver
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/onsi/auction/auctionrep"
+	"github.com/onsi/auction/communication"
+	"github.com/onsi/auction/communication/unix/unixframe"
+)
+
+// Server answers requests framed by unixframe.  Each connection may carry
+// many requests at once: they are handled concurrently and their replies,
+// which carry the request's id, are written as they are ready.
+type Server struct {
+	listener    net.Listener
+	rep         *auctionrep.AuctionRep
+	signer      *communication.Signer
+	dispatcher  *communication.RepDispatcher
+	inFlight    *communication.InFlight
+	connections map[net.Conn]bool
+	lock        *sync.Mutex
+	stopOnce    *sync.Once
+	stopped     chan struct{}
+}
+
+// Start listens on the unix socket at socketPath, replacing whatever socket a
+// previous rep left behind there, and serves rep until Stop is called.  With a
+// signer (which may be nil) the server only answers requests signed with its
+// key, and with an admission (ditto) it turns away bids beyond its limits.
+func Start(socketPath string, rep *auctionrep.AuctionRep, signer *communication.Signer, admission *communication.Admission) (*Server, error) {
+	err := os.Remove(socketPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+
+	server := Serve(listener, rep, signer, admission)
+
+	fmt.Printf("[%s] listening for unix\n", rep.Guid())
+
+	return server, nil
+}
+
+// Serve serves rep on connections accepted from listener, which Stop closes.
+func Serve(listener net.Listener, rep *auctionrep.AuctionRep, signer *communication.Signer, admission *communication.Admission) *Server {
+	server := &Server{
+		listener:    listener,
+		rep:         rep,
+		signer:      signer,
+		dispatcher:  communication.NewRepDispatcher(rep, signer, admission),
+		inFlight:    communication.NewInFlight(),
+		connections: map[net.Conn]bool{},
+		lock:        &sync.Mutex{},
+		stopOnce:    &sync.Once{},
+		stopped:     make(chan struct{}),
+	}
+
+	go server.accept()
+
+	return server
+}
+
+func (server *Server) accept() {
+	for {
+		conn, err := server.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		server.lock.Lock()
+		stopping := server.connections == nil
+		if !stopping {
+			server.connections[conn] = true
+		}
+		server.lock.Unlock()
+
+		if stopping {
+			conn.Close()
+			return
+		}
+
+		go server.serve(conn)
+	}
+}
+
+func (server *Server) serve(conn net.Conn) {
+	defer func() {
+		server.lock.Lock()
+		delete(server.connections, conn)
+		server.lock.Unlock()
+		conn.Close()
+	}()
+
+	writeLock := &sync.Mutex{}
+	reply := func(envelope communication.Envelope) {
+		writeLock.Lock()
+		unixframe.Write(conn, "", envelope)
+		writeLock.Unlock()
+	}
+
+	for {
+		subject, request, err := unixframe.Read(conn)
+		if err != nil {
+			return
+		}
+
+		go server.handle(subject, request, reply)
+	}
+}
+
+func (server *Server) handle(subject string, request communication.Envelope, reply func(communication.Envelope)) {
+	if !server.inFlight.Begin() {
+		reply(server.dispatcher.Refuse(subject, request))
+		return
+	}
+	defer server.inFlight.End()
+
+	envelope, ok := server.dispatcher.DispatchEnvelope(subject, request)
+	if !ok {
+		return
+	}
+	reply(envelope)
+}
+
+// Stop stops accepting connections, lets requests that are already being
+// handled reply (bids that arrive meanwhile are told the rep is draining), and
+// then closes every connection.
+func (server *Server) Stop() {
+	server.stopOnce.Do(func() {
+		server.listener.Close()
+		server.inFlight.Drain()
+
+		server.lock.Lock()
+		for conn := range server.connections {
+			conn.Close()
+		}
+		server.connections = nil
+		server.lock.Unlock()
+
+		close(server.stopped)
+	})
+}
+
+// Expired is the number of requests dropped because their deadline had passed.
+func (server *Server) Expired() uint64 {
+	return server.dispatcher.Expired()
+}
+
+// Wait blocks until the server has stopped.
+func (server *Server) Wait() {
+	<-server.stopped
+}
@@ -0,0 +1,13 @@
+package repunixserver_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestRepUnixServer(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "RepUnixServer Suite")
+}
@@ -0,0 +1,229 @@
+package repunixserver_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/onsi/auction/auctionrep"
+	"github.com/onsi/auction/auctionrep/fakedelegate"
+	"github.com/onsi/auction/communication"
+	. "github.com/onsi/auction/communication/unix/repunixserver"
+	"github.com/onsi/auction/communication/unix/unixframe"
+	"github.com/onsi/auction/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RepUnixServer", func() {
+	var tmpDir string
+	var socketPath string
+	var release chan struct{}
+	var arrived int32
+	var server *Server
+	var conn net.Conn
+	var replies chan communication.Envelope
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "repunixserver")
+		Ω(err).ShouldNot(HaveOccurred())
+		socketPath = filepath.Join(tmpDir, "rep.sock")
+
+		release = make(chan struct{})
+		arrived = 0
+
+		server, err = Start(socketPath, auctionrep.New("rep", fakedelegate.BlockingDelegate{Arrived: &arrived, Release: release}), nil, nil)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		conn, err = net.Dial("unix", socketPath)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		replies = make(chan communication.Envelope, 10)
+		received, connection := replies, conn
+		go func() {
+			for {
+				_, reply, err := unixframe.Read(connection)
+				if err != nil {
+					close(received)
+					return
+				}
+				received <- reply
+			}
+		}()
+	})
+
+	AfterEach(func() {
+		select {
+		case <-release:
+		default:
+			close(release)
+		}
+		server.Stop()
+		conn.Close()
+		os.RemoveAll(tmpDir)
+	})
+
+	score := func(requestID string, deadline time.Time) {
+		payload, _ := json.Marshal(types.Instance{InstanceGuid: "instance", Resources: types.Resources{MemoryMB: 1, DiskMB: 1}})
+		err := unixframe.Write(conn, communication.ScoreSubject, communication.Envelope{
+			Version:     communication.ProtocolVersion,
+			RequestID:   requestID,
+			Deadline:    deadline,
+			ContentType: communication.JSON.ContentType(),
+			Body:        payload,
+		})
+		Ω(err).ShouldNot(HaveOccurred())
+	}
+
+	decodeScore := func(reply communication.Envelope) types.ScoreResult {
+		var result types.ScoreResult
+		Ω(reply.Decode(&result)).ShouldNot(HaveOccurred())
+		return result
+	}
+
+	It("should answer requests with their request id", func() {
+		close(release)
+		score("request-id", time.Time{})
+
+		var reply communication.Envelope
+		Eventually(replies).Should(Receive(&reply))
+		Ω(reply.RequestID).Should(Equal("request-id"))
+		Ω(decodeScore(reply).Rep).Should(Equal("rep"))
+	})
+
+	It("should handle the requests on a connection concurrently", func() {
+		for _, requestID := range []string{"a", "b", "c"} {
+			score(requestID, time.Time{})
+		}
+		Eventually(func() int32 { return atomic.LoadInt32(&arrived) }).Should(Equal(int32(3)))
+
+		close(release)
+		Eventually(replies).Should(Receive())
+		Eventually(replies).Should(Receive())
+		Eventually(replies).Should(Receive())
+	})
+
+	It("should drop expired requests and count them", func() {
+		close(release)
+
+		score("expired", time.Now().Add(-time.Second))
+		score("live", time.Now().Add(time.Second))
+
+		var reply communication.Envelope
+		Eventually(replies).Should(Receive(&reply))
+		Ω(reply.RequestID).Should(Equal("live"))
+		Consistently(replies).ShouldNot(Receive())
+		Ω(server.Expired()).Should(Equal(uint64(1)))
+	})
+
+	It("should replace a socket left behind by an earlier rep", func() {
+		leftover := filepath.Join(tmpDir, "leftover.sock")
+		Ω(ioutil.WriteFile(leftover, nil, 0600)).ShouldNot(HaveOccurred())
+
+		other, err := Start(leftover, auctionrep.New("other", fakedelegate.BlockingDelegate{Arrived: &arrived, Release: release}), nil, nil)
+		Ω(err).ShouldNot(HaveOccurred())
+		defer other.Stop()
+
+		otherConn, err := net.Dial("unix", leftover)
+		Ω(err).ShouldNot(HaveOccurred())
+		otherConn.Close()
+	})
+
+	It("should answer bids beyond its admission that it is busy", func() {
+		admission, err := communication.NewAdmission(1, 0)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		busySocketPath := filepath.Join(tmpDir, "busy.sock")
+		busyServer, err := Start(busySocketPath, auctionrep.New("busy-rep", fakedelegate.BlockingDelegate{Arrived: &arrived, Release: release}), nil, admission)
+		Ω(err).ShouldNot(HaveOccurred())
+		defer busyServer.Stop()
+
+		busyConn, err := net.Dial("unix", busySocketPath)
+		Ω(err).ShouldNot(HaveOccurred())
+		defer busyConn.Close()
+
+		payload, _ := json.Marshal(types.Instance{InstanceGuid: "instance", Resources: types.Resources{MemoryMB: 1, DiskMB: 1}})
+		for _, requestID := range []string{"admitted", "turned-away"} {
+			Ω(unixframe.Write(busyConn, communication.ScoreSubject, communication.Envelope{
+				Version:     communication.ProtocolVersion,
+				RequestID:   requestID,
+				ContentType: communication.JSON.ContentType(),
+				Body:        payload,
+			})).ShouldNot(HaveOccurred())
+			Eventually(func() int32 { return atomic.LoadInt32(&arrived) }).Should(Equal(int32(1)))
+		}
+
+		busyReplies := make(chan communication.Envelope, 2)
+		go func() {
+			for {
+				_, reply, err := unixframe.Read(busyConn)
+				if err != nil {
+					return
+				}
+				busyReplies <- reply
+			}
+		}()
+
+		var reply communication.Envelope
+		Eventually(busyReplies).Should(Receive(&reply))
+		Ω(reply.RequestID).Should(Equal("turned-away"))
+		Ω(decodeScore(reply).Rep).Should(Equal("busy-rep"))
+		Ω(decodeScore(reply).Error).Should(Equal(types.RepBusy))
+		Ω(admission.Busy()).Should(Equal(uint64(1)))
+
+		close(release)
+		Eventually(busyReplies).Should(Receive(&reply))
+		Ω(reply.RequestID).Should(Equal("admitted"))
+	})
+
+	Describe("stopping", func() {
+		It("should let in-flight requests reply, and tell bids that arrive meanwhile that it is draining", func() {
+			score("in-flight", time.Time{})
+			Eventually(func() int32 { return atomic.LoadInt32(&arrived) }).Should(Equal(int32(1)))
+
+			stopped := make(chan struct{})
+			go func() {
+				server.Stop()
+				close(stopped)
+			}()
+
+			Consistently(stopped).ShouldNot(BeClosed())
+
+			score("refused", time.Time{})
+			var reply communication.Envelope
+			Eventually(replies).Should(Receive(&reply))
+			Ω(reply.RequestID).Should(Equal("refused"))
+			Ω(decodeScore(reply).Error).Should(Equal(types.Draining))
+
+			close(release)
+			Eventually(replies).Should(Receive(&reply))
+			Ω(reply.RequestID).Should(Equal("in-flight"))
+			Eventually(stopped).Should(BeClosed())
+		})
+
+		It("should close its connections and let Wait return", func() {
+			close(release)
+
+			waited := make(chan struct{})
+			go func() {
+				server.Wait()
+				close(waited)
+			}()
+			Consistently(waited).ShouldNot(BeClosed())
+
+			server.Stop()
+			server.Stop()
+
+			Eventually(waited).Should(BeClosed())
+			Eventually(replies).Should(BeClosed())
+
+			_, err := net.Dial("unix", socketPath)
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+})
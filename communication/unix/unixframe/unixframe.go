@@ -0,0 +1,81 @@
+package unixframe
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/onsi/auction/communication"
+)
+
+// MaxFrameSize bounds what a peer may ask us to read, so that a corrupt length
+// can't make us allocate without limit.
+const MaxFrameSize = 16 * 1024 * 1024
+
+var FrameTooLargeError = errors.New("frame too large")
+var TruncatedFrameError = errors.New("truncated frame")
+
+// Write frames envelope for subject (which is empty on replies) and writes it
+// in one go, so that writers sharing a connection only need to serialize their
+// calls to Write.
+//
+// A frame is a 4 byte big-endian length followed by that many bytes: a 2 byte
+// big-endian subject length, the subject, and the sealed envelope (see
+// communication.Seal).
+func Write(w io.Writer, subject string, envelope communication.Envelope) error {
+	sealed := communication.Seal(envelope)
+
+	length := 2 + len(subject) + len(sealed)
+	if length > MaxFrameSize || len(subject) > 0xffff {
+		return FrameTooLargeError
+	}
+
+	frame := make([]byte, 4+length)
+	binary.BigEndian.PutUint32(frame, uint32(length))
+	binary.BigEndian.PutUint16(frame[4:], uint16(len(subject)))
+	copy(frame[6:], subject)
+	copy(frame[6+len(subject):], sealed)
+
+	_, err := w.Write(frame)
+	return err
+}
+
+// Size is the number of bytes Write writes to frame envelope for subject.
+func Size(subject string, envelope communication.Envelope) int {
+	return 4 + 2 + len(subject) + len(communication.Seal(envelope))
+}
+
+// Read reads the next frame, returning its subject and envelope.
+func Read(r io.Reader) (string, communication.Envelope, error) {
+	header := make([]byte, 4)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return "", communication.Envelope{}, err
+	}
+
+	length := binary.BigEndian.Uint32(header)
+	if length > MaxFrameSize {
+		return "", communication.Envelope{}, FrameTooLargeError
+	}
+
+	frame := make([]byte, length)
+	_, err = io.ReadFull(r, frame)
+	if err != nil {
+		return "", communication.Envelope{}, err
+	}
+
+	if len(frame) < 2 {
+		return "", communication.Envelope{}, TruncatedFrameError
+	}
+	subjectLength := int(binary.BigEndian.Uint16(frame))
+	if len(frame) < 2+subjectLength {
+		return "", communication.Envelope{}, TruncatedFrameError
+	}
+
+	envelope, err := communication.Open(frame[2+subjectLength:])
+	if err != nil {
+		return "", communication.Envelope{}, err
+	}
+
+	return string(frame[2 : 2+subjectLength]), envelope, nil
+}
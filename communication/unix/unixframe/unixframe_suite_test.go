@@ -0,0 +1,13 @@
+package unixframe_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestUnixframe(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Unixframe Suite")
+}
@@ -0,0 +1,76 @@
+package unixframe_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"time"
+
+	"github.com/onsi/auction/communication"
+	. "github.com/onsi/auction/communication/unix/unixframe"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Unixframe", func() {
+	var envelope communication.Envelope
+
+	BeforeEach(func() {
+		envelope = communication.Envelope{
+			Version:     communication.ProtocolVersion,
+			RequestID:   "request-id",
+			Deadline:    time.Unix(0, 1234),
+			ContentType: communication.JSON.ContentType(),
+			Body:        []byte(`{"a":"app-guid"}`),
+		}
+	})
+
+	It("should read back what it wrote, frame after frame", func() {
+		buffer := &bytes.Buffer{}
+		Ω(Write(buffer, communication.ScoreSubject, envelope)).ShouldNot(HaveOccurred())
+		Ω(Write(buffer, "", envelope.Reply([]byte("{}"), communication.NoError))).ShouldNot(HaveOccurred())
+
+		subject, read, err := Read(buffer)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(subject).Should(Equal(communication.ScoreSubject))
+		Ω(read.RequestID).Should(Equal("request-id"))
+		Ω(read.Deadline.UnixNano()).Should(Equal(int64(1234)))
+		Ω(read.Body).Should(Equal(envelope.Body))
+
+		subject, read, err = Read(buffer)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(subject).Should(BeEmpty())
+		Ω(read.RequestID).Should(Equal("request-id"))
+		Ω(read.Body).Should(Equal([]byte("{}")))
+
+		_, _, err = Read(buffer)
+		Ω(err).Should(Equal(io.EOF))
+	})
+
+	It("should know the size of the frames it writes", func() {
+		buffer := &bytes.Buffer{}
+		Ω(Write(buffer, communication.ScoreSubject, envelope)).ShouldNot(HaveOccurred())
+		Ω(Size(communication.ScoreSubject, envelope)).Should(Equal(buffer.Len()))
+	})
+
+	It("should refuse to write or read frames that are too large", func() {
+		envelope.Body = make([]byte, MaxFrameSize)
+		Ω(Write(&bytes.Buffer{}, communication.ScoreSubject, envelope)).Should(Equal(FrameTooLargeError))
+
+		header := make([]byte, 4)
+		binary.BigEndian.PutUint32(header, MaxFrameSize+1)
+		_, _, err := Read(bytes.NewReader(header))
+		Ω(err).Should(Equal(FrameTooLargeError))
+	})
+
+	It("should fail on frames that end early", func() {
+		buffer := &bytes.Buffer{}
+		Ω(Write(buffer, communication.ScoreSubject, envelope)).ShouldNot(HaveOccurred())
+
+		_, _, err := Read(bytes.NewReader(buffer.Bytes()[:buffer.Len()-1]))
+		Ω(err).Should(Equal(io.ErrUnexpectedEOF))
+
+		_, _, err = Read(bytes.NewReader([]byte{0, 0, 0, 3, 0, 9, 'x'}))
+		Ω(err).Should(Equal(TruncatedFrameError))
+	})
+})
@@ -0,0 +1,113 @@
+package communication
+
+import (
+	"sync/atomic"
+)
+
+// WireBytes is a count of the bytes a client sent and received, and of the
+// bytes it would have sent and received had it encoded every body as JSON.
+type WireBytes struct {
+	Sent           uint64
+	Received       uint64
+	SentAsJSON     uint64
+	ReceivedAsJSON uint64
+}
+
+// Since is the count of bytes sent and received after earlier was taken.
+func (b WireBytes) Since(earlier WireBytes) WireBytes {
+	return WireBytes{
+		Sent:           b.Sent - earlier.Sent,
+		Received:       b.Received - earlier.Received,
+		SentAsJSON:     b.SentAsJSON - earlier.SentAsJSON,
+		ReceivedAsJSON: b.ReceivedAsJSON - earlier.ReceivedAsJSON,
+	}
+}
+
+// Saved is how many fewer bytes were sent and received than would have been
+// with JSON bodies; it is negative when JSON would have been smaller.
+func (b WireBytes) Saved() int64 {
+	return int64(b.SentAsJSON+b.ReceivedAsJSON) - int64(b.Sent+b.Received)
+}
+
+// WireCounted is implemented by the clients that count the bytes they send
+// and receive.
+type WireCounted interface {
+	WireBytes() WireBytes
+}
+
+// A WireCounter counts the bytes of the messages a client sends and receives:
+// each message's body and envelope as its transport carries them (sealed on
+// NATS, framed on unix sockets, see Envelope.Size for transports with
+// headers).  The transports' own protocols, addressing and routing aren't
+// counted.  A nil WireCounter counts nothing.
+type WireCounter struct {
+	sent           uint64
+	received       uint64
+	sentAsJSON     uint64
+	receivedAsJSON uint64
+}
+
+func NewWireCounter() *WireCounter {
+	return &WireCounter{}
+}
+
+// CountSent counts a message of size bytes carrying e.
+func (c *WireCounter) CountSent(size int, e Envelope) {
+	if c == nil {
+		return
+	}
+
+	atomic.AddUint64(&c.sent, uint64(size))
+	atomic.AddUint64(&c.sentAsJSON, uint64(sizeAsJSON(size, e)))
+}
+
+// CountReceived counts a message of size bytes carrying e.
+func (c *WireCounter) CountReceived(size int, e Envelope) {
+	if c == nil {
+		return
+	}
+
+	atomic.AddUint64(&c.received, uint64(size))
+	atomic.AddUint64(&c.receivedAsJSON, uint64(sizeAsJSON(size, e)))
+}
+
+// Bytes is the count so far.
+func (c *WireCounter) Bytes() WireBytes {
+	if c == nil {
+		return WireBytes{}
+	}
+
+	return WireBytes{
+		Sent:           atomic.LoadUint64(&c.sent),
+		Received:       atomic.LoadUint64(&c.received),
+		SentAsJSON:     atomic.LoadUint64(&c.sentAsJSON),
+		ReceivedAsJSON: atomic.LoadUint64(&c.receivedAsJSON),
+	}
+}
+
+// sizeAsJSON is the size a message of size bytes carrying e would have been
+// had e's body been encoded as JSON.  Bodies that are already JSON, or that
+// can't be decoded, count as they are.
+func sizeAsJSON(size int, e Envelope) int {
+	if len(e.Body) == 0 || e.ContentType == "" || e.ContentType == JSON.ContentType() {
+		return size
+	}
+
+	codec, err := CodecForContentType(e.ContentType)
+	if err != nil {
+		return size
+	}
+
+	var body interface{}
+	err = codec.Unmarshal(e.Body, &body)
+	if err != nil {
+		return size
+	}
+
+	asJSON, err := JSON.Marshal(body)
+	if err != nil {
+		return size
+	}
+
+	return size - len(e.Body) - len(e.ContentType) + len(asJSON) + len(JSON.ContentType())
+}
@@ -0,0 +1,67 @@
+package communication_test
+
+import (
+	. "github.com/onsi/auction/communication"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WireCounter", func() {
+	var jsonMessage, msgPackMessage Envelope
+
+	BeforeEach(func() {
+		message := map[string]interface{}{"instance_guid": "instance-guid", "memory": 128}
+
+		body, err := JSON.Marshal(message)
+		Ω(err).ShouldNot(HaveOccurred())
+		jsonMessage = Envelope{Version: ProtocolVersion, ContentType: JSON.ContentType(), Body: body}
+
+		body, err = MsgPack.Marshal(message)
+		Ω(err).ShouldNot(HaveOccurred())
+		msgPackMessage = Envelope{Version: ProtocolVersion, ContentType: MsgPack.ContentType(), Body: body}
+	})
+
+	It("should count nothing when nil", func() {
+		var counter *WireCounter
+		counter.CountSent(10, jsonMessage)
+		counter.CountReceived(10, jsonMessage)
+		Ω(counter.Bytes()).Should(BeZero())
+	})
+
+	It("should count the bytes sent and received since an earlier count", func() {
+		counter := NewWireCounter()
+		counter.CountSent(10, jsonMessage)
+		earlier := counter.Bytes()
+
+		counter.CountSent(3, jsonMessage)
+		counter.CountReceived(4, jsonMessage)
+		counter.CountReceived(5, Envelope{})
+
+		Ω(counter.Bytes()).Should(Equal(WireBytes{Sent: 13, Received: 9, SentAsJSON: 13, ReceivedAsJSON: 9}))
+		Ω(counter.Bytes().Since(earlier)).Should(Equal(WireBytes{Sent: 3, Received: 9, SentAsJSON: 3, ReceivedAsJSON: 9}))
+		Ω(counter.Bytes().Saved()).Should(BeZero())
+	})
+
+	It("should count what messages in other codecs would have been as JSON", func() {
+		counter := NewWireCounter()
+		counter.CountSent(msgPackMessage.Size(), msgPackMessage)
+		counter.CountReceived(msgPackMessage.Size(), msgPackMessage)
+
+		Ω(counter.Bytes()).Should(Equal(WireBytes{
+			Sent:           uint64(msgPackMessage.Size()),
+			Received:       uint64(msgPackMessage.Size()),
+			SentAsJSON:     uint64(jsonMessage.Size()),
+			ReceivedAsJSON: uint64(jsonMessage.Size()),
+		}))
+		Ω(counter.Bytes().Saved()).Should(Equal(int64(2 * (jsonMessage.Size() - msgPackMessage.Size()))))
+		Ω(counter.Bytes().Saved()).Should(BeNumerically(">", 0))
+	})
+
+	It("should count bodies it can't decode as they are", func() {
+		counter := NewWireCounter()
+		garbled := Envelope{Version: ProtocolVersion, ContentType: MsgPack.ContentType(), Body: []byte{0xc1}}
+		counter.CountSent(garbled.Size(), garbled)
+
+		Ω(counter.Bytes().SentAsJSON).Should(Equal(uint64(garbled.Size())))
+	})
+})
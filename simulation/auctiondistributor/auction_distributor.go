@@ -1,11 +1,14 @@
 package auctiondistributor
 
 import (
+	"crypto/tls"
 	"fmt"
 	"time"
 
 	"github.com/cheggaaa/pb"
 	"github.com/onsi/auction/auctioneer"
+	"github.com/onsi/auction/communication"
+	"github.com/onsi/auction/communication/nats/auctioneernatsclient"
 	"github.com/onsi/auction/simulation/visualization"
 	"github.com/onsi/auction/types"
 )
@@ -14,13 +17,18 @@ type AuctionCommunicator func(types.AuctionRequest) types.AuctionResult
 
 type AuctionDistributor struct {
 	client        types.TestRepPoolClient
+	wire          communication.WireCounted
 	communicator  AuctionCommunicator
 	maxConcurrent int
 }
 
-func NewInProcessAuctionDistributor(client types.TestRepPoolClient, maxConcurrent int) *AuctionDistributor {
+// NewInProcessAuctionDistributor holds auctions in this process.  When wire
+// (which may be nil) is the client's transport, reports say how many bytes
+// the auctions put on the wire.
+func NewInProcessAuctionDistributor(client types.TestRepPoolClient, wire communication.WireCounted, maxConcurrent int) *AuctionDistributor {
 	return &AuctionDistributor{
 		client:        client,
+		wire:          wire,
 		maxConcurrent: maxConcurrent,
 		communicator: func(auctionRequest types.AuctionRequest) types.AuctionResult {
 			return auctioneer.Auction(client, auctionRequest)
@@ -28,11 +36,29 @@ func NewInProcessAuctionDistributor(client types.TestRepPoolClient, maxConcurren
 	}
 }
 
-func NewRemoteAuctionDistributor(hosts []string, client types.TestRepPoolClient, maxConcurrent int) *AuctionDistributor {
+// NewRemoteAuctionDistributor submits auctions to the auctioneers at hosts over
+// http, or over https with a tlsConfig, giving each one timeout to be held.
+// Both tlsConfig and signer may be nil.  Reports add up the bytes the
+// auctioneers say (at /wire) their clients put on the wire, so they are only
+// right when no one else is holding auctions with them.
+func NewRemoteAuctionDistributor(hosts []string, tlsConfig *tls.Config, timeout time.Duration, signer *communication.Signer, client types.TestRepPoolClient, maxConcurrent int) *AuctionDistributor {
+	remoteAuctions := newHttpRemoteAuctions(hosts, tlsConfig, timeout, signer)
 	return &AuctionDistributor{
 		client:        client,
+		wire:          remoteAuctions,
 		maxConcurrent: maxConcurrent,
-		communicator:  newHttpRemoteAuctions(hosts).RemoteAuction,
+		communicator:  remoteAuctions.RemoteAuction,
+	}
+}
+
+// NewNATSRemoteAuctionDistributor submits auctions over NATS, leaving it to
+// pick which auctioneer holds each one.  It doesn't know the auctioneers, so
+// its reports can't say how many bytes they put on the wire.
+func NewNATSRemoteAuctionDistributor(auctioneerClient *auctioneernatsclient.AuctioneerNatsClient, client types.TestRepPoolClient, maxConcurrent int) *AuctionDistributor {
+	return &AuctionDistributor{
+		client:        client,
+		maxConcurrent: maxConcurrent,
+		communicator:  newNATSRemoteAuctions(auctioneerClient).RemoteAuction,
 	}
 }
 
@@ -40,6 +66,11 @@ func (ad *AuctionDistributor) HoldAuctionsFor(instances []types.Instance, repres
 	fmt.Printf("\nStarting Auctions\n\n")
 	bar := pb.StartNew(len(instances))
 
+	var wireBefore communication.WireBytes
+	if ad.wire != nil {
+		wireBefore = ad.wire.WireBytes()
+	}
+
 	t := time.Now()
 	semaphore := make(chan bool, ad.maxConcurrent)
 	c := make(chan types.AuctionResult)
@@ -66,11 +97,21 @@ func (ad *AuctionDistributor) HoldAuctionsFor(instances []types.Instance, repres
 	bar.Finish()
 
 	duration := time.Since(t)
+
+	var wireBytes *communication.WireBytes
+	if ad.wire != nil {
+		measured := ad.wire.WireBytes().Since(wireBefore)
+		wireBytes = &measured
+	}
+
+	instancesByRep, unresponsiveReps := visualization.FetchAndSortInstances(ad.client, representatives)
 	report := &visualization.Report{
-		RepGuids:        representatives,
-		AuctionResults:  results,
-		InstancesByRep:  visualization.FetchAndSortInstances(ad.client, representatives),
-		AuctionDuration: duration,
+		RepGuids:         representatives,
+		AuctionResults:   results,
+		InstancesByRep:   instancesByRep,
+		UnresponsiveReps: unresponsiveReps,
+		AuctionDuration:  duration,
+		WireBytes:        wireBytes,
 	}
 
 	return report
@@ -2,28 +2,59 @@ package auctiondistributor
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"time"
 
+	"github.com/onsi/auction/communication"
 	"github.com/onsi/auction/types"
 	"github.com/onsi/auction/util"
 )
 
 type httpRemoteAuctions struct {
-	hosts []string
+	hosts   []string
+	scheme  string
+	client  *http.Client
+	timeout time.Duration
+	signer  *communication.Signer
 }
 
-func newHttpRemoteAuctions(hosts []string) *httpRemoteAuctions {
-	return &httpRemoteAuctions{hosts}
+func newHttpRemoteAuctions(hosts []string, tlsConfig *tls.Config, timeout time.Duration, signer *communication.Signer) *httpRemoteAuctions {
+	if tlsConfig == nil {
+		return &httpRemoteAuctions{hosts: hosts, scheme: "http", client: http.DefaultClient, timeout: timeout, signer: signer}
+	}
+
+	return &httpRemoteAuctions{
+		hosts:   hosts,
+		scheme:  "https",
+		client:  &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}},
+		timeout: timeout,
+		signer:  signer,
+	}
 }
 
 func (h *httpRemoteAuctions) RemoteAuction(auctionRequest types.AuctionRequest) types.AuctionResult {
 	host := h.hosts[util.R.Intn(len(h.hosts))]
 
 	payload, _ := json.Marshal(auctionRequest)
-	res, err := http.Post("http://"+host+"/auction", "application/json", bytes.NewReader(payload))
+	envelope := h.signer.Sign("", communication.AuctionSubject, communication.Envelope{
+		Version:     communication.ProtocolVersion,
+		RequestID:   util.RandomGuid(),
+		Deadline:    time.Now().Add(h.timeout),
+		ContentType: communication.JSON.ContentType(),
+		Body:        payload,
+	})
+
+	req, _ := http.NewRequest("POST", h.scheme+"://"+host+"/auction", bytes.NewReader(payload))
+	req.Header.Set("Content-Type", envelope.ContentType)
+	for key, value := range envelope.Headers() {
+		req.Header.Set(key, value)
+	}
+
+	res, err := h.client.Do(req)
 	if err != nil {
 		fmt.Println("FAILED! TO AUCTION", err)
 		return types.AuctionResult{
@@ -38,7 +69,45 @@ func (h *httpRemoteAuctions) RemoteAuction(auctionRequest types.AuctionRequest)
 	}
 
 	var result types.AuctionResult
-	json.Unmarshal(data, &result)
+	reply := communication.EnvelopeFromHeaders(res.Header.Get("Content-Type"), res.Header.Get, data)
+	err = h.signer.Verify("", communication.AuctionSubject, reply)
+	if err == nil {
+		err = reply.Decode(&result)
+	}
+	if err != nil {
+		fmt.Println("FAILED! TO AUCTION", err)
+		return types.AuctionResult{
+			Instance: auctionRequest.Instance,
+		}
+	}
 
 	return result
 }
+
+// WireBytes adds up the bytes every auctioneer has counted.  Auctioneers that
+// can't say count for nothing.
+func (h *httpRemoteAuctions) WireBytes() communication.WireBytes {
+	var total communication.WireBytes
+	for _, host := range h.hosts {
+		res, err := h.client.Get(h.scheme + "://" + host + "/wire")
+		if err != nil {
+			fmt.Println("FAILED! TO COUNT BYTES", err)
+			continue
+		}
+
+		var counted communication.WireBytes
+		err = json.NewDecoder(res.Body).Decode(&counted)
+		res.Body.Close()
+		if err != nil {
+			fmt.Println("FAILED! TO COUNT BYTES", err)
+			continue
+		}
+
+		total.Sent += counted.Sent
+		total.Received += counted.Received
+		total.SentAsJSON += counted.SentAsJSON
+		total.ReceivedAsJSON += counted.ReceivedAsJSON
+	}
+
+	return total
+}
@@ -0,0 +1,28 @@
+package auctiondistributor
+
+import (
+	"fmt"
+
+	"github.com/onsi/auction/communication/nats/auctioneernatsclient"
+	"github.com/onsi/auction/types"
+)
+
+type natsRemoteAuctions struct {
+	client *auctioneernatsclient.AuctioneerNatsClient
+}
+
+func newNATSRemoteAuctions(client *auctioneernatsclient.AuctioneerNatsClient) *natsRemoteAuctions {
+	return &natsRemoteAuctions{client}
+}
+
+func (n *natsRemoteAuctions) RemoteAuction(auctionRequest types.AuctionRequest) types.AuctionResult {
+	result, err := n.client.Auction(auctionRequest)
+	if err != nil {
+		fmt.Println("FAILED! TO AUCTION", err)
+		return types.AuctionResult{
+			Instance: auctionRequest.Instance,
+		}
+	}
+
+	return result
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -10,36 +11,88 @@ import (
 	"time"
 
 	"github.com/cloudfoundry/yagnats"
-	"github.com/onsi/auction/auctioneer"
+	"github.com/onsi/auction/communication"
+	"github.com/onsi/auction/communication/circuitbreaker"
+	"github.com/onsi/auction/communication/http/auctioneerhttpserver"
+	"github.com/onsi/auction/communication/http/rephttpclient"
+	"github.com/onsi/auction/communication/nats/auctioneernatsserver"
+	"github.com/onsi/auction/communication/nats/natsnamespace"
 	"github.com/onsi/auction/communication/nats/repnatsclient"
 	"github.com/onsi/auction/communication/rabbit/reprabbitclient"
+	"github.com/onsi/auction/communication/unix/repunixclient"
 	"github.com/onsi/auction/types"
 )
 
 var natsAddrs = flag.String("natsAddrs", "", "nats server addresses")
 var rabbitAddr = flag.String("rabbitAddr", "", "rabbit server addresses")
+var repHttpAddrs = flag.String("repHttpAddrs", "", "comma separated guid=host:port http addresses of the reps")
+var repUnixSockets = flag.String("repUnixSockets", "", "comma separated guid=path unix sockets of reps on this host")
+var codecName = flag.String("codec", "json", "one of json, msgpack: the encoding used when talking to reps")
 var timeout = flag.Duration("timeout", 500*time.Millisecond, "timeout for entire auction")
-var maxConcurrent = flag.Int("maxConcurrent", 1000, "number of concurrent auctions to hold")
+var maxConcurrent = flag.Int("maxConcurrent", 1000, "number of concurrent auctions to hold over http, and again over nats")
 var httpAddr = flag.String("httpAddr", "0.0.0.0:48710", "http address to listen on")
+var circuitBreaker = flag.Bool("circuitBreaker", false, "stop asking reps that keep timing out to bid; their breakers are served at /breakers")
+var signingKey = flag.String("signingKey", "", "key shared with the reps and other auctioneers; requests and replies are signed with it")
+var tlsCACert = flag.String("tlsCACert", "", "PEM file of the CA that the rabbit broker's (and, with -natsTLS, the nats servers') certificates must chain to")
+var natsTLS = flag.Bool("natsTLS", false, "dial the nats servers over TLS")
+var tlsCert = flag.String("tlsCert", "", "PEM certificate to serve auctions over https with")
+var tlsKey = flag.String("tlsKey", "", "PEM key for -tlsCert")
+var namespaceName = flag.String("namespace", "", "cluster namespace: only reps and auctioneers in the same namespace on nats or rabbit are reached")
 
 var errorResponse = []byte("error")
 
 func main() {
 	flag.Parse()
 
-	if *natsAddrs == "" && *rabbitAddr == "" {
-		panic("need nats or rabbit addr")
+	numModes := 0
+	for _, addr := range []string{*natsAddrs, *rabbitAddr, *repHttpAddrs, *repUnixSockets} {
+		if addr != "" {
+			numModes++
+		}
+	}
+
+	if numModes == 0 {
+		panic("need nats, rabbit, rep http addrs, or rep unix sockets")
 	}
 
-	if *natsAddrs != "" && *rabbitAddr != "" {
-		panic("can't have both nats and rabbit addrs, choose one")
+	if numModes > 1 {
+		panic("can't have more than one of nats, rabbit, rep http addrs, and rep unix sockets, choose one")
 	}
 
 	if *httpAddr == "" {
 		panic("need http addr")
 	}
 
+	codec, err := communication.CodecNamed(*codecName)
+	if err != nil {
+		panic("unknown codec: " + *codecName)
+	}
+
+	tlsConfig, err := communication.TLSConfig(*tlsCACert, *tlsCert, *tlsKey)
+	if err != nil {
+		log.Fatalln("bad tls config:", err)
+	}
+
+	var natsTLSConfig *tls.Config
+	if *natsTLS {
+		natsTLSConfig = &tls.Config{}
+		if tlsConfig != nil {
+			natsTLSConfig = tlsConfig
+		}
+	}
+
+	var signer *communication.Signer
+	if *signingKey != "" {
+		signer = communication.NewSigner([]byte(*signingKey))
+	}
+
+	namespace, err := communication.ParseNamespace(*namespaceName)
+	if err != nil {
+		log.Fatalln("bad namespace:", err)
+	}
+
 	var repClient types.RepPoolClient
+	var natsClient yagnats.NATSClient
 
 	if *natsAddrs != "" {
 		client := yagnats.NewClient()
@@ -48,7 +101,8 @@ func main() {
 
 		for _, addr := range strings.Split(*natsAddrs, ",") {
 			clusterInfo.Members = append(clusterInfo.Members, &yagnats.ConnectionInfo{
-				Addr: addr,
+				Addr:      addr,
+				TLSConfig: natsTLSConfig,
 			})
 		}
 
@@ -58,35 +112,77 @@ func main() {
 			log.Fatalln("no nats:", err)
 		}
 
-		repClient = repnatsclient.New(client, *timeout)
+		natsClient = natsnamespace.New(client, namespace)
+		repClient = repnatsclient.New(natsClient, *timeout, codec, signer)
 	}
 
 	if *rabbitAddr != "" {
-		repClient = reprabbitclient.New(*rabbitAddr, *timeout)
+		repClient, err = reprabbitclient.New(*rabbitAddr, tlsConfig, namespace, *timeout, codec, signer)
+		if err != nil {
+			log.Fatalln("no rabbit:", err)
+		}
 	}
 
-	semaphore := make(chan bool, *maxConcurrent)
+	if *repHttpAddrs != "" {
+		repClient = rephttpclient.New(byGuid(*repHttpAddrs, "rep http addr"), *timeout, codec, signer)
+	}
 
-	http.HandleFunc("/auction", func(w http.ResponseWriter, r *http.Request) {
-		semaphore <- true
-		defer func() {
-			<-semaphore
-		}()
+	if *repUnixSockets != "" {
+		repClient = repunixclient.New(byGuid(*repUnixSockets, "rep unix socket"), *timeout, codec, signer)
+	}
 
-		var auctionRequest types.AuctionRequest
-		err := json.NewDecoder(r.Body).Decode(&auctionRequest)
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			return
+	//the transports' own clients count their bytes, so look before wrapping them
+	if counted, ok := repClient.(communication.WireCounted); ok {
+		http.HandleFunc("/wire", func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(counted.WireBytes())
+		})
+	}
+
+	if *circuitBreaker {
+		var breakers *circuitbreaker.Client
+		if broadcaster, ok := repClient.(types.BroadcastRepPoolClient); ok {
+			broadcastBreakers := circuitbreaker.NewBroadcast(broadcaster, circuitbreaker.DefaultConfig)
+			breakers, repClient = broadcastBreakers.Client, broadcastBreakers
+		} else {
+			breakers = circuitbreaker.New(repClient, circuitbreaker.DefaultConfig)
+			repClient = breakers
 		}
 
-		auctionResult := auctioneer.Auction(repClient, auctionRequest)
+		http.HandleFunc("/breakers", func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(breakers.Statuses())
+		})
+	}
+
+	http.Handle("/auction", auctioneerhttpserver.Handler(repClient, *maxConcurrent, signer))
+
+	if natsClient != nil {
+		_, err := auctioneernatsserver.Serve(natsClient, repClient, *maxConcurrent, signer)
+		if err != nil {
+			log.Fatalln("can't take auctions over nats:", err)
+		}
+	}
 
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(auctionResult)
-	})
+	if *tlsCert != "" {
+		server := &http.Server{Addr: *httpAddr, TLSConfig: tlsConfig}
+		fmt.Println("auctioneering")
+		panic(server.ListenAndServeTLS("", ""))
+	}
 
 	fmt.Println("auctioneering")
 
 	panic(http.ListenAndServe(*httpAddr, nil))
 }
+
+// byGuid parses a comma separated list of guid=value pairs
+func byGuid(list string, what string) map[string]string {
+	values := map[string]string{}
+	for _, guidAndValue := range strings.Split(list, ",") {
+		components := strings.SplitN(guidAndValue, "=", 2)
+		if len(components) != 2 {
+			panic("invalid " + what + ": " + guidAndValue)
+		}
+		values[components[0]] = components[1]
+	}
+
+	return values
+}
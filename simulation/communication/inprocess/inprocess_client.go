@@ -1,64 +1,71 @@
 package inprocess
 
 import (
+	"errors"
 	"time"
 
 	"github.com/onsi/auction/auctionrep"
 	"github.com/onsi/auction/types"
-	"github.com/onsi/auction/util"
 )
 
-var LatencyMin time.Duration
-var LatencyMax time.Duration
-var Timeout time.Duration
+var UnknownRepError = errors.New("unknown rep")
 
+// InprocessClient calls straight into the reps.  It has no latency of its own:
+// wrap it in a faults.Client to simulate a network.
 type InprocessClient struct {
-	reps map[string]*auctionrep.AuctionRep
+	reps    map[string]*auctionrep.AuctionRep
+	timeout time.Duration
 }
 
-func New(reps map[string]*auctionrep.AuctionRep) *InprocessClient {
+func New(reps map[string]*auctionrep.AuctionRep, timeout time.Duration) *InprocessClient {
 	return &InprocessClient{
-		reps: reps,
+		reps:    reps,
+		timeout: timeout,
 	}
 }
 
-func randomSleep(min time.Duration, max time.Duration, timeout time.Duration) bool {
-	sleepDuration := time.Duration(util.R.Float64()*float64(max-min) + float64(min))
-	if sleepDuration <= timeout {
-		time.Sleep(sleepDuration)
-		return true
-	} else {
-		time.Sleep(timeout)
-		return false
+// reps that aren't in the pool behave like reps that never answer: batched
+// requests to them time out
+func (client *InprocessClient) unknown(guid string) bool {
+	_, ok := client.reps[guid]
+	if !ok {
+		time.Sleep(client.timeout)
 	}
+	return !ok
 }
 
-func (client *InprocessClient) beSlowAndPossiblyTimeout(guid string) bool {
-	sleepDuration := time.Duration(util.R.Float64()*float64(LatencyMax-LatencyMin) + float64(LatencyMin))
-
-	if sleepDuration <= Timeout {
-		time.Sleep(sleepDuration)
-		return false
-	} else {
-		time.Sleep(Timeout)
-		return true
+func (client *InprocessClient) TotalResources(guid string) (types.Resources, error) {
+	rep, ok := client.reps[guid]
+	if !ok {
+		return types.Resources{}, UnknownRepError
 	}
+	return rep.TotalResources(), nil
 }
 
-func (client *InprocessClient) TotalResources(guid string) types.Resources {
-	return client.reps[guid].TotalResources()
-}
-
-func (client *InprocessClient) Instances(guid string) []types.Instance {
-	return client.reps[guid].Instances()
+func (client *InprocessClient) Instances(guid string) ([]types.Instance, error) {
+	rep, ok := client.reps[guid]
+	if !ok {
+		return nil, UnknownRepError
+	}
+	return rep.Instances(), nil
 }
 
-func (client *InprocessClient) SetInstances(guid string, instances []types.Instance) {
-	client.reps[guid].SetInstances(instances)
+func (client *InprocessClient) SetInstances(guid string, instances []types.Instance) error {
+	rep, ok := client.reps[guid]
+	if !ok {
+		return UnknownRepError
+	}
+	rep.SetInstances(instances)
+	return nil
 }
 
-func (client *InprocessClient) Reset(guid string) {
-	client.reps[guid].Reset()
+func (client *InprocessClient) Reset(guid string) error {
+	rep, ok := client.reps[guid]
+	if !ok {
+		return UnknownRepError
+	}
+	rep.Reset()
+	return nil
 }
 
 func (client *InprocessClient) score(guid string, instance types.Instance, c chan types.ScoreResult) {
@@ -69,14 +76,15 @@ func (client *InprocessClient) score(guid string, instance types.Instance, c cha
 		c <- result
 	}()
 
-	if client.beSlowAndPossiblyTimeout(guid) {
-		result.Error = "timeout"
+	if client.unknown(guid) {
+		result.Error = types.Timeout
 		return
 	}
 
-	score, err := client.reps[guid].Score(instance)
+	score, generation, err := client.reps[guid].Score(instance)
+	result.Generation = generation
 	if err != nil {
-		result.Error = err.Error()
+		result.Error, result.Message = types.ScoreErrorFor(err)
 		return
 	}
 
@@ -91,7 +99,7 @@ func (client *InprocessClient) Score(representatives []string, instance types.In
 	}
 
 	results := types.ScoreResults{}
-	for _ = range representatives {
+	for range representatives {
 		results = append(results, <-c)
 	}
 
@@ -106,14 +114,14 @@ func (client *InprocessClient) reserveAndRecastScore(guid string, instance types
 		c <- result
 	}()
 
-	if client.beSlowAndPossiblyTimeout(guid) {
-		result.Error = "timedout"
+	if client.unknown(guid) {
+		result.Error = types.Timeout
 		return
 	}
 
 	score, err := client.reps[guid].ScoreThenTentativelyReserve(instance)
 	if err != nil {
-		result.Error = err.Error()
+		result.Error, result.Message = types.ScoreErrorFor(err)
 		return
 	}
 
@@ -128,7 +136,44 @@ func (client *InprocessClient) ScoreThenTentativelyReserve(guids []string, insta
 	}
 
 	results := types.ScoreResults{}
-	for _ = range guids {
+	for range guids {
+		results = append(results, <-c)
+	}
+
+	return results
+}
+
+func (client *InprocessClient) reserveIfUnchanged(guid string, generation uint64, instance types.Instance, c chan types.ScoreResult) {
+	result := types.ScoreResult{
+		Rep: guid,
+	}
+	defer func() {
+		c <- result
+	}()
+
+	if client.unknown(guid) {
+		result.Error = types.Timeout
+		return
+	}
+
+	score, err := client.reps[guid].TentativelyReserveIfUnchanged(instance, generation)
+	if err != nil {
+		result.Error, result.Message = types.ScoreErrorFor(err)
+		return
+	}
+
+	result.Score = score
+	return
+}
+
+func (client *InprocessClient) TentativelyReserveIfUnchanged(scores types.ScoreResults, instance types.Instance) types.ScoreResults {
+	c := make(chan types.ScoreResult)
+	for _, score := range scores {
+		go client.reserveIfUnchanged(score.Rep, score.Generation, instance, c)
+	}
+
+	results := types.ScoreResults{}
+	for range scores {
 		results = append(results, <-c)
 	}
 
@@ -139,19 +184,22 @@ func (client *InprocessClient) ReleaseReservation(guids []string, instance types
 	c := make(chan bool)
 	for _, guid := range guids {
 		go func(guid string) {
-			client.beSlowAndPossiblyTimeout(guid)
-			client.reps[guid].ReleaseReservation(instance)
+			if !client.unknown(guid) {
+				client.reps[guid].ReleaseReservation(instance)
+			}
 			c <- true
 		}(guid)
 	}
 
-	for _ = range guids {
+	for range guids {
 		<-c
 	}
 }
 
 func (client *InprocessClient) Claim(guid string, instance types.Instance) {
-	client.beSlowAndPossiblyTimeout(guid)
+	if client.unknown(guid) {
+		return
+	}
 
 	client.reps[guid].Claim(instance)
 }
@@ -0,0 +1,40 @@
+package inprocess_test
+
+import (
+	"time"
+
+	"github.com/onsi/auction/auctionrep"
+	"github.com/onsi/auction/communication/conformance"
+	. "github.com/onsi/auction/simulation/communication/inprocess"
+	"github.com/onsi/auction/simulation/simulationrepdelegate"
+	"github.com/onsi/auction/types"
+	. "github.com/onsi/ginkgo"
+)
+
+var _ = Describe("InprocessClient", func() {
+	var client *InprocessClient
+	timeout := 100 * time.Millisecond
+
+	BeforeEach(func() {
+		reps := map[string]*auctionrep.AuctionRep{}
+		for _, guid := range []string{"rep-a", "rep-b"} {
+			reps[guid] = auctionrep.New(guid, simulationrepdelegate.New(types.Resources{
+				MemoryMB:   100,
+				DiskMB:     100,
+				Containers: 10,
+			}))
+		}
+
+		client = New(reps, timeout)
+	})
+
+	conformance.ItBehavesLikeATestRepPoolClient(func() conformance.Fixture {
+		return conformance.Fixture{
+			Client:       client,
+			Timeout:      timeout,
+			RepResources: types.Resources{MemoryMB: 100, DiskMB: 100, Containers: 10},
+			Responsive:   []string{"rep-a", "rep-b"},
+			Unresponsive: []string{"rep-gone"},
+		}
+	})
+})
@@ -0,0 +1,13 @@
+package inprocess_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestInprocess(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Inprocess Suite")
+}
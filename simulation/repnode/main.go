@@ -1,12 +1,21 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
 	"github.com/onsi/auction/auctionrep"
+	"github.com/onsi/auction/communication"
+	"github.com/onsi/auction/communication/http/rephttpserver"
 	"github.com/onsi/auction/communication/nats/repnatsserver"
 	"github.com/onsi/auction/communication/rabbit/reprabbitserver"
+	"github.com/onsi/auction/communication/unix/repunixserver"
 	"github.com/onsi/auction/simulation/simulationrepdelegate"
 	"github.com/onsi/auction/types"
 )
@@ -17,6 +26,14 @@ var containers = flag.Int("containers", 100, "total available containers")
 var guid = flag.String("guid", "", "guid")
 var natsAddrs = flag.String("natsAddrs", "", "nats server addresses")
 var rabbitAddr = flag.String("rabbitAddr", "", "rabbit server address")
+var httpAddr = flag.String("httpAddr", "", "http address to listen on")
+var unixSocket = flag.String("unixSocket", "", "path of a unix socket to listen on, for auctioneers on the same host")
+var signingKey = flag.String("signingKey", "", "key shared with the auctioneers; requests not signed with it are refused")
+var tlsCACert = flag.String("tlsCACert", "", "PEM file of the CA that the rabbit broker's (and, with -natsTLS, the nats servers') certificates must chain to")
+var natsTLS = flag.Bool("natsTLS", false, "dial the nats servers over TLS")
+var namespaceName = flag.String("namespace", "", "cluster namespace: only auctioneers in the same namespace on nats or rabbit reach the rep")
+var maxConcurrentBids = flag.Int("maxConcurrentBids", 0, "bids (scores and reservations) to work on at once, across every transport; 0 means no limit")
+var maxQueuedBids = flag.Int("maxQueuedBids", 0, "bids to queue behind -maxConcurrentBids before answering that the rep is busy")
 
 func main() {
 	flag.Parse()
@@ -25,8 +42,8 @@ func main() {
 		panic("need guid")
 	}
 
-	if *natsAddrs == "" && *rabbitAddr == "" {
-		panic("need nats or rabbit addr")
+	if *natsAddrs == "" && *rabbitAddr == "" && *httpAddr == "" && *unixSocket == "" {
+		panic("need nats, rabbit, or http addr, or unix socket")
 	}
 
 	repDelegate := simulationrepdelegate.New(types.Resources{
@@ -36,13 +53,97 @@ func main() {
 	})
 	rep := auctionrep.New(*guid, repDelegate)
 
+	tlsConfig, err := communication.TLSConfig(*tlsCACert, "", "")
+	if err != nil {
+		log.Fatalln("bad tls config:", err)
+	}
+
+	var natsTLSConfig *tls.Config
+	if *natsTLS {
+		natsTLSConfig = &tls.Config{}
+		if tlsConfig != nil {
+			natsTLSConfig = tlsConfig
+		}
+	}
+
+	var signer *communication.Signer
+	if *signingKey != "" {
+		signer = communication.NewSigner([]byte(*signingKey))
+	}
+
+	namespace, err := communication.ParseNamespace(*namespaceName)
+	if err != nil {
+		log.Fatalln("bad namespace:", err)
+	}
+
+	var admission *communication.Admission
+	if *maxConcurrentBids != 0 {
+		admission, err = communication.NewAdmission(*maxConcurrentBids, *maxQueuedBids)
+		if err != nil {
+			log.Fatalln("bad bid limits:", err)
+		}
+	}
+
+	servers := map[string]server{}
+
 	if *natsAddrs != "" {
-		go repnatsserver.Start(strings.Split(*natsAddrs, ","), rep)
+		natsServer, err := repnatsserver.Start(strings.Split(*natsAddrs, ","), natsTLSConfig, namespace, rep, signer, admission, logStateChanges("nats"))
+		if err != nil {
+			log.Fatalln("no nats:", err)
+		}
+		servers["nats"] = natsServer
 	}
 
 	if *rabbitAddr != "" {
-		go reprabbitserver.Start(*rabbitAddr, rep)
+		rabbitServer, err := reprabbitserver.Start(*rabbitAddr, tlsConfig, namespace, rep, signer, admission, logStateChanges("rabbit"))
+		if err != nil {
+			log.Fatalln("no rabbit:", err)
+		}
+		servers["rabbit"] = rabbitServer
+	}
+
+	if *httpAddr != "" {
+		httpServer, err := rephttpserver.Start(*httpAddr, rep, signer, admission)
+		if err != nil {
+			log.Fatalln("no http:", err)
+		}
+		servers["http"] = httpServer
 	}
 
-	select {}
+	if *unixSocket != "" {
+		unixServer, err := repunixserver.Start(*unixSocket, rep, signer, admission)
+		if err != nil {
+			log.Fatalln("no unix socket:", err)
+		}
+		servers["unix"] = unixServer
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	<-signals
+
+	for _, server := range servers {
+		server.Stop()
+	}
+
+	for transport, server := range servers {
+		server.Wait()
+		fmt.Printf("[%s] %s dropped %d expired requests\n", *guid, transport, server.Expired())
+	}
+
+	if admission != nil {
+		fmt.Printf("[%s] turned away %d bids while busy\n", *guid, admission.Busy())
+	}
+}
+
+type server interface {
+	Stop()
+	Wait()
+	Expired() uint64
+}
+
+func logStateChanges(transport string) communication.StateCallback {
+	return func(state communication.ConnectionState) {
+		fmt.Printf("[%s] %s %s\n", *guid, transport, state)
+	}
 }
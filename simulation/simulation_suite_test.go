@@ -5,15 +5,26 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 
 	"github.com/cloudfoundry/gunk/natsrunner"
 	"github.com/cloudfoundry/yagnats"
 	"github.com/onsi/auction/auctioneer"
 	"github.com/onsi/auction/auctionrep"
+	"github.com/onsi/auction/communication"
+	"github.com/onsi/auction/communication/faults"
+	"github.com/onsi/auction/communication/http/rephttpclient"
+	"github.com/onsi/auction/communication/nats/auctioneernatsclient"
+	"github.com/onsi/auction/communication/nats/auctioneernatsserver"
+	"github.com/onsi/auction/communication/nats/fakenats"
+	"github.com/onsi/auction/communication/nats/natsnamespace"
 	"github.com/onsi/auction/communication/nats/repnatsclient"
+	"github.com/onsi/auction/communication/nats/repnatsserver"
 	"github.com/onsi/auction/communication/rabbit/reprabbitclient"
+	"github.com/onsi/auction/communication/unix/repunixclient"
 	"github.com/onsi/auction/simulation/auctiondistributor"
 	"github.com/onsi/auction/simulation/communication/inprocess"
 	"github.com/onsi/auction/simulation/simulationrepdelegate"
@@ -31,12 +42,24 @@ import (
 
 var communicationMode string
 var auctioneerMode string
+var codecName string
+var codec communication.Codec
+var signingKey string
+var signer *communication.Signer
+var namespaceName string
+var namespace communication.Namespace
+var maxConcurrentBids int
+var maxQueuedBids int
 
 const InProcess = "inprocess"
 const NATS = "nats"
+const FakeNATS = "fakenats"
 const Rabbit = "rabbit"
+const HTTP = "http"
+const Unix = "unix"
 const KetchupNATS = "ketchup-nats"
 const Remote = "remote"
+const RemoteNATS = "remote-nats"
 
 //these are const because they are fixed on ketchup
 const numAuctioneers = 10
@@ -50,7 +73,18 @@ var repResources = types.Resources{
 
 var maxConcurrent int
 
+//in-process reps answer within a couple of milliseconds, unless faults say otherwise
+const inProcessTimeout = 50 * time.Millisecond
+
+var latencyMin, latencyMax time.Duration
+var dropRate, duplicateRate float64
+
 var timeout time.Duration
+
+//remote auctions span several rounds of requests to the reps
+const remoteAuctionTimeout = time.Minute
+
+var auctionBus yagnats.NATSClient
 var auctionDistributor *auctiondistributor.AuctionDistributor
 
 var svgReport *visualization.SVGReport
@@ -59,19 +93,32 @@ var reports []*visualization.Report
 
 var sessionsToTerminate []*gexec.Session
 var natsRunner *natsrunner.NATSRunner
+var repSocketDir string
 var client types.TestRepPoolClient
 var guids []string
 
 func init() {
-	flag.StringVar(&communicationMode, "communicationMode", "inprocess", "one of inprocess, nats, rabbit, ketchup")
-	flag.StringVar(&auctioneerMode, "auctioneerMode", "inprocess", "one of inprocess, remote")
+	flag.StringVar(&communicationMode, "communicationMode", "inprocess", "one of inprocess, nats, fakenats, rabbit, http, unix, ketchup")
+	flag.StringVar(&auctioneerMode, "auctioneerMode", "inprocess", "one of inprocess, remote, remote-nats")
+	flag.StringVar(&codecName, "codec", "json", "one of json, msgpack: the encoding used when talking to reps")
 	flag.DurationVar(&timeout, "timeout", 500*time.Millisecond, "timeout when waiting for responses from remote calls")
+	flag.StringVar(&signingKey, "signingKey", "", "if set, sign every request and reply with this shared key")
+	flag.StringVar(&namespaceName, "namespace", "", "if set, run the reps and auctioneers in this cluster namespace")
 
 	flag.StringVar(&(auctioneer.DefaultRules.Algorithm), "algorithm", auctioneer.DefaultRules.Algorithm, "the auction algorithm to use")
 	flag.IntVar(&(auctioneer.DefaultRules.MaxRounds), "maxRounds", auctioneer.DefaultRules.MaxRounds, "the maximum number of rounds per auction")
 	flag.Float64Var(&(auctioneer.DefaultRules.MaxBiddingPool), "maxBiddingPool", auctioneer.DefaultRules.MaxBiddingPool, "the maximum number of participants in the pool")
+	flag.Float64Var(&(auctioneer.DefaultRules.ScoreQuorum), "scoreQuorum", auctioneer.DefaultRules.ScoreQuorum, "go on with a round once this fraction of the reps asked have bid (0 waits for all of them)")
+	flag.DurationVar(&(auctioneer.DefaultRules.HedgeAfter), "hedgeAfter", auctioneer.DefaultRules.HedgeAfter, "ask other reps to bid too when bids take longer than this (0 never hedges)")
 
 	flag.IntVar(&maxConcurrent, "maxConcurrent", 20, "the maximum number of concurrent auctions to run")
+	flag.IntVar(&maxConcurrentBids, "maxConcurrentBids", 0, "the maximum number of bids each rep works on at once, outside of inprocess (0 means no limit)")
+	flag.IntVar(&maxQueuedBids, "maxQueuedBids", 0, "the number of bids each rep queues beyond -maxConcurrentBids before answering that it is busy")
+
+	flag.DurationVar(&latencyMin, "latencyMin", 0, "inject at least this much latency into every request to a rep")
+	flag.DurationVar(&latencyMax, "latencyMax", 0, "inject at most this much latency into every request to a rep")
+	flag.Float64Var(&dropRate, "dropRate", 0, "drop this fraction of requests to reps, and of their replies")
+	flag.Float64Var(&duplicateRate, "duplicateRate", 0, "deliver this fraction of replies from reps twice (fakenats only)")
 }
 
 func TestAuction(t *testing.T) {
@@ -83,6 +130,21 @@ var _ = BeforeSuite(func() {
 	fmt.Printf("Running in %s communicationMode\n", communicationMode)
 	fmt.Printf("Running in %s auctioneerMode\n", auctioneerMode)
 
+	var err error
+	codec, err = communication.CodecNamed(codecName)
+	Ω(err).ShouldNot(HaveOccurred())
+
+	if signingKey != "" {
+		signer = communication.NewSigner([]byte(signingKey))
+	}
+
+	namespace, err = communication.ParseNamespace(namespaceName)
+	Ω(err).ShouldNot(HaveOccurred())
+
+	if duplicateRate > 0 && communicationMode != FakeNATS {
+		panic("replies are duplicated by the fake nats bus, so -duplicateRate needs -communicationMode=fakenats")
+	}
+
 	startReport()
 
 	sessionsToTerminate = []*gexec.Session{}
@@ -90,43 +152,99 @@ var _ = BeforeSuite(func() {
 	switch communicationMode {
 	case InProcess:
 		client, guids = buildInProcessReps()
-		if auctioneerMode == Remote {
+		if auctioneerMode != InProcess {
 			panic("it doesn't make sense to use remote auctioneers when the reps are in-process")
 		}
 	case NATS:
 		natsAddrs := startNATS()
-		client = repnatsclient.New(natsRunner.MessageBus, timeout)
-		guids = launchExternalReps("-natsAddrs", natsAddrs)
-		if auctioneerMode == Remote {
+		client = repnatsclient.New(natsnamespace.New(natsRunner.MessageBus, namespace), timeout, codec, signer)
+		guids = launchExternalReps(staticFlags("-natsAddrs", natsAddrs))
+		if auctioneerMode != InProcess {
 			hosts = launchExternalAuctioneers("-natsAddrs", natsAddrs)
+			auctionBus = natsnamespace.New(natsRunner.MessageBus, namespace)
+		}
+	case FakeNATS:
+		var bus *fakenats.Bus
+		client, guids, bus = buildFakeNATSReps()
+		if auctioneerMode == Remote {
+			panic("it doesn't make sense to use remote http auctioneers when the reps are on an in-process bus")
+		}
+		if auctioneerMode == RemoteNATS {
+			serveFakeNATSAuctioneers(bus)
+			auctionBus = newFakeNATSClient(bus)
 		}
 	case Rabbit:
 		rabbitAddr := startRabbit()
-		client = reprabbitclient.New(rabbitAddr, timeout)
-		guids = launchExternalReps("-rabbitAddr", rabbitAddr)
+		client, err = reprabbitclient.New(rabbitAddr, nil, namespace, timeout, codec, signer)
+		Ω(err).ShouldNot(HaveOccurred())
+		guids = launchExternalReps(staticFlags("-rabbitAddr", rabbitAddr))
 		if auctioneerMode == Remote {
 			hosts = launchExternalAuctioneers("-rabbitAddr", rabbitAddr)
 		}
+	case HTTP:
+		repAddrs := map[string]string{}
+		guids = launchExternalReps(func(guid string, index int) []string {
+			repAddrs[guid] = fmt.Sprintf("127.0.0.1:%d", 49000+index)
+			return []string{"-httpAddr", repAddrs[guid]}
+		})
+		client = rephttpclient.New(repAddrs, timeout, codec, signer)
+		if auctioneerMode == Remote {
+			guidsAndAddrs := []string{}
+			for guid, addr := range repAddrs {
+				guidsAndAddrs = append(guidsAndAddrs, guid+"="+addr)
+			}
+			hosts = launchExternalAuctioneers("-repHttpAddrs", strings.Join(guidsAndAddrs, ","))
+		}
+	case Unix:
+		repSocketDir, err = ioutil.TempDir("", "auction-reps")
+		Ω(err).ShouldNot(HaveOccurred())
+		repSockets := map[string]string{}
+		guids = launchExternalReps(func(guid string, index int) []string {
+			repSockets[guid] = filepath.Join(repSocketDir, guid+".sock")
+			return []string{"-unixSocket", repSockets[guid]}
+		})
+		client = repunixclient.New(repSockets, timeout, codec, signer)
+		if auctioneerMode == Remote {
+			guidsAndSockets := []string{}
+			for guid, socketPath := range repSockets {
+				guidsAndSockets = append(guidsAndSockets, guid+"="+socketPath)
+			}
+			hosts = launchExternalAuctioneers("-repUnixSockets", strings.Join(guidsAndSockets, ","))
+		}
 	case KetchupNATS:
 		guids = computeKetchupGuids()
-		client = ketchupNATSClient()
+		natsClient := natsnamespace.New(connectToKetchupNATS(), namespace)
+		client = repnatsclient.New(natsClient, timeout, codec, signer)
 		if auctioneerMode == Remote {
 			hosts = ketchupAuctioneerHosts()
 		}
+		if auctioneerMode == RemoteNATS {
+			auctionBus = natsClient
+		}
 	default:
 		panic(fmt.Sprintf("unknown communication mode: %s", communicationMode))
 	}
 
+	if auctioneerMode == RemoteNATS && auctionBus == nil {
+		panic("remote-nats auctioneers need the reps to be on nats")
+	}
+
+	//only the transports' own clients count their bytes, so look before wrapping them
+	wire, _ := client.(communication.WireCounted)
+	client = injectFaults(client)
+
 	if auctioneerMode == InProcess {
-		auctionDistributor = auctiondistributor.NewInProcessAuctionDistributor(client, maxConcurrent)
+		auctionDistributor = auctiondistributor.NewInProcessAuctionDistributor(client, wire, maxConcurrent)
 	} else if auctioneerMode == Remote {
-		auctionDistributor = auctiondistributor.NewRemoteAuctionDistributor(hosts, client, maxConcurrent)
+		auctionDistributor = auctiondistributor.NewRemoteAuctionDistributor(hosts, nil, remoteAuctionTimeout, signer, client, maxConcurrent)
+	} else if auctioneerMode == RemoteNATS {
+		auctionDistributor = auctiondistributor.NewNATSRemoteAuctionDistributor(auctioneernatsclient.New(auctionBus, remoteAuctionTimeout, codec, signer), client, maxConcurrent)
 	}
 })
 
 var _ = BeforeEach(func() {
 	for _, guid := range guids {
-		client.Reset(guid)
+		Ω(client.Reset(guid)).ShouldNot(HaveOccurred())
 	}
 
 	util.ResetGuids()
@@ -142,13 +260,13 @@ var _ = AfterSuite(func() {
 	if natsRunner != nil {
 		natsRunner.Stop()
 	}
+
+	if repSocketDir != "" {
+		os.RemoveAll(repSocketDir)
+	}
 })
 
 func buildInProcessReps() (types.TestRepPoolClient, []string) {
-	inprocess.LatencyMin = 1 * time.Millisecond
-	inprocess.LatencyMax = 2 * time.Millisecond
-	inprocess.Timeout = 50 * time.Millisecond
-
 	guids := []string{}
 	repMap := map[string]*auctionrep.AuctionRep{}
 
@@ -160,10 +278,78 @@ func buildInProcessReps() (types.TestRepPoolClient, []string) {
 		repMap[guid] = auctionrep.New(guid, repDelegate)
 	}
 
-	client := inprocess.New(repMap)
+	client := faults.NewTest(inprocess.New(repMap, inProcessTimeout), inProcessTimeout)
+	client.Inject("*", faults.Faults{Latency: faults.Uniform(1*time.Millisecond, 2*time.Millisecond)})
 	return client, guids
 }
 
+// injectFaults applies the fault flags to every rep.  Remote auctioneers have
+// clients of their own, so only in-process auctions see the faults.
+func injectFaults(client types.TestRepPoolClient) types.TestRepPoolClient {
+	if latencyMax == 0 && dropRate == 0 {
+		return client
+	}
+
+	faultyClient, ok := client.(*faults.TestClient)
+	if !ok {
+		faultyClient = faults.NewTest(client, timeout)
+	}
+
+	injected := faults.Faults{
+		DropRequests: dropRate,
+		DropReplies:  dropRate,
+	}
+	if latencyMax > 0 {
+		injected.Latency = faults.Uniform(latencyMin, latencyMax)
+	}
+	faultyClient.Inject("*", injected)
+
+	return faultyClient
+}
+
+// buildFakeNATSReps runs the reps' nats servers and the client over an
+// in-process bus, so the whole nats stack is exercised without gnatsd.
+func buildFakeNATSReps() (types.TestRepPoolClient, []string, *fakenats.Bus) {
+	bus := fakenats.NewBus()
+	bus.DuplicateReplies(duplicateRate)
+	guids := []string{}
+
+	for i := 0; i < numReps; i++ {
+		guid := util.NewGuid("REP")
+		guids = append(guids, guid)
+
+		_, err := repnatsserver.Serve(newFakeNATSClient(bus), auctionrep.New(guid, simulationrepdelegate.New(repResources)), signer, newAdmission(), nil)
+		Ω(err).ShouldNot(HaveOccurred())
+	}
+
+	return repnatsclient.New(newFakeNATSClient(bus), timeout, codec, signer), guids, bus
+}
+
+// newAdmission gives a rep its own admission, or nil when bids are unlimited.
+func newAdmission() *communication.Admission {
+	if maxConcurrentBids == 0 {
+		return nil
+	}
+
+	admission, err := communication.NewAdmission(maxConcurrentBids, maxQueuedBids)
+	Ω(err).ShouldNot(HaveOccurred())
+
+	return admission
+}
+
+func newFakeNATSClient(bus *fakenats.Bus) yagnats.NATSClient {
+	return natsnamespace.New(bus.NewClient(), namespace)
+}
+
+// serveFakeNATSAuctioneers runs auctioneers on the bus, each with a client of
+// its own, as auctioneernode does on a real one.
+func serveFakeNATSAuctioneers(bus *fakenats.Bus) {
+	for i := 0; i < numAuctioneers; i++ {
+		_, err := auctioneernatsserver.Serve(newFakeNATSClient(bus), repnatsclient.New(newFakeNATSClient(bus), timeout, codec, signer), maxConcurrent, signer)
+		Ω(err).ShouldNot(HaveOccurred())
+	}
+}
+
 func startNATS() string {
 	natsPort := 5222 + GinkgoParallelNode()
 	natsAddrs := []string{fmt.Sprintf("127.0.0.1:%d", natsPort)}
@@ -180,7 +366,13 @@ func startRabbit() string {
 	return "amqp://127.0.0.1"
 }
 
-func launchExternalReps(communicationFlag string, communicationValue string) []string {
+func staticFlags(flags ...string) func(string, int) []string {
+	return func(string, int) []string {
+		return flags
+	}
+}
+
+func launchExternalReps(communicationFlags func(guid string, index int) []string) []string {
 	repNodeBinary, err := gexec.Build("github.com/onsi/auction/simulation/repnode")
 	Ω(err).ShouldNot(HaveOccurred())
 
@@ -189,14 +381,24 @@ func launchExternalReps(communicationFlag string, communicationValue string) []s
 	for i := 0; i < numReps; i++ {
 		guid := util.NewGuid("REP")
 
-		serverCmd := exec.Command(
-			repNodeBinary,
+		args := []string{
 			"-guid", guid,
-			communicationFlag, communicationValue,
 			"-memoryMB", fmt.Sprintf("%f", repResources.MemoryMB),
 			"-diskMB", fmt.Sprintf("%f", repResources.DiskMB),
 			"-containers", fmt.Sprintf("%d", repResources.Containers),
-		)
+		}
+		args = append(args, communicationFlags(guid, i)...)
+		if signingKey != "" {
+			args = append(args, "-signingKey", signingKey)
+		}
+		if namespaceName != "" {
+			args = append(args, "-namespace", namespaceName)
+		}
+		if maxConcurrentBids != 0 {
+			args = append(args, "-maxConcurrentBids", fmt.Sprintf("%d", maxConcurrentBids), "-maxQueuedBids", fmt.Sprintf("%d", maxQueuedBids))
+		}
+
+		serverCmd := exec.Command(repNodeBinary, args...)
 
 		sess, err := gexec.Start(serverCmd, GinkgoWriter, GinkgoWriter)
 		Ω(err).ShouldNot(HaveOccurred())
@@ -216,12 +418,19 @@ func launchExternalAuctioneers(communicationFlag string, communicationValue stri
 	auctioneerHosts := []string{}
 	for i := 0; i < numAuctioneers; i++ {
 		port := 48710 + i
-		auctioneerCmd := exec.Command(
-			auctioneerNodeBinary,
+		args := []string{
 			communicationFlag, communicationValue,
 			"-timeout", fmt.Sprintf("%s", timeout),
+			"-codec", codecName,
 			"-httpAddr", fmt.Sprintf("127.0.0.1:%d", port),
-		)
+		}
+		if signingKey != "" {
+			args = append(args, "-signingKey", signingKey)
+		}
+		if namespaceName != "" {
+			args = append(args, "-namespace", namespaceName)
+		}
+		auctioneerCmd := exec.Command(auctioneerNodeBinary, args...)
 		auctioneerHosts = append(auctioneerHosts, fmt.Sprintf("127.0.0.1:%d", port))
 
 		sess, err := gexec.Start(auctioneerCmd, GinkgoWriter, GinkgoWriter)
@@ -261,7 +470,7 @@ func ketchupAuctioneerHosts() []string {
 	}
 }
 
-func ketchupNATSClient() types.TestRepPoolClient {
+func connectToKetchupNATS() yagnats.NATSClient {
 	natsAddrs := []string{
 		"10.10.50.20:4222",
 		"10.10.114.20:4222",
@@ -279,7 +488,7 @@ func ketchupNATSClient() types.TestRepPoolClient {
 	err := natsClient.Connect(clusterInfo)
 	Ω(err).ShouldNot(HaveOccurred())
 
-	return repnatsclient.New(natsClient, timeout)
+	return natsClient
 }
 
 func startReport() {
@@ -68,7 +68,7 @@ var _ = Describe("Auction", func() {
 
 	JustBeforeEach(func() {
 		for index, instances := range initialDistributions {
-			client.SetInstances(guids[index], instances)
+			Ω(client.SetInstances(guids[index], instances)).ShouldNot(HaveOccurred())
 		}
 	})
 
@@ -98,7 +98,7 @@ var _ = Describe("Auction", func() {
 
 						report := auctionDistributor.HoldAuctionsFor(instances, guids[:nexec[i]], auctioneer.DefaultRules)
 
-						visualization.PrintReport(client, report.AuctionResults, guids[:nexec[i]], report.AuctionDuration, auctioneer.DefaultRules)
+						visualization.PrintReport(client, report.AuctionResults, guids[:nexec[i]], report.AuctionDuration, auctioneer.DefaultRules, report.WireBytes)
 
 						svgReport.DrawReportCard(i, 0, report)
 						reports = append(reports, report)
@@ -127,7 +127,7 @@ var _ = Describe("Auction", func() {
 
 						report := auctionDistributor.HoldAuctionsFor(instances, guids[:nexec[i]], auctioneer.DefaultRules)
 
-						visualization.PrintReport(client, report.AuctionResults, guids[:nexec[i]], report.AuctionDuration, auctioneer.DefaultRules)
+						visualization.PrintReport(client, report.AuctionResults, guids[:nexec[i]], report.AuctionDuration, auctioneer.DefaultRules, report.WireBytes)
 
 						svgReport.DrawReportCard(i, 1, report)
 						reports = append(reports, report)
@@ -155,7 +155,7 @@ var _ = Describe("Auction", func() {
 
 						report := auctionDistributor.HoldAuctionsFor(instances, guids[:nexec[i]], auctioneer.DefaultRules)
 
-						visualization.PrintReport(client, report.AuctionResults, guids[:nexec[i]], report.AuctionDuration, auctioneer.DefaultRules)
+						visualization.PrintReport(client, report.AuctionResults, guids[:nexec[i]], report.AuctionDuration, auctioneer.DefaultRules, report.WireBytes)
 
 						svgReport.DrawReportCard(i, 2, report)
 						reports = append(reports, report)
@@ -2,10 +2,12 @@ package visualization
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
-	"github.com/onsi/auction/simulation/communication/inprocess"
+	"github.com/onsi/auction/communication"
+	"github.com/onsi/auction/communication/faults"
 	"github.com/onsi/auction/types"
 )
 
@@ -19,7 +21,9 @@ const grayColor = "\x1b[90m"
 const lightGrayColor = "\x1b[37m"
 const purpleColor = "\x1b[35m"
 
-func PrintReport(client types.TestRepPoolClient, results []types.AuctionResult, representatives []string, duration time.Duration, rules types.AuctionRules) {
+// PrintReport prints a summary of the auctions.  wire is what the auctions put
+// on the wire, or nil if that wasn't measured.
+func PrintReport(client types.TestRepPoolClient, results []types.AuctionResult, representatives []string, duration time.Duration, rules types.AuctionRules, wire *communication.WireBytes) {
 	roundsDistribution := map[int]int{}
 	auctionedInstances := map[string]bool{}
 
@@ -48,11 +52,17 @@ func PrintReport(client types.TestRepPoolClient, results []types.AuctionResult,
 	guidFormat := fmt.Sprintf("%%%ds", maxGuidLength)
 
 	numNew := 0
+	unresponsiveReps := []string{}
 	for _, guid := range representatives {
 		repString := fmt.Sprintf(guidFormat, guid)
 
 		instanceString := ""
-		instances := client.Instances(guid)
+		instances, err := client.Instances(guid)
+		if err != nil {
+			unresponsiveReps = append(unresponsiveReps, guid)
+			fmt.Printf("  %s: %s!!!!UNRESPONSIVE!!!! %s%s\n", repString, redColor, err, defaultStyle)
+			continue
+		}
 
 		availableColors := []string{"red", "cyan", "yellow", "gray", "purple", "green"}
 		colorLookup := map[string]string{"red": redColor, "green": greenColor, "cyan": cyanColor, "yellow": yellowColor, "gray": lightGrayColor, "purple": purpleColor}
@@ -75,7 +85,10 @@ func PrintReport(client types.TestRepPoolClient, results []types.AuctionResult,
 			instanceString += strings.Repeat(colorLookup[col]+"○"+defaultStyle, originalCounts[col])
 			instanceString += strings.Repeat(colorLookup[col]+"●"+defaultStyle, newCounts[col])
 		}
-		instanceString += strings.Repeat(grayColor+"○"+defaultStyle, client.TotalResources(guid).Containers-len(instances))
+		totalResources, err := client.TotalResources(guid)
+		if err == nil {
+			instanceString += strings.Repeat(grayColor+"○"+defaultStyle, totalResources.Containers-len(instances))
+		}
 
 		fmt.Printf("  %s: %s\n", repString, instanceString)
 	}
@@ -85,9 +98,27 @@ func PrintReport(client types.TestRepPoolClient, results []types.AuctionResult,
 		expected := len(auctionedInstances)
 		fmt.Printf("  %s!!!!MISSING INSTANCES!!!!  Expected %d, got %d (%.3f %% failure rate)%s", redColor, expected, numNew, float64(expected-numNew)/float64(expected), defaultStyle)
 	}
+	if len(unresponsiveReps) > 0 {
+		fmt.Printf("  %s!!!!UNRESPONSIVE REPS!!!!  %d reps did not report their instances: %s%s\n", redColor, len(unresponsiveReps), strings.Join(unresponsiveReps, ", "), defaultStyle)
+	}
 	fmt.Printf("  %#v\n", rules)
-	if _, ok := client.(*inprocess.InprocessClient); ok {
-		fmt.Printf("  Latency Range: %s < %s, Timeout: %s, Flakiness: %.2f\n", inprocess.LatencyMin, inprocess.LatencyMax, inprocess.Timeout)
+	if faultyClient, ok := client.(*faults.TestClient); ok {
+		fmt.Printf("  Faults: %s\n", faultyClient)
+	}
+
+	///
+
+	failedBids := FailedBids(results)
+	if len(failedBids) > 0 {
+		fmt.Println("Failed Bids")
+		codes := []string{}
+		for code := range failedBids {
+			codes = append(codes, string(code))
+		}
+		sort.Strings(codes)
+		for _, code := range codes {
+			fmt.Printf("  %s: %d\n", code, failedBids[types.ScoreError(code)])
+		}
 	}
 
 	///
@@ -159,4 +190,22 @@ func PrintReport(client types.TestRepPoolClient, results []types.AuctionResult,
 	meanScores = meanScores / float64(len(results))
 	fmt.Printf("  Min: %d | Max: %d | Total: %d | Mean: %.2f\n", minScores, maxScores, totalScores, meanScores)
 
+	///
+
+	fmt.Println("Bytes On The Wire (per auction)")
+	if wire == nil || len(results) == 0 {
+		fmt.Println("  Not measured")
+		return
+	}
+	sent := float64(wire.Sent) / float64(len(results))
+	received := float64(wire.Received) / float64(len(results))
+	fmt.Printf("  Sent: %.0f | Received: %.0f | Total: %.0f\n", sent, received, sent+received)
+
+	asJSON := float64(wire.SentAsJSON+wire.ReceivedAsJSON) / float64(len(results))
+	saved := float64(wire.Saved()) / float64(len(results))
+	savedPercent := 0.0
+	if asJSON > 0 {
+		savedPercent = 100 * saved / asJSON
+	}
+	fmt.Printf("  As JSON: %.0f | Saved: %.0f (%.1f%%)\n", asJSON, saved, savedPercent)
 }
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/GaryBoone/GoStats/stats"
+	"github.com/onsi/auction/communication"
 	"github.com/onsi/auction/types"
 )
 
@@ -12,7 +13,9 @@ type Report struct {
 	RepGuids                     []string
 	AuctionResults               []types.AuctionResult
 	InstancesByRep               map[string][]types.Instance
+	UnresponsiveReps             []string
 	AuctionDuration              time.Duration
+	WireBytes                    *communication.WireBytes
 	auctionedInstancesByInstGuid map[string]bool
 }
 
@@ -53,6 +56,10 @@ func (r *Report) NReps() int {
 	return len(r.RepGuids)
 }
 
+func (r *Report) NUnresponsiveReps() int {
+	return len(r.UnresponsiveReps)
+}
+
 func (r *Report) NMissingInstances() int {
 	numRunningThatWereAuctioned := 0
 	for _, instances := range r.InstancesByRep {
@@ -129,15 +136,34 @@ func (r *Report) WaitTimeStats() Stat {
 	return NewStat(waitTimes)
 }
 
-func FetchAndSortInstances(client types.TestRepPoolClient, repGuids []string) map[string][]types.Instance {
+// FailedBids totals the bids that failed across the auctions, by error.
+func FailedBids(results []types.AuctionResult) map[types.ScoreError]int {
+	failed := map[types.ScoreError]int{}
+	for _, result := range results {
+		for code, count := range result.FailedBids {
+			failed[code] += count
+		}
+	}
+
+	return failed
+}
+
+// FetchAndSortInstances also returns the reps that failed to report their
+// instances; those reps are left out of the map.
+func FetchAndSortInstances(client types.TestRepPoolClient, repGuids []string) (map[string][]types.Instance, []string) {
 	instancesByRepGuid := map[string][]types.Instance{}
+	unresponsiveReps := []string{}
 	for _, guid := range repGuids {
-		instances := client.Instances(guid)
+		instances, err := client.Instances(guid)
+		if err != nil {
+			unresponsiveReps = append(unresponsiveReps, guid)
+			continue
+		}
 		sort.Sort(ByAppGuid(instances))
 		instancesByRepGuid[guid] = instances
 	}
 
-	return instancesByRepGuid
+	return instancesByRepGuid, unresponsiveReps
 }
 
 type ByAppGuid []types.Instance
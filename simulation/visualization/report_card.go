@@ -153,6 +153,9 @@ func (r *SVGReport) drawText(report *Report, y int) {
 	if missingInstances > 0 {
 		missing = fmt.Sprintf("MISSING %d (%.2f%%)", missingInstances, float64(missingInstances)/float64(report.NAuctions())*100)
 	}
+	if report.NUnresponsiveReps() > 0 {
+		missing += fmt.Sprintf(" UNRESPONSIVE %d", report.NUnresponsiveReps())
+	}
 
 	lines := []string{
 		fmt.Sprintf("%d over %d Reps %s", report.NAuctions(), report.NReps(), missing),
@@ -0,0 +1,72 @@
+package types
+
+// A ScoreError says why a rep didn't bid.  It travels as the "e" field of a
+// ScoreResult, so the codes that predate it keep the text they always had and
+// peers that only check for an empty error understand every code.  Codes a
+// peer doesn't know are kept as they are.
+//
+// ScoreErrors are errors, so reps and their delegates return them directly.
+type ScoreError string
+
+const (
+	// InsufficientResources is what reps said before they said which resource
+	// ran out, and what delegates may still say.
+	InsufficientResources  ScoreError = "insufficient resources for instance"
+	InsufficientMemory     ScoreError = "insufficient memory for instance"
+	InsufficientDisk       ScoreError = "insufficient disk for instance"
+	InsufficientContainers ScoreError = "insufficient containers for instance"
+
+	// ConstraintMismatch is for delegates that can't run the instance at all,
+	// however much room they have.
+	ConstraintMismatch ScoreError = "rep can't run instance"
+
+	StaleScore ScoreError = "rep state changed since scoring"
+
+	// Draining reps are shutting down and won't take on more work.
+	Draining ScoreError = "rep is draining"
+
+	// RepBusy is the error a rep bids with when it has too many bids in hand
+	// to take on another.  It says nothing about whether the rep has room for
+	// the instance, so the rep shouldn't be taken for full.
+	RepBusy ScoreError = "rep is busy"
+
+	// Timeout and TransportFailure are reported by clients for reps that never
+	// answered; the result's message says what went wrong in transit.
+	Timeout          ScoreError = "timeout"
+	TransportFailure ScoreError = "transport error"
+
+	// RequestFailed is reported by clients for reps that couldn't handle the
+	// request at all.
+	RequestFailed ScoreError = "request failed"
+)
+
+func (e ScoreError) Error() string {
+	return string(e)
+}
+
+// Insufficient is true of the errors of reps that are too full for the
+// instance.
+func (e ScoreError) Insufficient() bool {
+	switch e {
+	case InsufficientResources, InsufficientMemory, InsufficientDisk, InsufficientContainers:
+		return true
+	}
+
+	return false
+}
+
+// Unanswered is true of the errors clients report for reps that timed out or
+// couldn't be reached, as opposed to reps that answered with an error.
+func (e ScoreError) Unanswered() bool {
+	return e == Timeout || e == TransportFailure
+}
+
+// ScoreErrorFor is the code for err, and a message to go with it when the code
+// alone doesn't say everything err does.
+func ScoreErrorFor(err error) (ScoreError, string) {
+	if code, ok := err.(ScoreError); ok {
+		return code, ""
+	}
+
+	return RequestFailed, err.Error()
+}
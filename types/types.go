@@ -1,11 +1,6 @@
 package types
 
-import (
-	"errors"
-	"time"
-)
-
-var InsufficientResources = errors.New("insufficient resources for instance")
+import "time"
 
 type AuctionRequest struct {
 	Instance Instance     `json:"i"`
@@ -20,24 +15,41 @@ type AuctionResult struct {
 	NumCommunications int           `json:"nc"`
 	BiddingDuration   time.Duration `json:"bd"`
 	Duration          time.Duration `json:"d"`
+
+	// FailedBids counts the bids that failed during the auction, by error.
+	FailedBids map[ScoreError]int `json:"fb,omitempty"`
 }
 
 type AuctionRules struct {
 	Algorithm      string  `json:"alg"`
 	MaxRounds      int     `json:"mr"`
 	MaxBiddingPool float64 `json:"mb"`
+
+	// ScoreQuorum, a fraction of the reps asked to bid in a round, lets the
+	// round go on once that many have bid rather than waiting for them all.
+	// HedgeAfter asks other reps in the pool to bid as well when bids are
+	// slower than that.  Zero turns either off.
+	ScoreQuorum float64       `json:"sq,omitempty"`
+	HedgeAfter  time.Duration `json:"ha,omitempty"`
 }
 
 type RepGuids []string
 
 type ScoreResult struct {
-	Rep   string  `json:"r"`
-	Score float64 `json:"s"`
-	Error string  `json:"e"`
+	Rep        string     `json:"r"`
+	Score      float64    `json:"s"`
+	Generation uint64     `json:"g,omitempty"`
+	Error      ScoreError `json:"e"`
+	Message    string     `json:"em,omitempty"`
 }
 
 type ScoreResults []ScoreResult
 
+type ReserveIfUnchangedRequest struct {
+	Instance   Instance `json:"i"`
+	Generation uint64   `json:"g"`
+}
+
 type Resources struct {
 	DiskMB     float64 `json:"d"`
 	MemoryMB   float64 `json:"m"`
@@ -53,15 +65,35 @@ type Instance struct {
 type RepPoolClient interface {
 	Score(guids []string, instance Instance) ScoreResults
 	ScoreThenTentativelyReserve(guids []string, instance Instance) ScoreResults
+	TentativelyReserveIfUnchanged(scores ScoreResults, instance Instance) ScoreResults
 	ReleaseReservation(guids []string, instance Instance)
 	Claim(guid string, instance Instance)
 }
 
+// A BroadcastRepPoolClient can ask the whole pool for scores with a single
+// message.  BroadcastScore returns as soon as k reps have scored the instance,
+// or with whatever has arrived when the client times out.  Errors (full reps,
+// say) are returned alongside the scores but don't count towards k.
+type BroadcastRepPoolClient interface {
+	RepPoolClient
+
+	BroadcastScore(k int, instance Instance) ScoreResults
+}
+
+// A FilteringRepPoolClient knows that some reps aren't worth asking to bid
+// right now (because they keep timing out, say).  Auctions are held among the
+// reps it makes Available.
+type FilteringRepPoolClient interface {
+	RepPoolClient
+
+	Available(guids RepGuids) RepGuids
+}
+
 type TestRepPoolClient interface {
 	RepPoolClient
 
-	TotalResources(guid string) Resources
-	Instances(guid string) []Instance
-	SetInstances(guid string, instances []Instance)
-	Reset(guid string)
+	TotalResources(guid string) (Resources, error)
+	Instances(guid string) ([]Instance, error)
+	SetInstances(guid string, instances []Instance) error
+	Reset(guid string) error
 }
@@ -34,6 +34,18 @@ func (v ScoreResults) FilterErrors() ScoreResults {
 	return out
 }
 
+// Busy picks out the results of reps that were too busy to bid.
+func (v ScoreResults) Busy() ScoreResults {
+	out := ScoreResults{}
+	for _, r := range v {
+		if r.Error == RepBusy {
+			out = append(out, r)
+		}
+	}
+
+	return out
+}
+
 func (v ScoreResults) Shuffle() ScoreResults {
 	out := make(ScoreResults, len(v))
 
@@ -8,16 +8,41 @@ import (
 	"time"
 )
 
+// R is shared by every auction an auctioneer holds concurrently, so its source
+// is locked.
 var R *rand.Rand
 var guidTracker map[string]int
 var lock *sync.Mutex
 
 func init() {
-	R = rand.New(rand.NewSource(time.Now().UnixNano()))
+	R = rand.New(&lockedSource{src: rand.NewSource(time.Now().UnixNano()).(rand.Source64), lock: &sync.Mutex{}})
 	ResetGuids()
 	lock = &sync.Mutex{}
 }
 
+type lockedSource struct {
+	src  rand.Source64
+	lock *sync.Mutex
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Uint64() uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.src.Uint64()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.src.Seed(seed)
+}
+
 func ResetGuids() {
 	guidTracker = map[string]int{}
 }